@@ -1,25 +1,182 @@
 package main
 
 import (
+    "fmt"
     "log"
     "os"
     "os/signal"
+    "path/filepath"
+    "strings"
     "syscall"
-    "your-module/internal/bot"
-    "your-module/internal/config"
-    "your-module/internal/services"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/bot"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/config"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/encryption"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/repository"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
     "github.com/bwmarrin/discordgo"
 )
 
+type stores struct {
+    sessions services.SessionStore
+    prompts  services.PromptStore
+    configs  services.ConfigStore
+    close    func() error
+}
+
+// openStores builds the persistence layer selected by cfg.StorageBackend.
+func openStores(cfg *config.Config) (*stores, error) {
+    switch cfg.StorageBackend {
+    case "sqlite":
+        db, err := repository.Open(filepath.Join(cfg.DataDir, "bot.db"))
+        if err != nil {
+            return nil, err
+        }
+        return &stores{
+            sessions: db.Chats(),
+            prompts:  db.Prompts(),
+            configs:  db.Users(),
+            close:    db.Close,
+        }, nil
+    case "file":
+        sessions, err := services.NewFileSessionStore(filepath.Join(cfg.DataDir, "sessions"))
+        if err != nil {
+            return nil, err
+        }
+        prompts, err := services.NewFilePromptStore(filepath.Join(cfg.DataDir, "prompts"))
+        if err != nil {
+            return nil, err
+        }
+        configs, err := services.NewFileConfigStore(filepath.Join(cfg.DataDir, "configs"))
+        if err != nil {
+            return nil, err
+        }
+        return &stores{
+            sessions: sessions,
+            prompts:  prompts,
+            configs:  configs,
+            close:    func() error { return nil },
+        }, nil
+    default:
+        return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+    }
+}
+
+// encryptStores wraps the sensitive stores with keyring encryption. When the
+// keyring still holds old keys, everything is re-encrypted with the primary
+// key so the old keys can be retired.
+func encryptStores(st *stores, keyring *encryption.Keyring) error {
+    sessions := services.NewEncryptedSessionStore(st.sessions, keyring)
+    prompts := services.NewEncryptedPromptStore(st.prompts, keyring)
+    st.sessions = sessions
+    st.prompts = prompts
+
+    if !keyring.HasOldKeys() {
+        return nil
+    }
+    count, err := sessions.Reencrypt()
+    if err != nil {
+        return fmt.Errorf("re-encrypting sessions: %v", err)
+    }
+    promptCount, err := prompts.Reencrypt()
+    if err != nil {
+        return fmt.Errorf("re-encrypting prompts: %v", err)
+    }
+    log.Printf("Re-encrypted %d records with the primary key", count+promptCount)
+    return nil
+}
+
 func main() {
     // Load configuration
     cfg := config.Load()
 
     // Initialize services
-    openAI := services.NewOpenAIService(cfg.OpenAIKey)
-    promptManager := services.NewPromptManager()
-    chatManager := services.NewChatManager(openAI, promptManager)
-    proxyClient := services.NewProxyClient(cfg.ProxyURL, cfg.ProxyPassword)
+    st, err := openStores(cfg)
+    if err != nil {
+        log.Fatal("Error opening storage:", err)
+    }
+    defer st.close()
+
+    var sealer services.Sealer
+    var keyring *encryption.Keyring
+    if cfg.EncryptionKey != "" {
+        keyring, err = encryption.ParseKeyring(cfg.EncryptionKey, cfg.EncryptionOldKeys)
+        if err != nil {
+            log.Fatal("Error loading encryption keys:", err)
+        }
+        if err := encryptStores(st, keyring); err != nil {
+            log.Fatal("Error enabling encryption:", err)
+        }
+        sealer = keyring
+    } else {
+        log.Println("ENCRYPTION_KEY is not set, user data is stored unencrypted")
+    }
+
+    proxyClient := services.NewProxyClient(cfg.ProxyURL, cfg.ProxyPassword, st.configs)
+    if cfg.InstructTemplate != "" {
+        templates, err := services.LoadInstructTemplates(cfg.InstructTemplatesFile)
+        if err != nil {
+            log.Fatal("Error loading instruct templates:", err)
+        }
+        template, ok := templates[strings.ToLower(cfg.InstructTemplate)]
+        if !ok {
+            log.Fatalf("Unknown instruct template %q", cfg.InstructTemplate)
+        }
+        proxyClient.UseInstructTemplate(template, cfg.CompletionsURL)
+    }
+    openAI := services.NewOpenAIService(cfg.OpenAIKey, proxyClient)
+
+    promptManager := services.NewPromptManager(st.prompts)
+    promptManager.SetContextBudget(cfg.ContextTokens)
+    chatManager := services.NewChatManager(openAI, promptManager, st.sessions)
+
+    // Replay anything journaled since the last snapshot before serving users
+    journal, err := services.OpenJournal(filepath.Join(cfg.DataDir, "journal.log"), sealer)
+    if err != nil {
+        log.Fatal("Error opening journal:", err)
+    }
+    defer journal.Close()
+    if err := chatManager.UseJournal(journal); err != nil {
+        log.Fatal("Error replaying journal:", err)
+    }
+    chatManager.StartCompaction(cfg.JournalCompactInterval)
+
+    janitor := services.NewSessionJanitor(chatManager, cfg.SessionTimeout, cfg.SessionCleanupInterval)
+    janitor.Start()
+    backupManager, err := services.NewBackupManager(filepath.Join(cfg.DataDir, "backups"), promptManager, chatManager, proxyClient, sealer)
+    if err != nil {
+        log.Fatal("Error creating backup manager:", err)
+    }
+    // Manifests are signed with the encryption keys, or with a key kept
+    // next to the backups when encryption is off
+    signer := keyring
+    if signer == nil {
+        signer, err = encryption.LoadOrCreateKeyFile(filepath.Join(cfg.DataDir, "backup-signing.key"), "local")
+        if err != nil {
+            log.Fatal("Error loading backup signing key:", err)
+        }
+    }
+    backupManager.UseSigner(signer)
+    backupManager.SetBotOwners(cfg.BotOwnerIDs)
+    if len(cfg.BotOwnerIDs) == 0 {
+        log.Println("BOT_OWNER_IDS is not set, nobody can back up or restore the whole instance")
+    }
+    if keyring != nil && keyring.HasOldKeys() {
+        count, err := backupManager.Reencrypt()
+        if err != nil {
+            log.Fatal("Error re-encrypting backups:", err)
+        }
+        log.Printf("Re-encrypted %d backups with the primary key", count)
+    }
+    privacyManager, err := services.NewPrivacyManager(filepath.Join(cfg.DataDir, "privacy.json"), promptManager, chatManager, proxyClient, backupManager)
+    if err != nil {
+        log.Fatal("Error creating privacy manager:", err)
+    }
+    privacyManager.StartRetention(cfg.RetentionCheckInterval)
+    guildManager, err := services.NewGuildManager(filepath.Join(cfg.DataDir, "guilds.json"))
+    if err != nil {
+        log.Fatal("Error creating guild manager:", err)
+    }
+    promptManager.UseGuilds(guildManager)
 
     // Create Discord session
     discord, err := discordgo.New("Bot " + cfg.DiscordToken)
@@ -41,7 +198,7 @@ func main() {
     defer discord.Close()
 
     // Initialize bot server
-    botServer := bot.NewServer(discord, promptManager, chatManager, proxyClient)
+    botServer := bot.NewServer(discord, promptManager, chatManager, proxyClient, backupManager, privacyManager, guildManager)
 
     // Add the interaction handler
     discord.AddHandler(botServer.HandleInteractionCreate)
@@ -73,4 +230,9 @@ func main() {
     <-sc
 
     log.Println("Gracefully shutting down...")
+    janitor.Stop()
+    privacyManager.StopRetention()
+    if err := botServer.Stop(); err != nil {
+        log.Println("Error stopping bot server:", err)
+    }
 }
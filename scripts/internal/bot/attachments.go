@@ -0,0 +1,42 @@
+package bot
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "time"
+)
+
+// maxBackupDownloadSize caps backup archives uploaded to /restore.
+const maxBackupDownloadSize = 50 << 20
+
+// maxCardDownloadSize caps character cards uploaded to /import-character
+// and /lorebook import, and avatars uploaded to /export-character.
+const maxCardDownloadSize = 20 << 20
+
+var attachmentClient = &http.Client{
+    Timeout: 30 * time.Second,
+}
+
+// downloadAttachment fetches a Discord attachment, refusing anything larger
+// than maxSize bytes.
+func downloadAttachment(url string, maxSize int64) ([]byte, error) {
+    resp, err := attachmentClient.Get(url)
+    if err != nil {
+        return nil, fmt.Errorf("error downloading attachment: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("error downloading attachment: status %s", resp.Status)
+    }
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+    if err != nil {
+        return nil, fmt.Errorf("error downloading attachment: %v", err)
+    }
+    if int64(len(data)) > maxSize {
+        return nil, fmt.Errorf("attachment is larger than %d bytes", maxSize)
+    }
+    return data, nil
+}
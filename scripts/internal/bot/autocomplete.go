@@ -0,0 +1,191 @@
+package bot
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "github.com/bwmarrin/discordgo"
+)
+
+// maxAutocompleteChoices is the most choices Discord accepts in one
+// autocomplete response.
+const maxAutocompleteChoices = 25
+
+type autocompleteFunc func(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice
+
+func (h *CommandHandler) handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    autocompleteHandlers := map[string]autocompleteFunc{
+        "load-chat":   h.savedChatChoices,
+        "delete-chat": h.savedChatChoices,
+        "character":   h.characterChoices,
+        "lorebook":    h.loreEntryChoices,
+        "prompt":      h.promptChoices,
+        "scenario":    h.scenarioChoices,
+        "new-chat":    h.greetingChoices,
+        "greeting":    h.greetingChoices,
+        "group":       h.characterChoices,
+        "next":        h.groupMemberChoices,
+        "guild-character": h.guildCharacterChoices,
+        "prompt-preview":  h.groupMemberChoices,
+        "persona":         h.personaChoices,
+    }
+
+    data := i.ApplicationCommandData()
+    handler, ok := autocompleteHandlers[data.Name]
+    if !ok {
+        return
+    }
+
+    focused := focusedOption(data.Options)
+    if focused == nil {
+        return
+    }
+
+    choices := handler(i.GuildID, i.Member.User.ID, focused)
+    if len(choices) > maxAutocompleteChoices {
+        choices = choices[:maxAutocompleteChoices]
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+        Data: &discordgo.InteractionResponseData{
+            Choices: choices,
+        },
+    })
+}
+
+func (h *CommandHandler) savedChatChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    chats, err := h.chatManager.ListChats(userID)
+    if err != nil {
+        return nil
+    }
+
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, chat := range chats {
+        if input != "" && !strings.Contains(strings.ToLower(chat.Title), input) && !strings.HasPrefix(chat.ID, input) {
+            continue
+        }
+        name := fmt.Sprintf("%s (%d messages, %s)", chat.Title, chat.MessageCount(), chat.CreatedAt.Format("Jan 2"))
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(name),
+            Value: chat.ID,
+        })
+    }
+    return choices
+}
+
+func (h *CommandHandler) characterChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    characters, activeID := h.promptManager.ListCharacters(userID)
+
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, character := range characters {
+        if input != "" && !strings.Contains(strings.ToLower(character.Name), input) {
+            continue
+        }
+        name := character.Name
+        if character.ID == activeID {
+            name += " (active)"
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(name),
+            Value: character.Name,
+        })
+    }
+    return choices
+}
+
+func (h *CommandHandler) loreEntryChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    book := h.promptManager.GetLorebook(userID)
+
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, entry := range book.Entries {
+        if input != "" && !strings.Contains(strings.ToLower(entry.Name), input) {
+            continue
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(entry.Name),
+            Value: entry.Name,
+        })
+    }
+    return choices
+}
+
+func (h *CommandHandler) promptChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    stack := h.promptManager.PromptStack(userID)
+
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for n, entry := range stack {
+        name := fmt.Sprintf("%d. %s", n+1, promptEntryName(entry))
+        if input != "" && !strings.Contains(strings.ToLower(name), input) {
+            continue
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(name),
+            Value: entry.ID,
+        })
+    }
+    return choices
+}
+
+func (h *CommandHandler) scenarioChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, preset := range h.guilds.Scenarios(guildID) {
+        if input != "" && !strings.Contains(strings.ToLower(preset.Name), input) {
+            continue
+        }
+        name := preset.Name
+        if preset.Builtin {
+            name += " (built-in)"
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(name),
+            Value: preset.Name,
+        })
+    }
+    return choices
+}
+
+// greetingChoices offers the greetings of the user's character by number.
+func (h *CommandHandler) greetingChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for n, greeting := range h.promptManager.Greetings(userID) {
+        name := fmt.Sprintf("%d. %s", n+1, strings.ReplaceAll(greeting, "\n", " "))
+        if input != "" && !strings.Contains(strings.ToLower(name), input) {
+            continue
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(name),
+            Value: strconv.Itoa(n + 1),
+        })
+    }
+    return choices
+}
+
+// focusedOption finds the option the user is typing in, looking inside
+// subcommands and subcommand groups.
+func focusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+    for _, opt := range options {
+        if opt.Focused {
+            return opt
+        }
+        if found := focusedOption(opt.Options); found != nil {
+            return found
+        }
+    }
+    return nil
+}
+
+// truncateChoiceName keeps choice names within Discord's 100 character limit.
+func truncateChoiceName(name string) string {
+    runes := []rune(name)
+    if len(runes) <= 100 {
+        return name
+    }
+    return string(runes[:97]) + "..."
+}
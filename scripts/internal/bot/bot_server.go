@@ -4,7 +4,7 @@ import (
     "log"
     "sync"
     "github.com/bwmarrin/discordgo"
-    "your-module/internal/services"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
 )
 
 type Server struct {
@@ -12,6 +12,9 @@ type Server struct {
     promptManager *services.PromptManager
     chatManager   *services.ChatManager
     proxyClient   *services.ProxyClient
+    backupManager *services.BackupManager
+    privacy       *services.PrivacyManager
+    guilds        *services.GuildManager
     commands      *CommandHandler
     events        *EventHandler
     mu            sync.RWMutex
@@ -22,17 +25,23 @@ func NewServer(
     pm *services.PromptManager,
     cm *services.ChatManager,
     pc *services.ProxyClient,
+    bm *services.BackupManager,
+    privacy *services.PrivacyManager,
+    guilds *services.GuildManager,
 ) *Server {
     server := &Server{
         discord:       discord,
         promptManager: pm,
         chatManager:   cm,
         proxyClient:   pc,
+        backupManager: bm,
+        privacy:       privacy,
+        guilds:        guilds,
     }
 
     // Initialize handlers
-    server.commands = NewCommandHandler(discord, pm, cm, pc)
-    server.events = NewEventHandler(discord, pm, cm, pc)
+    server.commands = NewCommandHandler(discord, pm, cm, pc, bm, privacy, guilds)
+    server.events = NewEventHandler(discord, pm, cm, pc, privacy)
 
     return server
 }
@@ -67,7 +76,10 @@ func (s *Server) Stop() error {
     defer s.mu.Unlock()
 
     // Save any pending data
-    s.chatManager.SaveAllSessions()
+    s.chatManager.StopCompaction()
+    if err := s.chatManager.SaveAllSessions(); err != nil {
+        log.Printf("Error saving sessions: %v", err)
+    }
 
     // Remove commands on shutdown
     for _, cmd := range commands {
@@ -1,12 +1,17 @@
 package bot
 
 import (
+    "bytes"
+    "errors"
     "fmt"
     "log"
-    "time"
+    "math/rand"
+    "strconv"
     "strings"
+    "unicode"
     "github.com/bwmarrin/discordgo"
-    "your-module/internal/services"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/cards"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
 )
 
 type CommandHandler struct {
@@ -14,21 +19,38 @@ type CommandHandler struct {
     promptManager *services.PromptManager
     chatManager   *services.ChatManager
     proxyClient   *services.ProxyClient
+    backupManager *services.BackupManager
+    privacy       *services.PrivacyManager
+    guilds        *services.GuildManager
 }
 
-func NewCommandHandler(d *discordgo.Session, pm *services.PromptManager, cm *services.ChatManager, pc *services.ProxyClient) *CommandHandler {
+func NewCommandHandler(d *discordgo.Session, pm *services.PromptManager, cm *services.ChatManager, pc *services.ProxyClient, bm *services.BackupManager, privacy *services.PrivacyManager, guilds *services.GuildManager) *CommandHandler {
     return &CommandHandler{
         discord:       d,
         promptManager: pm,
         chatManager:   cm,
         proxyClient:   pc,
+        backupManager: bm,
+        privacy:       privacy,
+        guilds:        guilds,
     }
 }
 
+var minRetentionDays = 1.0
+
 var commands = []*discordgo.ApplicationCommand{
     {
         Name: "new-chat",
         Description: "Start a new chat session",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:         discordgo.ApplicationCommandOptionString,
+                Name:         "greeting",
+                Description:  "Greeting to open the chat with, picked at random if left out",
+                Required:     false,
+                Autocomplete: true,
+            },
+        },
     },
     {
         Name: "regenerate",
@@ -60,6 +82,12 @@ var commands = []*discordgo.ApplicationCommand{
                 Description: "Current scenario/setting",
                 Required:    false,
             },
+            {
+                Type:        discordgo.ApplicationCommandOptionString,
+                Name:        "example_dialogue",
+                Description: "Example chats like <START> {{user}}: Hi {{char}}: Hello",
+                Required:    false,
+            },
         },
     },
     {
@@ -86,19 +114,900 @@ var commands = []*discordgo.ApplicationCommand{
             },
         },
     },
+    {
+        Name: "import-character",
+        Description: "Import a SillyTavern/TavernAI character card (JSON or PNG)",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionAttachment,
+                Name:        "card",
+                Description: "Character card file",
+                Required:    true,
+            },
+        },
+    },
+    {
+        Name: "export-character",
+        Description: "Export your character as a Character Card V2",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionString,
+                Name:        "format",
+                Description: "Card format (default PNG)",
+                Required:    false,
+                Choices: []*discordgo.ApplicationCommandOptionChoice{
+                    {Name: "PNG", Value: "png"},
+                    {Name: "JSON", Value: "json"},
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionAttachment,
+                Name:        "avatar",
+                Description: "Image to embed the card in (PNG, JPEG or GIF)",
+                Required:    false,
+            },
+        },
+    },
+    {
+        Name: "character",
+        Description: "Manage your character library",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "create",
+                Description: "Create an empty character and switch to it",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "name",
+                        Description: "Name of the new character",
+                        Required:    true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "List your characters",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "switch",
+                Description: "Switch to another character and its chat",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Character to switch to",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "delete",
+                Description: "Delete a character and its chat",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Character to delete",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "rename",
+                Description: "Rename a character",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Character to rename",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "new-name",
+                        Description: "New name",
+                        Required:    true,
+                    },
+                },
+            },
+        },
+    },
+    {
+        Name: "greeting",
+        Description: "Manage the greetings of your character",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "add",
+                Description: "Add an alternate greeting",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "message",
+                        Description: "Greeting message",
+                        Required:    true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "remove",
+                Description: "Remove a greeting",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "greeting",
+                        Description:  "Greeting to remove",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "List the greetings of your character",
+            },
+        },
+    },
+    {
+        Name: "post-history",
+        Description: "Manage the instructions sent after the latest message",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "set",
+                Description: "Set the post-history instructions of your character",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "instructions",
+                        Description: "Instructions, macros like {{char}} and {{user}} work",
+                        Required:    true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "clear",
+                Description: "Clear the post-history instructions of your character",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "show",
+                Description: "Show which post-history instructions are used",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "guild",
+                Description: "Set this server's default or override instructions (admins)",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "kind",
+                        Description: "Default for characters without any, or override for all",
+                        Required:    true,
+                        Choices: []*discordgo.ApplicationCommandOptionChoice{
+                            {Name: "default", Value: services.PostHistoryDefault},
+                            {Name: "override", Value: services.PostHistoryOverride},
+                        },
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "instructions",
+                        Description: "Instructions, {{original}} is the character's own; leave out to clear",
+                        Required:    false,
+                    },
+                },
+            },
+        },
+    },
+    {
+        Name: "group",
+        Description: "Chat with several of your characters at once",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "start",
+                Description: "Start a group chat",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "characters",
+                        Description: "Names of the characters taking part, separated by commas",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "turn-order",
+                        Description: "Who replies next (default round-robin)",
+                        Required:    false,
+                        Choices:     turnOrderChoices,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "add",
+                Description: "Add a character to the group",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "character",
+                        Description:  "Character to add",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "remove",
+                Description: "Remove a character from the group",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "character",
+                        Description:  "Character to remove",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "turn-order",
+                Description: "Change who replies next",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "order",
+                        Description: "Turn order",
+                        Required:    true,
+                        Choices:     turnOrderChoices,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "show",
+                Description: "Show the group and whose turn it is",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "end",
+                Description: "End the group chat",
+            },
+        },
+    },
+    {
+        Name: "next",
+        Description: "Have a group chat member reply now",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:         discordgo.ApplicationCommandOptionString,
+                Name:         "character",
+                Description:  "Member who replies, the next in turn if left out",
+                Required:     false,
+                Autocomplete: true,
+            },
+        },
+    },
+    {
+        Name: "prompt-preview",
+        Description: "Show the exact request your next reply would send, without sending it",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:         discordgo.ApplicationCommandOptionString,
+                Name:         "character",
+                Description:  "Group chat member to preview, the next in turn if left out",
+                Required:     false,
+                Autocomplete: true,
+            },
+        },
+    },
+    {
+        Name: "persona",
+        Description: "Manage who you play as",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "create",
+                Description: "Create a persona and switch to it",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "name",
+                        Description: "Name characters call you, fills {{user}}",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "description",
+                        Description: "Who you are, sent as your user persona",
+                        Required:    false,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "switch",
+                Description: "Switch to another persona",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Persona to use, none if left out",
+                        Required:     false,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "List your personas",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "delete",
+                Description: "Delete a persona",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Persona to delete",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "bind",
+                Description: "Use a persona automatically with a character or in a channel",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Persona to bind",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "character",
+                        Description:  "Character of yours",
+                        Required:     false,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionChannel,
+                        Name:        "channel",
+                        Description: "Channel",
+                        Required:    false,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "unbind",
+                Description: "Stop using a persona automatically with a character or in a channel",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Persona to unbind",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "character",
+                        Description:  "Character of yours",
+                        Required:     false,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionChannel,
+                        Name:        "channel",
+                        Description: "Channel",
+                        Required:    false,
+                    },
+                },
+            },
+        },
+    },
+    {
+        Name: "guild-character",
+        Description: "Use the characters shared in this server",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "use",
+                Description: "Chat with a shared character, keeping your own chat history",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Shared character to use",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "List the shared characters",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "publish",
+                Description: "Share one of your characters with the server (admins only)",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "character",
+                        Description:  "Character to publish, your active one if left out",
+                        Required:     false,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "unpublish",
+                Description: "Stop sharing a character (admins only)",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Shared character to remove",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+        },
+    },
+    {
+        Name: "scenario",
+        Description: "Apply or manage scenario presets",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "use",
+                Description: "Set your scenario from a preset",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Preset to apply",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "List the scenario presets of this server",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "add",
+                Description: "Add or replace a scenario preset for this server (admins)",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "name",
+                        Description: "Name of the preset",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "scenario",
+                        Description: "Scenario text",
+                        Required:    true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "remove",
+                Description: "Remove a scenario preset from this server (admins)",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Preset to remove",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+        },
+    },
+    {
+        Name: "prompt",
+        Description: "Edit the ordered stack of prompts sent before the chat",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "add",
+                Description: "Add a prompt to your stack",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "role",
+                        Description: "Role the prompt is sent as",
+                        Required:    true,
+                        Choices: []*discordgo.ApplicationCommandOptionChoice{
+                            {Name: "System", Value: "system"},
+                            {Name: "User", Value: "user"},
+                            {Name: "Assistant", Value: "assistant"},
+                        },
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "content",
+                        Description: "Prompt text; macros like {{char}} and {{user}} are expanded",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "position",
+                        Description: "Position in the stack, starting at 1 (default: last)",
+                        MinValue:    &[]float64{1.0}[0],
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "move",
+                Description: "Move a prompt or built-in block",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "prompt",
+                        Description:  "Prompt to move",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "position",
+                        Description: "New position, starting at 1",
+                        Required:    true,
+                        MinValue:    &[]float64{1.0}[0],
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "edit",
+                Description: "Change a prompt, or enable or disable a built-in block",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "prompt",
+                        Description:  "Prompt to change",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "content",
+                        Description: "New prompt text",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "role",
+                        Description: "New role",
+                        Choices: []*discordgo.ApplicationCommandOptionChoice{
+                            {Name: "System", Value: "system"},
+                            {Name: "User", Value: "user"},
+                            {Name: "Assistant", Value: "assistant"},
+                        },
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "enabled",
+                        Description: "Whether the prompt is sent",
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "remove",
+                Description: "Remove one of your prompts",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "prompt",
+                        Description:  "Prompt to remove",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "Show your prompt stack in order",
+            },
+        },
+    },
+    {
+        Name: "lorebook",
+        Description: "Manage world info that is added to the chat when its keywords come up",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "add",
+                Description: "Add a lorebook entry",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "name",
+                        Description: "Name of the entry",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "keys",
+                        Description: "Comma separated trigger keys; write /pattern/i for a regex",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "content",
+                        Description: "Text added to the context",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "secondary-keys",
+                        Description: "Comma separated keys, one of which must also match when selective",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "position",
+                        Description: "Where the entry is inserted",
+                        Choices: []*discordgo.ApplicationCommandOptionChoice{
+                            {Name: "Before the character definitions", Value: services.LorePositionBefore},
+                            {Name: "After the character definitions", Value: services.LorePositionAfter},
+                            {Name: "At a depth in the chat", Value: services.LorePositionDepth},
+                        },
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "depth",
+                        Description: "Messages from the end of the chat, for the depth position",
+                        MinValue:    &[]float64{0.0}[0],
+                        MaxValue:    services.MaxAuthorsNoteDepth,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "order",
+                        Description: "Insertion order; higher orders win when the token budget runs out",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "case-sensitive",
+                        Description: "Match keys case sensitively",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "selective",
+                        Description: "Also require one of the secondary keys",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "constant",
+                        Description: "Always insert the entry",
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "edit",
+                Description: "Change a lorebook entry",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Entry to change",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "new-name",
+                        Description: "New name",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "keys",
+                        Description: "Comma separated trigger keys; write /pattern/i for a regex",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "content",
+                        Description: "Text added to the context",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "secondary-keys",
+                        Description: "Comma separated keys, one of which must also match when selective",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionString,
+                        Name:        "position",
+                        Description: "Where the entry is inserted",
+                        Choices: []*discordgo.ApplicationCommandOptionChoice{
+                            {Name: "Before the character definitions", Value: services.LorePositionBefore},
+                            {Name: "After the character definitions", Value: services.LorePositionAfter},
+                            {Name: "At a depth in the chat", Value: services.LorePositionDepth},
+                        },
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "depth",
+                        Description: "Messages from the end of the chat, for the depth position",
+                        MinValue:    &[]float64{0.0}[0],
+                        MaxValue:    services.MaxAuthorsNoteDepth,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "order",
+                        Description: "Insertion order; higher orders win when the token budget runs out",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "case-sensitive",
+                        Description: "Match keys case sensitively",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "selective",
+                        Description: "Also require one of the secondary keys",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "constant",
+                        Description: "Always insert the entry",
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "enabled",
+                        Description: "Turn the entry on or off",
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "remove",
+                Description: "Remove a lorebook entry",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Entry to remove",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "show",
+                Description: "Show a lorebook entry",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:         discordgo.ApplicationCommandOptionString,
+                        Name:         "name",
+                        Description:  "Entry to show",
+                        Required:     true,
+                        Autocomplete: true,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "list",
+                Description: "List your lorebook entries",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "settings",
+                Description: "Show or change how the lorebook is scanned",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "scan-depth",
+                        Description: "How many recent messages are searched for keys",
+                        MinValue:    &[]float64{1.0}[0],
+                        MaxValue:    services.MaxLoreScanDepth,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "token-budget",
+                        Description: "Most tokens of entries added to one request",
+                        MinValue:    &[]float64{1.0}[0],
+                        MaxValue:    services.MaxLoreTokenBudget,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "import",
+                Description: "Import SillyTavern world info or a card's character book",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionAttachment,
+                        Name:        "file",
+                        Description: "World info JSON, or a character card with a character book",
+                        Required:    true,
+                    },
+                    {
+                        Type:        discordgo.ApplicationCommandOptionBoolean,
+                        Name:        "replace",
+                        Description: "Replace your lorebook instead of adding to it",
+                    },
+                },
+            },
+        },
+    },
     {
         Name: "save-chat",
         Description: "Save current chat history",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionString,
+                Name:        "title",
+                Description: "Title for the saved chat",
+                Required:    false,
+                MaxLength:   80,
+            },
+        },
     },
     {
         Name: "load-chat",
         Description: "Load a saved chat history",
         Options: []*discordgo.ApplicationCommandOption{
             {
-                Type:        discordgo.ApplicationCommandOptionString,
-                Name:        "chat-id",
-                Description: "ID of saved chat",
-                Required:    true,
+                Type:         discordgo.ApplicationCommandOptionString,
+                Name:         "chat-id",
+                Description:  "ID of saved chat",
+                Required:     true,
+                Autocomplete: true,
+            },
+        },
+    },
+    {
+        Name: "list-chats",
+        Description: "List your saved chats",
+    },
+    {
+        Name: "delete-chat",
+        Description: "Delete a saved chat",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:         discordgo.ApplicationCommandOptionString,
+                Name:         "chat-id",
+                Description:  "ID of saved chat",
+                Required:     true,
+                Autocomplete: true,
             },
         },
     },
@@ -170,6 +1079,23 @@ var commands = []*discordgo.ApplicationCommand{
                 Description: "Author's note content",
                 Required:    true,
             },
+            {
+                Type:        discordgo.ApplicationCommandOptionInteger,
+                Name:        "depth",
+                Description: "How many messages from the end to insert the note (0 = after the latest)",
+                MinValue:    &[]float64{0.0}[0],
+                MaxValue:    services.MaxAuthorsNoteDepth,
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionString,
+                Name:        "role",
+                Description: "Role the note is sent as",
+                Choices: []*discordgo.ApplicationCommandOptionChoice{
+                    {Name: "System", Value: "system"},
+                    {Name: "User", Value: "user"},
+                    {Name: "Assistant", Value: "assistant"},
+                },
+            },
         },
     },
     {
@@ -187,6 +1113,18 @@ var commands = []*discordgo.ApplicationCommand{
     {
         Name: "backup",
         Description: "Create backup of all user settings and chat data",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionString,
+                Name:        "scope",
+                Description: "What to back up (instance is for bot owners only)",
+                Required:    false,
+                Choices: []*discordgo.ApplicationCommandOptionChoice{
+                    {Name: "My data", Value: services.BackupScopeUser},
+                    {Name: "Whole instance", Value: services.BackupScopeInstance},
+                },
+            },
+        },
     },
     {
         Name: "restore",
@@ -196,10 +1134,63 @@ var commands = []*discordgo.ApplicationCommand{
                 Type:        discordgo.ApplicationCommandOptionString,
                 Name:        "backup-id",
                 Description: "Backup identifier to restore from",
+                Required:    false,
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionAttachment,
+                Name:        "file",
+                Description: "Backup archive to restore from",
+                Required:    false,
+            },
+        },
+    },
+    {
+        Name: "my-data",
+        Description: "Download everything the bot stores about you",
+    },
+    {
+        Name: "forget-me",
+        Description: "Permanently delete everything the bot stores about you",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionBoolean,
+                Name:        "confirm",
+                Description: "Set to true to confirm, this cannot be undone",
                 Required:    true,
             },
         },
     },
+    {
+        Name: "retention",
+        Description: "Manage how long this server's chat history is kept (admin only)",
+        Options: []*discordgo.ApplicationCommandOption{
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "set",
+                Description: "Delete chat history older than the given number of days",
+                Options: []*discordgo.ApplicationCommandOption{
+                    {
+                        Type:        discordgo.ApplicationCommandOptionInteger,
+                        Name:        "days",
+                        Description: "Days of history to keep",
+                        Required:    true,
+                        MinValue:    &minRetentionDays,
+                        MaxValue:    services.MaxRetentionDays,
+                    },
+                },
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "show",
+                Description: "Show the current retention policy",
+            },
+            {
+                Type:        discordgo.ApplicationCommandOptionSubCommand,
+                Name:        "clear",
+                Description: "Keep chat history until users delete it",
+            },
+        },
+    },
     {
         Name: "switch-model",
         Description: "Switch between different AI models",
@@ -228,9 +1219,21 @@ func (h *CommandHandler) RegisterCommands() {
 }
 
 func (h *CommandHandler) HandleInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+        h.handleAutocomplete(s, i)
+        return
+    }
+    if i.Type == discordgo.InteractionMessageComponent {
+        h.handleComponent(s, i)
+        return
+    }
     if i.Type != discordgo.InteractionApplicationCommand {
         return
     }
+    if i.Member != nil {
+        h.privacy.TrackUser(i.GuildID, i.Member.User.ID)
+        h.promptManager.TrackCommand(i.Member.User.ID, i.GuildID, i.ChannelID, displayName(i.Member, i.Member.User))
+    }
 
     commandHandlers := map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
         "new-chat":          h.handleNewChat,
@@ -239,8 +1242,23 @@ func (h *CommandHandler) HandleInteractionCreate(s *discordgo.Session, i *discor
         "set-definitions":   h.handleSetDefinitions,
         "set-userpersona":   h.handleSetUserPersona,
         "set-usertoken":     h.handleSetUserToken,
+        "import-character":  h.handleImportCharacter,
+        "export-character":  h.handleExportCharacter,
+        "character":         h.handleCharacter,
+        "lorebook":          h.handleLorebook,
+        "prompt":            h.handlePrompt,
+        "scenario":          h.handleScenario,
+        "greeting":          h.handleGreeting,
+        "post-history":      h.handlePostHistory,
+        "group":             h.handleGroup,
+        "next":              h.handleNext,
+        "guild-character":   h.handleGuildCharacter,
+        "persona":           h.handlePersona,
+        "prompt-preview":    h.handlePromptPreview,
         "save-chat":         h.handleSaveChat,
         "load-chat":         h.handleLoadChat,
+        "list-chats":        h.handleListChats,
+        "delete-chat":       h.handleDeleteChat,
         "toggle-stream":     h.handleToggleStream,
         "set-temperature":   h.handleSetTemperature,
         "help":             h.handleHelp,
@@ -254,6 +1272,9 @@ func (h *CommandHandler) HandleInteractionCreate(s *discordgo.Session, i *discor
         "ping":             h.handlePing,
         "backup":           h.handleBackup,
         "restore":          h.handleRestore,
+        "my-data":          h.handleMyData,
+        "forget-me":        h.handleForgetMe,
+        "retention":        h.handleRetention,
         "switch-model":     h.handleSwitchModel,
     }
 
@@ -264,13 +1285,48 @@ func (h *CommandHandler) HandleInteractionCreate(s *discordgo.Session, i *discor
 
 func (h *CommandHandler) handleNewChat(s *discordgo.Session, i *discordgo.InteractionCreate) {
     userID := i.Member.User.ID
-    h.chatManager.CreateNewChat(userID)
-    
+    if group, members := h.promptManager.Group(userID); group.Active() {
+        h.chatManager.CreateNewChat(userID)
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: fmt.Sprintf("New group chat started with %s! 🌟", memberNames(members)),
+            },
+        })
+        return
+    }
+    greetings := h.promptManager.Greetings(userID)
+
+    // Pick the requested greeting, or one at random
+    greeting := 0
+    if options := i.ApplicationCommandData().Options; len(options) > 0 {
+        n, err := strconv.Atoi(strings.TrimSpace(options[0].StringValue()))
+        if err != nil || n < 1 || n > len(greetings) {
+            s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+                Type: discordgo.InteractionResponseChannelMessageWithSource,
+                Data: &discordgo.InteractionResponseData{
+                    Content: fmt.Sprintf("❌ %v", services.ErrGreetingNotFound),
+                    Flags:   discordgo.MessageFlagsEphemeral,
+                },
+            })
+            return
+        }
+        greeting = n - 1
+    } else if len(greetings) > 1 {
+        greeting = rand.Intn(len(greetings))
+    }
+    h.chatManager.CreateNewChatWithGreeting(userID, greeting)
+
     response := "New chat session started! 🌟"
+    var components []discordgo.MessageComponent
+    if history := h.chatManager.GetChatHistory(userID); len(greetings) > 0 && len(history) > 0 {
+        response, components = greetingMessage(userID, history[len(history)-1].Content, greeting, len(greetings))
+    }
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: response,
+            Content:    response,
+            Components: components,
         },
     })
 }
@@ -346,92 +1402,375 @@ func (h *CommandHandler) handleSwitchModel(s *discordgo.Session, i *discordgo.In
     h.proxyClient.SwitchModel(userID, model)
     
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: fmt.Sprintf("🔄 Switched to %s model", model),
+        },
+    })
+}
+
+func (h *CommandHandler) handleContinue(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+    })
+
+    response := h.chatManager.ContinueChat(userID)
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+    })
+}
+
+func (h *CommandHandler) handleSetUserPersona(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    persona := ""
+    if len(i.ApplicationCommandData().Options) > 0 {
+        persona = i.ApplicationCommandData().Options[0].StringValue()
+    }
+    
+    h.promptManager.SetUserPersona(userID, persona)
+    
+    response := "User persona updated! ✨"
+    if persona == "" {
+        response = "User persona cleared! 🔄"
+    }
+    
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+        },
+    })
+}
+
+func (h *CommandHandler) handleSetUserToken(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    token := i.ApplicationCommandData().Options[0].StringValue()
+    
+    h.promptManager.SetUserToken(userID, token)
+    
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: "🔑 User token set successfully!",
+        },
+    })
+}
+
+func (h *CommandHandler) handleImportCharacter(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    data := i.ApplicationCommandData()
+
+    attachment, ok := data.Resolved.Attachments[data.Options[0].Value.(string)]
+    if !ok {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Attach a character card file",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: fmt.Sprintf("🔄 Switched to %s model", model),
+            Flags: discordgo.MessageFlagsEphemeral,
         },
     })
+
+    var card *cards.Card
+    raw, err := downloadAttachment(attachment.URL, maxCardDownloadSize)
+    if err == nil {
+        card, err = cards.Parse(raw)
+    }
+
+    response := ""
+    switch {
+    case err == nil:
+        previous, next, unmapped := h.promptManager.ImportCharacter(userID, card)
+        response = fmt.Sprintf("🎭 Imported **%s** (card V%d) and made it your character. Use `/new-chat` to start chatting with them.",
+            next.Name, card.SourceVersion)
+        if len(unmapped) > 0 {
+            response += "\nThese fields could not be imported: `" + strings.Join(unmapped, "`, `") + "`"
+        }
+        if switchErr := h.chatManager.SwitchCharacter(userID, previous, next); switchErr != nil {
+            log.Printf("Error switching chat history for %s: %v", userID, switchErr)
+            response += "\n⚠️ The chat history could not be switched, use `/new-chat` to start fresh"
+        }
+    case errors.Is(err, cards.ErrUnsupportedSpec), errors.Is(err, cards.ErrInvalidCard), errors.Is(err, cards.ErrNoCardChunk):
+        response = fmt.Sprintf("❌ %v", err)
+    default:
+        log.Printf("Error importing character for %s: %v", userID, err)
+        response = "❌ Could not read the character card"
+    }
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+    })
 }
 
-func (h *CommandHandler) handleContinue(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) handleExportCharacter(s *discordgo.Session, i *discordgo.InteractionCreate) {
     userID := i.Member.User.ID
-    
+    data := i.ApplicationCommandData()
+
+    format := "png"
+    avatarURL := ""
+    for _, opt := range data.Options {
+        switch opt.Name {
+        case "format":
+            format = opt.StringValue()
+        case "avatar":
+            if attachment, ok := data.Resolved.Attachments[opt.Value.(string)]; ok {
+                avatarURL = attachment.URL
+            }
+        }
+    }
+
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Flags: discordgo.MessageFlagsEphemeral,
+        },
     })
 
-    response := h.chatManager.ContinueChat(userID)
+    card := h.promptManager.ExportCharacter(userID)
+    var file *discordgo.File
+    var err error
+    if format == "json" {
+        var encoded []byte
+        encoded, err = card.JSON()
+        file = &discordgo.File{
+            Name:        cardFileName(card.Data.Name) + ".json",
+            ContentType: "application/json",
+            Reader:      bytes.NewReader(encoded),
+        }
+    } else {
+        avatar := cards.DefaultAvatar(card.Data.Name)
+        if avatarURL != "" {
+            avatar, err = downloadAttachment(avatarURL, maxCardDownloadSize)
+        }
+        var encoded []byte
+        if err == nil {
+            encoded, err = cards.EmbedPNG(avatar, card)
+        }
+        file = &discordgo.File{
+            Name:        cardFileName(card.Data.Name) + ".png",
+            ContentType: "image/png",
+            Reader:      bytes.NewReader(encoded),
+        }
+    }
+
+    if err != nil {
+        log.Printf("Error exporting character for %s: %v", userID, err)
+        response := fmt.Sprintf("❌ Could not export the character: %v", err)
+        s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+            Content: &response,
+        })
+        return
+    }
+
+    response := fmt.Sprintf("🎭 Here is **%s** as a Character Card V2", card.Data.Name)
     s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
         Content: &response,
+        Files:   []*discordgo.File{file},
     })
 }
 
-func (h *CommandHandler) handleSetUserPersona(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// cardFileName turns a character name into a safe file name.
+func cardFileName(name string) string {
+    cleaned := strings.Map(func(r rune) rune {
+        if r == ' ' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return r
+        }
+        return -1
+    }, name)
+    cleaned = strings.TrimSpace(cleaned)
+    if cleaned == "" {
+        return "character"
+    }
+    return cleaned
+}
+
+func (h *CommandHandler) handleCharacter(s *discordgo.Session, i *discordgo.InteractionCreate) {
     userID := i.Member.User.ID
-    persona := ""
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt.StringValue()
+    }
+
+    response := ""
+    var err error
+    switch sub.Name {
+    case "create", "switch":
+        var previous, next services.Character
+        if sub.Name == "create" {
+            previous, next, err = h.promptManager.CreateCharacter(userID, options["name"])
+        } else {
+            previous, next, err = h.promptManager.SwitchCharacter(userID, options["name"])
+        }
+        if err != nil {
+            break
+        }
+        response = fmt.Sprintf("🎭 Now chatting as **%s**", next.Name)
+        if sub.Name == "create" {
+            response = fmt.Sprintf("🎭 Created **%s**. Fill it in with `/set-definitions`.", next.Name)
+        }
+        if switchErr := h.chatManager.SwitchCharacter(userID, previous, next); switchErr != nil {
+            log.Printf("Error switching chat history for %s: %v", userID, switchErr)
+            response += "\n⚠️ The chat history could not be switched, use `/new-chat` to start fresh"
+        }
+    case "delete":
+        var deleted services.Character
+        deleted, err = h.promptManager.DeleteCharacter(userID, options["name"])
+        if err != nil {
+            break
+        }
+        if deleteErr := h.chatManager.DeleteCharacterHistory(userID, deleted.ID); deleteErr != nil {
+            log.Printf("Error deleting chat history of character %s: %v", deleted.ID, deleteErr)
+        }
+        response = fmt.Sprintf("🗑️ Deleted **%s**", deleted.Name)
+    case "rename":
+        var renamed services.Character
+        renamed, err = h.promptManager.RenameCharacter(userID, options["name"], options["new-name"])
+        if err == nil {
+            response = fmt.Sprintf("✏️ Renamed to **%s**", renamed.Name)
+        }
+    case "list":
+        characters, activeID := h.promptManager.ListCharacters(userID)
+        var sb strings.Builder
+        sb.WriteString("🎭 Your characters:\n")
+        for _, character := range characters {
+            marker := ""
+            if character.ID == activeID {
+                marker = " (active)"
+            }
+            sb.WriteString(fmt.Sprintf("**%s**%s\n", character.Name, marker))
+        }
+        response = sb.String()
+    }
+
+    if err != nil {
+        switch {
+        case errors.Is(err, services.ErrCharacterNotFound), errors.Is(err, services.ErrCharacterExists),
+            errors.Is(err, services.ErrCharacterActive), errors.Is(err, services.ErrCharacterName):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error managing characters for %s: %v", userID, err)
+            response = "❌ Something went wrong, please try again"
+        }
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+func (h *CommandHandler) handleSaveChat(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    title := ""
     if len(i.ApplicationCommandData().Options) > 0 {
-        persona = i.ApplicationCommandData().Options[0].StringValue()
+        title = i.ApplicationCommandData().Options[0].StringValue()
     }
-    
-    h.promptManager.SetUserPersona(userID, persona)
-    
-    response := "User persona updated! ✨"
-    if persona == "" {
-        response = "User persona cleared! 🔄"
+
+    chat, err := h.chatManager.SaveChat(userID, title)
+    if err != nil {
+        log.Printf("Error saving chat for %s: %v", userID, err)
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Could not save chat",
+            },
+        })
+        return
     }
     
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: response,
+            Content: fmt.Sprintf("💾 Chat **%s** saved! ID: `%s` (%d messages)", chat.Title, chat.ID, chat.MessageCount()),
         },
     })
 }
 
-func (h *CommandHandler) handleSetUserToken(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) handleLoadChat(s *discordgo.Session, i *discordgo.InteractionCreate) {
     userID := i.Member.User.ID
-    token := i.ApplicationCommandData().Options[0].StringValue()
+    chatID := i.ApplicationCommandData().Options[0].StringValue()
     
-    h.promptManager.SetUserToken(userID, token)
+    if err := h.chatManager.LoadChat(userID, chatID); err != nil {
+        response := "❌ Chat not found or error loading chat"
+        if errors.Is(err, services.ErrChatNotOwned) {
+            response = "❌ You can only load your own chats"
+        }
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: response,
+            },
+        })
+        return
+    }
     
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: "🔑 User token set successfully!",
+            Content: "📂 Chat loaded successfully!",
         },
     })
 }
 
-func (h *CommandHandler) handleSaveChat(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) handleListChats(s *discordgo.Session, i *discordgo.InteractionCreate) {
     userID := i.Member.User.ID
-    chatID := h.chatManager.SaveChat(userID)
+    chats, err := h.chatManager.ListChats(userID)
+    
+    response := "📂 You have no saved chats. Use `/save-chat` to create one."
+    if err != nil {
+        log.Printf("Error listing chats for %s: %v", userID, err)
+        response = "❌ Could not list saved chats"
+    } else if len(chats) > 0 {
+        var sb strings.Builder
+        sb.WriteString("📂 Your saved chats:\n")
+        for _, chat := range chats {
+            sb.WriteString(fmt.Sprintf("`%s` **%s** - %d messages, saved %s\n",
+                chat.ID, chat.Title, chat.MessageCount(), chat.CreatedAt.Format("2006-01-02 15:04")))
+        }
+        response = sb.String()
+    }
     
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: fmt.Sprintf("💾 Chat saved! ID: `%s`", chatID),
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
         },
     })
 }
 
-func (h *CommandHandler) handleLoadChat(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (h *CommandHandler) handleDeleteChat(s *discordgo.Session, i *discordgo.InteractionCreate) {
     userID := i.Member.User.ID
     chatID := i.ApplicationCommandData().Options[0].StringValue()
     
-    if err := h.chatManager.LoadChat(userID, chatID); err != nil {
-        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-            Type: discordgo.InteractionResponseChannelMessageWithSource,
-            Data: &discordgo.InteractionResponseData{
-                Content: "❌ Chat not found or error loading chat",
-            },
-        })
-        return
+    response := ""
+    chat, err := h.chatManager.DeleteChat(userID, chatID)
+    switch {
+    case err == nil:
+        response = fmt.Sprintf("🗑️ Deleted saved chat **%s**", chat.Title)
+    case errors.Is(err, services.ErrChatNotOwned):
+        response = "❌ You can only delete your own chats"
+    default:
+        response = "❌ Chat not found or error deleting chat"
     }
     
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: "📂 Chat loaded successfully!",
+            Content: response,
         },
     })
 }
@@ -517,9 +1856,27 @@ func (h *CommandHandler) handleHelp(s *discordgo.Session, i *discordgo.Interacti
         "`/continue` - Continue from last message\n" +
         "`/set-definitions` - Set bot personality\n" +
         "`/set-userpersona` - Set your character\n" +
+        "`/persona` - Create, switch and bind personas you play as\n" +
+        "`/import-character` - Import a Tavern character card\n" +
+        "`/export-character` - Export your character as a card\n" +
+        "`/character` - Create, list and switch between characters\n" +
+        "`/group` - Chat with several of your characters at once\n" +
+        "`/next` - Have a group chat member reply now\n" +
+        "`/guild-character` - Use the characters shared in this server\n" +
+        "`/lorebook` - Manage world info triggered by keywords\n" +
+        "`/prompt` - Reorder, add and disable the prompts sent before the chat\n" +
+        "`/scenario` - Apply a scenario preset\n" +
+        "`/greeting` - Add and remove alternate greetings\n" +
+        "`/post-history` - Set instructions sent after the latest message\n" +
+        "`/prompt-preview` - Show the exact request your next reply would send\n" +
         "`/toggle-stream` - Toggle streaming mode\n" +
         "`/save-chat` - Save current chat\n" +
-        "`/load-chat` - Load saved chat"
+        "`/load-chat` - Load saved chat\n" +
+        "`/list-chats` - List your saved chats\n" +
+        "`/delete-chat` - Delete a saved chat\n" +
+        "`/my-data` - Download everything stored about you\n" +
+        "`/forget-me` - Delete everything stored about you\n" +
+        "`/retention` - Set how long chat history is kept (admins)"
 
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -558,15 +1915,41 @@ func (h *CommandHandler) handleSetFirstMessage(s *discordgo.Session, i *discordg
 }
 
 func (h *CommandHandler) handleSetAuthorsNote(s *discordgo.Session, i *discordgo.InteractionCreate) {
-    note := i.ApplicationCommandData().Options[0].StringValue()
     userID := i.Member.User.ID
-    
+    current := h.promptManager.GetUserPrompts(userID)
+    depth, role := current.AuthorsNoteDepth, current.AuthorsNoteRole
+
+    var note string
+    for _, opt := range i.ApplicationCommandData().Options {
+        switch opt.Name {
+        case "note":
+            note = opt.StringValue()
+        case "depth":
+            depth = int(opt.IntValue())
+        case "role":
+            role = opt.StringValue()
+        }
+    }
+    if role == "" {
+        role = "system"
+    }
+
+    if err := h.promptManager.SetAuthorsNotePlacement(userID, depth, role); err != nil {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: fmt.Sprintf("Error: %v", err),
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
     h.promptManager.SetAuthorsNote(userID, note)
-    
+
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: "Author's note updated! 📝",
+            Content: fmt.Sprintf("Author's note updated! 📝 It will be sent as %s, %d messages from the end of the chat.", role, depth),
         },
     })
 }
@@ -584,27 +1967,288 @@ func (h *CommandHandler) handleClearMemory(s *discordgo.Session, i *discordgo.In
 }
 
 func (h *CommandHandler) handleBackup(s *discordgo.Session, i *discordgo.InteractionCreate) {
-    backupID := time.Now().Format("20060102150405")
-    
+    userID := i.Member.User.ID
+    scope := services.BackupScopeUser
+    if len(i.ApplicationCommandData().Options) > 0 {
+        scope = i.ApplicationCommandData().Options[0].StringValue()
+    }
+
+    if scope == services.BackupScopeInstance && !h.backupManager.IsBotOwner(userID) {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Only the bot's owners can back up the whole instance",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: fmt.Sprintf("Backup created! ID: %s 💾", backupID),
+            Flags: discordgo.MessageFlagsEphemeral,
         },
     })
+
+    var info *services.BackupInfo
+    var err error
+    if scope == services.BackupScopeInstance {
+        info, err = h.backupManager.CreateInstanceBackup(userID)
+    } else {
+        info, err = h.backupManager.CreateUserBackup(userID)
+    }
+    if err != nil {
+        log.Printf("Error creating %s backup for %s: %v", scope, userID, err)
+        response := "❌ Backup failed"
+        s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+            Content: &response,
+        })
+        return
+    }
+
+    response := fmt.Sprintf("Backup created! ID: `%s` 💾\nUsers: %d, files: %d, checksum: `%.12s`",
+        info.Manifest.ID, len(info.Manifest.UserIDs), len(info.Manifest.Files), info.Manifest.Checksum)
+    edit := &discordgo.WebhookEdit{
+        Content: &response,
+    }
+    if scope == services.BackupScopeInstance {
+        // Instance archives hold every user's data and stay on the server
+        response += "\nThe archive is kept on the server only."
+        s.InteractionResponseEdit(i.Interaction, edit)
+        return
+    }
+
+    archive, err := h.backupManager.ReadBackup(info.Manifest.ID)
+    if err != nil {
+        log.Printf("Error reading backup %s: %v", info.Manifest.ID, err)
+    }
+    if archive != nil {
+        edit.Files = []*discordgo.File{
+            {
+                Name:        info.Manifest.ID + ".zip",
+                ContentType: "application/zip",
+                Reader:      bytes.NewReader(archive),
+            },
+        }
+    }
+    s.InteractionResponseEdit(i.Interaction, edit)
 }
 
 
 func (h *CommandHandler) handleRestore(s *discordgo.Session, i *discordgo.InteractionCreate) {
-    backupID := i.ApplicationCommandData().Options[0].StringValue()
-    
+    userID := i.Member.User.ID
+    data := i.ApplicationCommandData()
+
+    backupID := ""
+    attachmentURL := ""
+    for _, opt := range data.Options {
+        switch opt.Name {
+        case "backup-id":
+            backupID = opt.StringValue()
+        case "file":
+            if attachment, ok := data.Resolved.Attachments[opt.Value.(string)]; ok {
+                attachmentURL = attachment.URL
+            }
+        }
+    }
+
+    if backupID == "" && attachmentURL == "" {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Give a backup ID or attach a backup archive",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Flags: discordgo.MessageFlagsEphemeral,
+        },
+    })
+
+    var archive []byte
+    var err error
+    if attachmentURL != "" {
+        archive, err = downloadAttachment(attachmentURL, maxBackupDownloadSize)
+    } else {
+        archive, err = h.backupManager.ReadBackup(backupID)
+    }
+
+    var manifest *services.BackupManifest
+    if err == nil {
+        manifest, err = h.backupManager.Restore(userID, archive)
+    }
+
+    response := ""
+    switch {
+    case err == nil:
+        response = fmt.Sprintf("Restored from backup: `%s` 📥 (%d users)", manifest.ID, len(manifest.UserIDs))
+    case errors.Is(err, services.ErrBackupNotFound):
+        response = "❌ Backup not found"
+    case errors.Is(err, services.ErrBackupForbidden):
+        response = "❌ You are not allowed to restore this backup"
+    case errors.Is(err, services.ErrBackupInvalid):
+        response = fmt.Sprintf("❌ %v", err)
+    default:
+        log.Printf("Error restoring backup for %s: %v", userID, err)
+        response = "❌ Restore failed, nothing was changed"
+    }
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+    })
+}
+
+func (h *CommandHandler) handleMyData(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Flags: discordgo.MessageFlagsEphemeral,
+        },
+    })
+
+    manifest, archive, err := h.backupManager.CreateDataExport(userID)
+    if err != nil {
+        log.Printf("Error exporting data for %s: %v", userID, err)
+        response := "❌ Could not export your data"
+        s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+            Content: &response,
+        })
+        return
+    }
+
+    response := "📦 Here is everything stored about you. See README.md inside; " +
+        "attach the zip to `/restore` to import it on another instance."
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+        Files: []*discordgo.File{
+            {
+                Name:        manifest.ID + ".zip",
+                ContentType: "application/zip",
+                Reader:      bytes.NewReader(archive),
+            },
+        },
+    })
+}
+
+func (h *CommandHandler) handleForgetMe(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    if !i.ApplicationCommandData().Options[0].BoolValue() {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "Nothing was deleted. Run `/forget-me confirm:True` to delete your data.",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Flags: discordgo.MessageFlagsEphemeral,
+        },
+    })
+
+    report, err := h.privacy.ForgetUser(userID)
+    response := formatForgetReport(report)
+    if err != nil {
+        log.Printf("Error forgetting user %s: %v", userID, err)
+        response = "❌ Deleting your data did not finish. This much was removed, run `/forget-me` again to retry:\n" + response
+    }
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+    })
+}
+
+func formatForgetReport(report *services.ForgetReport) string {
+    removed := func(ok bool) string {
+        if ok {
+            return "deleted"
+        }
+        return "none stored"
+    }
+
+    var sb strings.Builder
+    sb.WriteString("🧹 Your data has been deleted:\n")
+    sb.WriteString(fmt.Sprintf("Prompts and token: %s\n", removed(report.Prompts)))
+    sb.WriteString(fmt.Sprintf("Model settings: %s\n", removed(report.Config)))
+    sb.WriteString(fmt.Sprintf("Active chat: %s\n", removed(report.Session)))
+    sb.WriteString(fmt.Sprintf("Saved chats: %d deleted\n", report.SavedChats))
+    sb.WriteString(fmt.Sprintf("Backups: %d deleted, removed from %d server backups\n", report.Backups, report.RewrittenBackups))
+    sb.WriteString("Backup files you downloaded yourself are not affected.")
+    return sb.String()
+}
+
+func (h *CommandHandler) handleRetention(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    if i.GuildID == "" || !isAdmin(i) {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Only server admins can manage retention",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+
+    response := ""
+    switch sub.Name {
+    case "set":
+        policy, err := h.privacy.SetRetention(i.GuildID, userID, int(sub.Options[0].IntValue()))
+        switch {
+        case err == nil:
+            response = fmt.Sprintf("🗓️ Chat history older than %d days will now be deleted", policy.Days)
+        case errors.Is(err, services.ErrInvalidRetention):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error setting retention for guild %s: %v", i.GuildID, err)
+            response = "❌ Could not save the retention policy"
+        }
+    case "clear":
+        cleared, err := h.privacy.ClearRetention(i.GuildID)
+        switch {
+        case err != nil:
+            log.Printf("Error clearing retention for guild %s: %v", i.GuildID, err)
+            response = "❌ Could not clear the retention policy"
+        case cleared:
+            response = "🗓️ Retention policy removed, chat history is kept until users delete it"
+        default:
+            response = "This server has no retention policy"
+        }
+    case "show":
+        if policy := h.privacy.GetRetention(i.GuildID); policy != nil {
+            response = fmt.Sprintf("🗓️ Chat history older than %d days is deleted (set by <@%s> on %s)",
+                policy.Days, policy.SetBy, policy.UpdatedAt.Format("2006-01-02"))
+        } else {
+            response = "This server has no retention policy"
+        }
+    }
+
     s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
         Type: discordgo.InteractionResponseChannelMessageWithSource,
         Data: &discordgo.InteractionResponseData{
-            Content: fmt.Sprintf("Restored from backup: %s 📥", backupID),
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
         },
     })
 }
 
+// isAdmin reports whether the member who triggered the interaction has
+// administrator permission in the guild.
+func isAdmin(i *discordgo.InteractionCreate) bool {
+    return i.Member != nil && i.Member.Permissions&discordgo.PermissionAdministrator != 0
+}
+
 
 
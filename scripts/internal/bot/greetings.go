@@ -0,0 +1,166 @@
+package bot
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+// greetingButtonPrefix starts the custom IDs of the buttons under a
+// greeting: greeting:<prev|next>:<user ID>:<greeting index>.
+const greetingButtonPrefix = "greeting:"
+
+func (h *CommandHandler) handleGreeting(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt.StringValue()
+    }
+
+    response := ""
+    var err error
+    switch sub.Name {
+    case "add":
+        var n int
+        if n, err = h.promptManager.AddGreeting(userID, options["message"]); err == nil {
+            response = fmt.Sprintf("👋 Added greeting %d. Pick it with `/new-chat greeting:%d`.", n, n)
+        }
+    case "remove":
+        n, convErr := strconv.Atoi(strings.TrimSpace(options["greeting"]))
+        if convErr != nil {
+            err = services.ErrGreetingNotFound
+            break
+        }
+        var removed string
+        if removed, err = h.promptManager.RemoveGreeting(userID, n); err == nil {
+            response = "🗑️ Removed greeting: " + truncateChoiceName(strings.ReplaceAll(removed, "\n", " "))
+        }
+    case "list":
+        response = formatGreetings(h.promptManager.Greetings(userID))
+    }
+
+    if err != nil {
+        response = fmt.Sprintf("❌ %v", err)
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+func (h *CommandHandler) handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    customID := i.MessageComponentData().CustomID
+    if strings.HasPrefix(customID, greetingButtonPrefix) {
+        h.handleGreetingButton(s, i, strings.TrimPrefix(customID, greetingButtonPrefix))
+    }
+}
+
+// handleGreetingButton swaps the greeting of a chat that was just started
+// for the previous or next one, as long as the user has not replied yet.
+func (h *CommandHandler) handleGreetingButton(s *discordgo.Session, i *discordgo.InteractionCreate, args string) {
+    parts := strings.Split(args, ":")
+    if len(parts) != 3 {
+        return
+    }
+    ownerID := parts[1]
+    index, err := strconv.Atoi(parts[2])
+    if err != nil {
+        return
+    }
+
+    response := ""
+    if i.Member == nil || i.Member.User.ID != ownerID {
+        response = "❌ Only the person who started this chat can change its greeting"
+    } else {
+        greetings := h.promptManager.Greetings(ownerID)
+        if len(greetings) > 0 {
+            index = (index%len(greetings) + len(greetings)) % len(greetings)
+        }
+        var greeting string
+        if greeting, err = h.chatManager.SwapGreeting(ownerID, index); err == nil {
+            content, components := greetingMessage(ownerID, greeting, index, len(greetings))
+            s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+                Type: discordgo.InteractionResponseUpdateMessage,
+                Data: &discordgo.InteractionResponseData{
+                    Content:    content,
+                    Components: components,
+                },
+            })
+            return
+        }
+        if !errors.Is(err, services.ErrChatStarted) && !errors.Is(err, services.ErrGreetingNotFound) {
+            err = errors.New("could not change the greeting")
+        }
+        response = fmt.Sprintf("❌ %v", err)
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// greetingMessage shows greeting index out of total greetings, with
+// buttons to cycle through them when there is more than one.
+func greetingMessage(userID, greeting string, index, total int) (string, []discordgo.MessageComponent) {
+    header := "New chat session started! 🌟\n\n"
+    if total > 1 {
+        header = fmt.Sprintf("New chat session started! 🌟 *Greeting %d of %d*\n\n", index+1, total)
+    }
+    body := []rune(greeting)
+    if room := maxMessageLength - len([]rune(header)); len(body) > room {
+        body = append(body[:room-3], []rune("...")...)
+    }
+    content := header + string(body)
+    if total < 2 {
+        return content, nil
+    }
+
+    prev := (index + total - 1) % total
+    next := (index + 1) % total
+    return content, []discordgo.MessageComponent{
+        discordgo.ActionsRow{
+            Components: []discordgo.MessageComponent{
+                discordgo.Button{
+                    Label:    "◀",
+                    Style:    discordgo.SecondaryButton,
+                    CustomID: fmt.Sprintf("%sprev:%s:%d", greetingButtonPrefix, userID, prev),
+                },
+                discordgo.Button{
+                    Label:    "▶",
+                    Style:    discordgo.SecondaryButton,
+                    CustomID: fmt.Sprintf("%snext:%s:%d", greetingButtonPrefix, userID, next),
+                },
+            },
+        },
+    }
+}
+
+func formatGreetings(greetings []string) string {
+    if len(greetings) == 0 {
+        return "Your character has no greetings. Set one with `/set-first-message` or `/greeting add`."
+    }
+    var sb strings.Builder
+    sb.WriteString("👋 Greetings of your character:\n")
+    for n, greeting := range greetings {
+        line := fmt.Sprintf("%d. %s\n", n+1, truncateChoiceName(strings.ReplaceAll(greeting, "\n", " ")))
+        if len([]rune(sb.String()+line)) > maxMessageLength-40 {
+            sb.WriteString(fmt.Sprintf("...and %d more", len(greetings)-n))
+            break
+        }
+        sb.WriteString(line)
+    }
+    return sb.String()
+}
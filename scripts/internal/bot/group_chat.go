@@ -0,0 +1,139 @@
+package bot
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+// turnOrderChoices are the turn orders offered by /group.
+var turnOrderChoices = []*discordgo.ApplicationCommandOptionChoice{
+    {Name: "round-robin", Value: services.TurnRoundRobin},
+    {Name: "mention", Value: services.TurnMention},
+    {Name: "manual (/next)", Value: services.TurnManual},
+}
+
+func (h *CommandHandler) handleGroup(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt.StringValue()
+    }
+
+    response := ""
+    var err error
+    switch sub.Name {
+    case "start":
+        var members []services.Character
+        if members, err = h.promptManager.StartGroup(userID, strings.Split(options["characters"], ","), options["turn-order"]); err != nil {
+            break
+        }
+        h.chatManager.CreateNewChat(userID)
+        group, _ := h.promptManager.Group(userID)
+        response = fmt.Sprintf("👥 Group chat started with %s, taking turns %s. Reply to the bot to talk to them.",
+            memberNames(members), group.TurnOrder)
+    case "add":
+        var member services.Character
+        if member, err = h.promptManager.AddGroupMember(userID, options["character"]); err == nil {
+            response = fmt.Sprintf("👥 **%s** joined the group. Their definitions are sent from the next `/new-chat`.", member.Name)
+        }
+    case "remove":
+        var member services.Character
+        if member, err = h.promptManager.RemoveGroupMember(userID, options["character"]); err == nil {
+            response = fmt.Sprintf("👥 **%s** left the group", member.Name)
+        }
+    case "turn-order":
+        if err = h.promptManager.SetTurnOrder(userID, options["order"]); err == nil {
+            response = fmt.Sprintf("👥 The group now takes turns %s", options["order"])
+        }
+    case "show":
+        group, members := h.promptManager.Group(userID)
+        if !group.Active() || len(members) == 0 {
+            err = services.ErrNoGroup
+            break
+        }
+        next := members[group.Turn%len(members)].Name
+        response = fmt.Sprintf("👥 Group chat with %s\nTurn order: %s\nNext in turn: **%s**", memberNames(members), group.TurnOrder, next)
+    case "end":
+        if !h.promptManager.EndGroup(userID) {
+            err = services.ErrNoGroup
+            break
+        }
+        h.chatManager.CreateNewChat(userID)
+        response = "👥 Group chat ended, a new chat with your active character has started"
+    }
+
+    if err != nil {
+        response = fmt.Sprintf("❌ %v", err)
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// handleNext makes a group member reply now: the named one, or the next
+// one in turn order.
+func (h *CommandHandler) handleNext(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    name := ""
+    if options := i.ApplicationCommandData().Options; len(options) > 0 {
+        name = options[0].StringValue()
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: "💬 Waiting for the next reply...",
+        },
+    })
+
+    response, err := h.chatManager.GenerateResponseAs(userID, name)
+    if err != nil {
+        switch {
+        case errors.Is(err, services.ErrNoGroup), errors.Is(err, services.ErrNotInGroup), errors.Is(err, services.ErrManualTurn):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error generating group reply for %s: %v", userID, err)
+            response = "An error occurred while processing your request."
+        }
+    }
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+    })
+}
+
+// groupMemberChoices offers the members of the user's group chat.
+func (h *CommandHandler) groupMemberChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    _, members := h.promptManager.Group(userID)
+
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, member := range members {
+        if input != "" && !strings.Contains(strings.ToLower(member.Name), input) {
+            continue
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(member.Name),
+            Value: member.Name,
+        })
+    }
+    return choices
+}
+
+func memberNames(members []services.Character) string {
+    names := make([]string, len(members))
+    for n, member := range members {
+        names[n] = "**" + member.Name + "**"
+    }
+    return strings.Join(names, ", ")
+}
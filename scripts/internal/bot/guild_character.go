@@ -0,0 +1,123 @@
+package bot
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+func (h *CommandHandler) handleGuildCharacter(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt.StringValue()
+    }
+
+    response := ""
+    var err error
+    switch {
+    case i.GuildID == "":
+        response = "❌ Shared characters are only available in servers"
+    case (sub.Name == "publish" || sub.Name == "unpublish") && !isAdmin(i):
+        response = "❌ Only server admins can publish characters"
+    case sub.Name == "publish":
+        character := h.promptManager.GetUserPrompts(userID).Character
+        if name := options["character"]; name != "" {
+            if character, err = h.promptManager.Character(userID, name); err != nil {
+                break
+            }
+        }
+        var shared services.SharedCharacter
+        if shared, err = h.guilds.PublishCharacter(i.GuildID, character, userID, displayName(i.Member, i.Member.User)); err == nil {
+            response = fmt.Sprintf("📢 Published **%s** v%d. Members can use it with `/guild-character use`.",
+                shared.Character.Name, shared.Version)
+        }
+    case sub.Name == "unpublish":
+        var shared services.SharedCharacter
+        if shared, err = h.guilds.UnpublishCharacter(i.GuildID, options["name"]); err == nil {
+            response = fmt.Sprintf("🗑️ Unpublished **%s**. Members keep the copies they already use.", shared.Character.Name)
+        }
+    case sub.Name == "list":
+        response = formatSharedCharacters(h.guilds.SharedCharacters(i.GuildID))
+    case sub.Name == "use":
+        var shared services.SharedCharacter
+        if shared, err = h.guilds.SharedCharacter(i.GuildID, options["name"]); err != nil {
+            break
+        }
+        var previous, next services.Character
+        if previous, next, err = h.promptManager.UseSharedCharacter(userID, i.GuildID, shared); err != nil {
+            break
+        }
+        response = fmt.Sprintf("🎭 Now chatting as **%s** v%d by %s", next.Name, next.SharedVersion, shared.AuthorName)
+        if previous.ID == next.ID {
+            break
+        }
+        if switchErr := h.chatManager.SwitchCharacter(userID, previous, next); switchErr != nil {
+            log.Printf("Error switching chat history for %s: %v", userID, switchErr)
+            response += "\n⚠️ The chat history could not be switched, use `/new-chat` to start fresh"
+        }
+    }
+
+    if err != nil {
+        switch {
+        case errors.Is(err, services.ErrSharedNotFound), errors.Is(err, services.ErrSharedFull),
+            errors.Is(err, services.ErrCharacterNotFound), errors.Is(err, services.ErrCharacterName):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error managing shared characters for guild %s: %v", i.GuildID, err)
+            response = "❌ Could not save the shared characters"
+        }
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// guildCharacterChoices offers the user's own characters for publishing,
+// and the server's shared characters otherwise.
+func (h *CommandHandler) guildCharacterChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    if focused.Name == "character" {
+        return h.characterChoices(guildID, userID, focused)
+    }
+
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, shared := range h.guilds.SharedCharacters(guildID) {
+        if input != "" && !strings.Contains(strings.ToLower(shared.Character.Name), input) {
+            continue
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(fmt.Sprintf("%s v%d by %s", shared.Character.Name, shared.Version, shared.AuthorName)),
+            Value: shared.Character.Name,
+        })
+    }
+    return choices
+}
+
+func formatSharedCharacters(shared []services.SharedCharacter) string {
+    if len(shared) == 0 {
+        return "No characters are shared in this server yet. Admins can publish one with `/guild-character publish`."
+    }
+    var sb strings.Builder
+    sb.WriteString("📢 Shared characters:\n")
+    for n, character := range shared {
+        line := fmt.Sprintf("**%s** v%d by %s (%s)\n", character.Character.Name, character.Version,
+            character.AuthorName, character.PublishedAt.Format("2006-01-02"))
+        if len([]rune(sb.String()+line)) > maxMessageLength-40 {
+            sb.WriteString(fmt.Sprintf("...and %d more", len(shared)-n))
+            break
+        }
+        sb.WriteString(line)
+    }
+    return sb.String()
+}
@@ -1,9 +1,10 @@
 package bot
 
 import (
+    "errors"
     "strings"
     "github.com/bwmarrin/discordgo"
-    "your-module/internal/services"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
 )
 
 type EventHandler struct {
@@ -11,14 +12,16 @@ type EventHandler struct {
     promptManager *services.PromptManager
     chatManager   *services.ChatManager
     proxyClient   *services.ProxyClient
+    privacy       *services.PrivacyManager
 }
 
-func NewEventHandler(d *discordgo.Session, pm *services.PromptManager, cm *services.ChatManager, pc *services.ProxyClient) *EventHandler {
+func NewEventHandler(d *discordgo.Session, pm *services.PromptManager, cm *services.ChatManager, pc *services.ProxyClient, privacy *services.PrivacyManager) *EventHandler {
     return &EventHandler{
         discord:       d,
         promptManager: pm,
         chatManager:   cm,
         proxyClient:   pc,
+        privacy:       privacy,
     }
 }
 
@@ -64,6 +67,8 @@ func (h *EventHandler) HandleMessageDelete(s *discordgo.Session, m *discordgo.Me
 }
 
 func (h *EventHandler) handleBotReply(s *discordgo.Session, m *discordgo.MessageCreate) {
+    h.privacy.TrackUser(m.GuildID, m.Author.ID)
+    h.promptManager.TrackPresence(m.Author.ID, m.GuildID, m.ChannelID, displayName(m.Member, m.Author))
     s.ChannelTyping(m.ChannelID)
     h.chatManager.AddMessage(m.Author.ID, "user", m.Content)
     
@@ -82,6 +87,8 @@ func (h *EventHandler) handleBotMention(s *discordgo.Session, m *discordgo.Messa
         "",
     ))
 
+    h.privacy.TrackUser(m.GuildID, m.Author.ID)
+    h.promptManager.TrackPresence(m.Author.ID, m.GuildID, m.ChannelID, displayName(m.Member, m.Author))
     s.ChannelTyping(m.ChannelID)
     h.chatManager.AddMessage(m.Author.ID, "user", content)
     
@@ -103,9 +110,29 @@ func (h *EventHandler) sendResponse(s *discordgo.Session, channelID string, cont
 }
 
 func (h *EventHandler) sendErrorResponse(s *discordgo.Session, channelID string, err error) {
+    if errors.Is(err, services.ErrManualTurn) {
+        // The user picks who replies with /next
+        return
+    }
     s.ChannelMessageSend(channelID, "An error occurred while processing your request.")
 }
 
+// displayName returns the name a user goes by: their server nickname, then
+// their global display name, then their username. Members attached to
+// message events carry no user, so the author is passed separately.
+func displayName(member *discordgo.Member, user *discordgo.User) string {
+    if member != nil && member.Nick != "" {
+        return member.Nick
+    }
+    if user == nil {
+        return ""
+    }
+    if user.GlobalName != "" {
+        return user.GlobalName
+    }
+    return user.Username
+}
+
 func (h *EventHandler) RegisterHandlers() {
     h.discord.AddHandler(h.HandleMessageCreate)
     h.discord.AddHandler(h.HandleMessageEdit)
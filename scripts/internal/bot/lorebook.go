@@ -0,0 +1,261 @@
+package bot
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/cards"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+// maxMessageLength is the most characters Discord accepts in one message.
+const maxMessageLength = 2000
+
+func (h *CommandHandler) handleLorebook(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt
+    }
+
+    if sub.Name == "import" {
+        h.handleLorebookImport(s, i, options)
+        return
+    }
+
+    response := ""
+    var err error
+    switch sub.Name {
+    case "add":
+        entry := services.LoreEntry{Enabled: true}
+        applyLoreOptions(&entry, options)
+        if entry, err = h.promptManager.AddLoreEntry(userID, entry); err == nil {
+            response = fmt.Sprintf("📚 Added **%s** to your lorebook", entry.Name)
+        }
+    case "edit":
+        name := options["name"].StringValue()
+        book := h.promptManager.GetLorebook(userID)
+        entry, found := findLoreEntry(book, name)
+        if !found {
+            err = services.ErrLoreEntryNotFound
+            break
+        }
+        applyLoreOptions(&entry, options)
+        if opt, ok := options["new-name"]; ok {
+            entry.Name = opt.StringValue()
+        }
+        if opt, ok := options["enabled"]; ok {
+            entry.Enabled = opt.BoolValue()
+        }
+        if entry, err = h.promptManager.UpdateLoreEntry(userID, name, entry); err == nil {
+            response = fmt.Sprintf("✏️ Updated **%s**", entry.Name)
+        }
+    case "remove":
+        var removed services.LoreEntry
+        if removed, err = h.promptManager.RemoveLoreEntry(userID, options["name"].StringValue()); err == nil {
+            response = fmt.Sprintf("🗑️ Removed **%s** from your lorebook", removed.Name)
+        }
+    case "show":
+        entry, found := findLoreEntry(h.promptManager.GetLorebook(userID), options["name"].StringValue())
+        if !found {
+            err = services.ErrLoreEntryNotFound
+            break
+        }
+        response = formatLoreEntry(entry)
+    case "list":
+        response = formatLorebook(h.promptManager.GetLorebook(userID))
+    case "settings":
+        book := h.promptManager.GetLorebook(userID)
+        scanDepth, budget := book.ScanDepth, book.TokenBudget
+        if opt, ok := options["scan-depth"]; ok {
+            scanDepth = int(opt.IntValue())
+        }
+        if opt, ok := options["token-budget"]; ok {
+            budget = int(opt.IntValue())
+        }
+        if len(options) > 0 {
+            err = h.promptManager.SetLoreSettings(userID, scanDepth, budget)
+        }
+        if err == nil {
+            response = fmt.Sprintf("📚 The last %d messages are scanned for keys, and up to %d tokens of entries are added.",
+                scanDepth, budget)
+        }
+    }
+
+    if err != nil {
+        response = fmt.Sprintf("❌ %v", err)
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+func (h *CommandHandler) handleLorebookImport(s *discordgo.Session, i *discordgo.InteractionCreate, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+    userID := i.Member.User.ID
+    data := i.ApplicationCommandData()
+
+    attachment, ok := data.Resolved.Attachments[options["file"].Value.(string)]
+    if !ok {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Attach a world info file",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+    replace := false
+    if opt, ok := options["replace"]; ok {
+        replace = opt.BoolValue()
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Flags: discordgo.MessageFlagsEphemeral,
+        },
+    })
+
+    var entries []cards.WorldEntry
+    raw, err := downloadAttachment(attachment.URL, maxCardDownloadSize)
+    if err == nil {
+        entries, err = cards.ParseWorldInfo(raw)
+    }
+    imported := 0
+    if err == nil {
+        imported, err = h.promptManager.ImportWorldInfo(userID, entries, replace)
+    }
+
+    response := ""
+    switch {
+    case err == nil:
+        response = fmt.Sprintf("📚 Imported %d lorebook entries", imported)
+        if skipped := len(entries) - imported; skipped > 0 {
+            response += fmt.Sprintf(" (%d skipped: empty or without keys)", skipped)
+        }
+    case errors.Is(err, cards.ErrUnsupportedSpec), errors.Is(err, cards.ErrInvalidCard),
+        errors.Is(err, cards.ErrNoCardChunk), errors.Is(err, services.ErrLorebookFull):
+        response = fmt.Sprintf("❌ %v", err)
+    default:
+        log.Printf("Error importing world info for %s: %v", userID, err)
+        response = "❌ Could not read the world info file"
+    }
+    s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+        Content: &response,
+    })
+}
+
+// applyLoreOptions copies the entry options given to /lorebook add or edit
+// onto entry.
+func applyLoreOptions(entry *services.LoreEntry, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+    for name, opt := range options {
+        switch name {
+        case "keys":
+            entry.Keys = services.SplitKeys(opt.StringValue())
+        case "secondary-keys":
+            entry.SecondaryKeys = services.SplitKeys(opt.StringValue())
+        case "content":
+            entry.Content = opt.StringValue()
+        case "position":
+            entry.Position = opt.StringValue()
+        case "depth":
+            entry.Depth = int(opt.IntValue())
+        case "order":
+            entry.Order = int(opt.IntValue())
+        case "case-sensitive":
+            entry.CaseSensitive = opt.BoolValue()
+        case "selective":
+            entry.Selective = opt.BoolValue()
+        case "constant":
+            entry.Constant = opt.BoolValue()
+        }
+    }
+    if name, ok := options["name"]; ok && entry.Name == "" {
+        entry.Name = name.StringValue()
+    }
+}
+
+func findLoreEntry(book services.Lorebook, name string) (services.LoreEntry, bool) {
+    name = strings.TrimSpace(name)
+    for _, entry := range book.Entries {
+        if strings.EqualFold(entry.Name, name) {
+            return entry, true
+        }
+    }
+    return services.LoreEntry{}, false
+}
+
+// loreEntrySummary describes where and when an entry is inserted.
+func loreEntrySummary(entry services.LoreEntry) string {
+    var flags []string
+    if entry.Position == services.LorePositionDepth {
+        flags = append(flags, fmt.Sprintf("depth %d", entry.Depth))
+    } else {
+        flags = append(flags, entry.Position)
+    }
+    flags = append(flags, fmt.Sprintf("order %d", entry.Order))
+    if entry.Constant {
+        flags = append(flags, "constant")
+    }
+    if entry.Selective {
+        flags = append(flags, "selective")
+    }
+    if entry.CaseSensitive {
+        flags = append(flags, "case sensitive")
+    }
+    if !entry.Enabled {
+        flags = append(flags, "disabled")
+    }
+    return strings.Join(flags, ", ")
+}
+
+func formatLoreEntry(entry services.LoreEntry) string {
+    var sb strings.Builder
+    sb.WriteString(fmt.Sprintf("📖 **%s** (%s, ~%d tokens)\n", entry.Name, loreEntrySummary(entry), services.EstimateTokens(entry.Content)))
+    if len(entry.Keys) > 0 {
+        sb.WriteString("Keys: `" + strings.Join(entry.Keys, "`, `") + "`\n")
+    }
+    if len(entry.SecondaryKeys) > 0 {
+        sb.WriteString("Secondary keys: `" + strings.Join(entry.SecondaryKeys, "`, `") + "`\n")
+    }
+    sb.WriteString(entry.Content)
+
+    runes := []rune(sb.String())
+    if len(runes) > maxMessageLength {
+        return string(runes[:maxMessageLength-3]) + "..."
+    }
+    return string(runes)
+}
+
+func formatLorebook(book services.Lorebook) string {
+    if len(book.Entries) == 0 {
+        return "📚 Your lorebook is empty. Use `/lorebook add` or `/lorebook import` to fill it."
+    }
+
+    var sb strings.Builder
+    sb.WriteString(fmt.Sprintf("📚 Your lorebook (%d entries, scanning %d messages, %d token budget):\n",
+        len(book.Entries), book.ScanDepth, book.TokenBudget))
+    for n, entry := range book.Entries {
+        keys := "no keys"
+        if len(entry.Keys) > 0 {
+            keys = "`" + strings.Join(entry.Keys, "`, `") + "`"
+        }
+        line := fmt.Sprintf("**%s** — %s (%s)\n", entry.Name, keys, loreEntrySummary(entry))
+        if len([]rune(sb.String()+line)) > maxMessageLength-40 {
+            sb.WriteString(fmt.Sprintf("...and %d more", len(book.Entries)-n))
+            break
+        }
+        sb.WriteString(line)
+    }
+    return sb.String()
+}
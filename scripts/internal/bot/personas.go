@@ -0,0 +1,154 @@
+package bot
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+func (h *CommandHandler) handlePersona(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        if opt.Type == discordgo.ApplicationCommandOptionChannel {
+            // Channels come as their ID
+            options[opt.Name], _ = opt.Value.(string)
+            continue
+        }
+        options[opt.Name] = opt.StringValue()
+    }
+
+    response := ""
+    var err error
+    var persona services.Persona
+    switch sub.Name {
+    case "create":
+        if persona, err = h.promptManager.CreatePersona(userID, options["name"], options["description"]); err == nil {
+            response = fmt.Sprintf("🪪 Created **%s** and made it your persona. Start a `/new-chat` to use it.", persona.Name)
+        }
+    case "switch":
+        if persona, err = h.promptManager.SwitchPersona(userID, options["name"]); err != nil {
+            break
+        }
+        response = fmt.Sprintf("🪪 You are now **%s**. Start a `/new-chat` to use it.", persona.Name)
+        if persona.ID == "" {
+            response = "🪪 You no longer use a persona. Start a `/new-chat` to apply it."
+        }
+    case "list":
+        personas, activeID := h.promptManager.ListPersonas(userID)
+        response = h.formatPersonas(userID, personas, activeID)
+    case "delete":
+        if persona, err = h.promptManager.DeletePersona(userID, options["name"]); err == nil {
+            response = fmt.Sprintf("🗑️ Deleted **%s**", persona.Name)
+        }
+    case "bind":
+        if persona, err = h.promptManager.BindPersona(userID, options["name"], options["character"], options["channel"]); err == nil {
+            response = fmt.Sprintf("🔗 **%s** is now used %s", persona.Name, bindTarget(options["character"], options["channel"]))
+        }
+    case "unbind":
+        if persona, err = h.promptManager.UnbindPersona(userID, options["name"], options["character"], options["channel"]); err == nil {
+            response = fmt.Sprintf("🔗 **%s** is no longer used %s", persona.Name, bindTarget(options["character"], options["channel"]))
+        }
+    }
+
+    if err != nil {
+        switch {
+        case errors.Is(err, services.ErrPersonaNotFound), errors.Is(err, services.ErrPersonaExists),
+            errors.Is(err, services.ErrPersonaName), errors.Is(err, services.ErrPersonasFull),
+            errors.Is(err, services.ErrNoBindTarget), errors.Is(err, services.ErrCharacterNotFound):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error managing personas for %s: %v", userID, err)
+            response = "❌ Could not update your personas"
+        }
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// bindTarget describes where a persona binding applies.
+func bindTarget(character, channelID string) string {
+    var targets []string
+    if character != "" {
+        targets = append(targets, "with **"+character+"**")
+    }
+    if channelID != "" {
+        targets = append(targets, "in <#"+channelID+">")
+    }
+    return strings.Join(targets, " and ")
+}
+
+// formatPersonas lists the user's personas with the active one and their
+// bindings.
+func (h *CommandHandler) formatPersonas(userID string, personas []services.Persona, activeID string) string {
+    if len(personas) == 0 {
+        return "You have no personas yet. Create one with `/persona create`."
+    }
+    var sb strings.Builder
+    sb.WriteString("🪪 Your personas:\n")
+    for n, persona := range personas {
+        line := "**" + persona.Name + "**"
+        if persona.ID == activeID {
+            line += " (active)"
+        }
+        var bound []string
+        for _, id := range persona.Characters {
+            if name, ok := h.promptManager.CharacterName(userID, id); ok {
+                bound = append(bound, name)
+            }
+        }
+        for _, id := range persona.Channels {
+            bound = append(bound, "<#"+id+">")
+        }
+        if len(bound) > 0 {
+            line += " → " + strings.Join(bound, ", ")
+        }
+        if persona.Description != "" {
+            line += ": " + truncateChoiceName(strings.ReplaceAll(persona.Description, "\n", " "))
+        }
+        line += "\n"
+        if len([]rune(sb.String()+line)) > maxMessageLength-40 {
+            sb.WriteString(fmt.Sprintf("...and %d more", len(personas)-n))
+            break
+        }
+        sb.WriteString(line)
+    }
+    return sb.String()
+}
+
+// personaChoices offers the user's personas, and their characters for
+// binding.
+func (h *CommandHandler) personaChoices(guildID, userID string, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+    if focused.Name == "character" {
+        return h.characterChoices(guildID, userID, focused)
+    }
+
+    personas, activeID := h.promptManager.ListPersonas(userID)
+    input := strings.ToLower(focused.StringValue())
+    var choices []*discordgo.ApplicationCommandOptionChoice
+    for _, persona := range personas {
+        if input != "" && !strings.Contains(strings.ToLower(persona.Name), input) {
+            continue
+        }
+        name := persona.Name
+        if persona.ID == activeID {
+            name += " (active)"
+        }
+        choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+            Name:  truncateChoiceName(name),
+            Value: persona.Name,
+        })
+    }
+    return choices
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+)
+
+func (h *CommandHandler) handlePostHistory(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt.StringValue()
+    }
+
+    response := ""
+    switch sub.Name {
+    case "set":
+        h.promptManager.SetPostHistoryInstructions(userID, options["instructions"])
+        response = "📌 Post-history instructions set for your character"
+    case "clear":
+        h.promptManager.SetPostHistoryInstructions(userID, "")
+        response = "📌 Post-history instructions cleared for your character"
+    case "show":
+        fallback, override := h.guilds.PostHistoryInstructions(i.GuildID)
+        response = formatPostHistory(h.promptManager.GetUserPrompts(userID).PostHistoryInstructions, fallback, override)
+    case "guild":
+        if i.GuildID == "" || !isAdmin(i) {
+            response = "❌ Only server admins can set the server's post-history instructions"
+            break
+        }
+        kind := options["kind"]
+        if err := h.guilds.SetPostHistoryInstructions(i.GuildID, kind, options["instructions"]); err != nil {
+            log.Printf("Error saving post-history instructions for guild %s: %v", i.GuildID, err)
+            response = "❌ Could not save the post-history instructions"
+        } else if strings.TrimSpace(options["instructions"]) == "" {
+            response = fmt.Sprintf("📌 Cleared the server's %s post-history instructions", kind)
+        } else {
+            response = fmt.Sprintf("📌 Set the server's %s post-history instructions", kind)
+        }
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// formatPostHistory shows the character's and the server's post-history
+// instructions, and which of them are used.
+func formatPostHistory(own, fallback, override string) string {
+    show := func(text string) string {
+        if text == "" {
+            return "*none*"
+        }
+        return truncateChoiceName(strings.ReplaceAll(text, "\n", " "))
+    }
+
+    var sb strings.Builder
+    sb.WriteString("📌 Post-history instructions:\n")
+    sb.WriteString(fmt.Sprintf("**Character:** %s\n", show(own)))
+    sb.WriteString(fmt.Sprintf("**Server default:** %s\n", show(fallback)))
+    sb.WriteString(fmt.Sprintf("**Server override:** %s\n", show(override)))
+    switch {
+    case override != "":
+        sb.WriteString("The server override is used.")
+    case own != "":
+        sb.WriteString("Your character's instructions are used.")
+    case fallback != "":
+        sb.WriteString("The server default is used.")
+    default:
+        sb.WriteString("None are sent.")
+    }
+    return sb.String()
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+// handlePromptPreview sends the request the next reply would make as a
+// JSON file, without calling the backend.
+func (h *CommandHandler) handlePromptPreview(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    name := ""
+    if options := i.ApplicationCommandData().Options; len(options) > 0 {
+        name = options[0].StringValue()
+    }
+
+    response := ""
+    var files []*discordgo.File
+    preview, err := h.chatManager.PreviewRequest(userID, name)
+    if err == nil {
+        preview.Payload = h.proxyClient.Payload(userID, preview.Messages)
+        var data []byte
+        if data, err = json.MarshalIndent(preview, "", "  "); err == nil {
+            response = formatPromptPreview(preview)
+            files = []*discordgo.File{
+                {
+                    Name:        "prompt_preview.json",
+                    ContentType: "application/json",
+                    Reader:      bytes.NewReader(data),
+                },
+            }
+        }
+    }
+
+    if err != nil {
+        switch {
+        case errors.Is(err, services.ErrNoGroup), errors.Is(err, services.ErrNotInGroup), errors.Is(err, services.ErrManualTurn):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error previewing prompt for %s: %v", userID, err)
+            response = "❌ Could not build the prompt preview"
+        }
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Files:   files,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// formatPromptPreview summarizes the estimated tokens of each section.
+func formatPromptPreview(preview *services.PromptPreview) string {
+    var sb strings.Builder
+    sb.WriteString("🔍 Prompt preview")
+    if preview.Speaker != "" {
+        sb.WriteString(fmt.Sprintf(" for **%s**", preview.Speaker))
+    }
+    sb.WriteString(fmt.Sprintf(", about %d tokens (nothing was sent):\n", preview.TotalTokens))
+    for _, section := range preview.Sections {
+        sb.WriteString(fmt.Sprintf("**%s:** %d tokens in %d messages\n", section.Name, section.Tokens, section.Messages))
+    }
+    return sb.String()
+}
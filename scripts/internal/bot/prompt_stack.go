@@ -0,0 +1,96 @@
+package bot
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+func (h *CommandHandler) handlePrompt(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt
+    }
+
+    response := ""
+    var entry services.PromptEntry
+    var err error
+    switch sub.Name {
+    case "add":
+        position := 0
+        if opt, ok := options["position"]; ok {
+            position = int(opt.IntValue())
+        }
+        entry, err = h.promptManager.AddPrompt(userID, options["role"].StringValue(), options["content"].StringValue(), position)
+        response = "➕ Added " + promptEntryName(entry)
+    case "move":
+        entry, err = h.promptManager.MovePrompt(userID, options["prompt"].StringValue(), int(options["position"].IntValue()))
+        response = "↕️ Moved " + promptEntryName(entry)
+    case "edit":
+        var role, content *string
+        var enabled *bool
+        if opt, ok := options["role"]; ok {
+            value := opt.StringValue()
+            role = &value
+        }
+        if opt, ok := options["content"]; ok {
+            value := opt.StringValue()
+            content = &value
+        }
+        if opt, ok := options["enabled"]; ok {
+            value := opt.BoolValue()
+            enabled = &value
+        }
+        entry, err = h.promptManager.EditPrompt(userID, options["prompt"].StringValue(), role, content, enabled)
+        response = "✏️ Updated " + promptEntryName(entry)
+    case "remove":
+        entry, err = h.promptManager.RemovePrompt(userID, options["prompt"].StringValue())
+        response = "🗑️ Removed " + promptEntryName(entry)
+    case "list":
+        response = formatPromptStack(h.promptManager.PromptStack(userID))
+    }
+
+    if err != nil {
+        response = fmt.Sprintf("❌ %v", err)
+    } else if sub.Name != "list" {
+        response += "\nChanges apply to new chats, use `/new-chat` to start one."
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
+
+// promptEntryName describes a stack entry in one line.
+func promptEntryName(entry services.PromptEntry) string {
+    name := fmt.Sprintf("[%s] %s", entry.Role, strings.ReplaceAll(entry.Label(), "\n", " "))
+    if entry.Block != "" {
+        name = entry.Label() + " (built-in)"
+    }
+    if !entry.Enabled {
+        name += " (disabled)"
+    }
+    return truncateChoiceName(name)
+}
+
+func formatPromptStack(stack []services.PromptEntry) string {
+    var sb strings.Builder
+    sb.WriteString("🧱 Your prompt stack, sent in this order before the chat:\n")
+    for n, entry := range stack {
+        line := fmt.Sprintf("%d. %s\n", n+1, promptEntryName(entry))
+        if len([]rune(sb.String()+line)) > maxMessageLength-40 {
+            sb.WriteString(fmt.Sprintf("...and %d more", len(stack)-n))
+            break
+        }
+        sb.WriteString(line)
+    }
+    return sb.String()
+}
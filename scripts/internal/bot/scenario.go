@@ -0,0 +1,89 @@
+package bot
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/bwmarrin/discordgo"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+func (h *CommandHandler) handleScenario(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    userID := i.Member.User.ID
+    sub := i.ApplicationCommandData().Options[0]
+    options := make(map[string]string, len(sub.Options))
+    for _, opt := range sub.Options {
+        options[opt.Name] = opt.StringValue()
+    }
+
+    if (sub.Name == "add" || sub.Name == "remove") && (i.GuildID == "" || !isAdmin(i)) {
+        s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+            Type: discordgo.InteractionResponseChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData{
+                Content: "❌ Only server admins can manage scenario presets",
+                Flags:   discordgo.MessageFlagsEphemeral,
+            },
+        })
+        return
+    }
+
+    response := ""
+    var err error
+    switch sub.Name {
+    case "use":
+        var preset services.ScenarioPreset
+        if preset, err = h.guilds.Scenario(i.GuildID, options["name"]); err != nil {
+            break
+        }
+        h.promptManager.UpdateDefinitions(userID, map[string]string{"scenario": preset.Scenario})
+        response = fmt.Sprintf("🎬 Scenario set to **%s**. Use `/new-chat` to start a chat with it.", preset.Name)
+    case "list":
+        var sb strings.Builder
+        sb.WriteString("🎬 Scenario presets:\n")
+        presets := h.guilds.Scenarios(i.GuildID)
+        for n, preset := range presets {
+            marker := ""
+            if preset.Builtin {
+                marker = " (built-in)"
+            }
+            line := fmt.Sprintf("**%s**%s: %s\n", preset.Name, marker, truncateChoiceName(preset.Scenario))
+            if len([]rune(sb.String()+line)) > maxMessageLength-40 {
+                sb.WriteString(fmt.Sprintf("...and %d more", len(presets)-n))
+                break
+            }
+            sb.WriteString(line)
+        }
+        response = sb.String()
+    case "add":
+        var preset services.ScenarioPreset
+        if preset, err = h.guilds.SetScenario(i.GuildID, options["name"], options["scenario"], userID); err == nil {
+            response = fmt.Sprintf("🎬 Saved the **%s** preset for this server", preset.Name)
+        }
+    case "remove":
+        var preset services.ScenarioPreset
+        if preset, err = h.guilds.RemoveScenario(i.GuildID, options["name"]); err == nil {
+            response = fmt.Sprintf("🗑️ Removed the **%s** preset", preset.Name)
+        }
+    }
+
+    if err != nil {
+        switch {
+        case errors.Is(err, services.ErrScenarioNotFound), errors.Is(err, services.ErrScenarioBuiltin),
+            errors.Is(err, services.ErrScenarioName), errors.Is(err, services.ErrScenarioEmpty):
+            response = fmt.Sprintf("❌ %v", err)
+        default:
+            log.Printf("Error managing scenario presets for guild %s: %v", i.GuildID, err)
+            response = "❌ Could not save the scenario presets"
+        }
+    }
+
+    s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+        Type: discordgo.InteractionResponseChannelMessageWithSource,
+        Data: &discordgo.InteractionResponseData{
+            Content: response,
+            Flags:   discordgo.MessageFlagsEphemeral,
+        },
+    })
+}
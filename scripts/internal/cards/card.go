@@ -0,0 +1,166 @@
+package cards
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+const (
+    SpecV2        = "chara_card_v2"
+    SpecVersionV2 = "2.0"
+)
+
+var (
+    ErrUnsupportedSpec = errors.New("unsupported character card spec")
+    ErrInvalidCard     = errors.New("invalid character card")
+)
+
+// Data holds the character fields of a card. V1 cards keep them at the top
+// level, V2 cards under "data".
+type Data struct {
+    Name                    string                 `json:"name"`
+    Description             string                 `json:"description"`
+    Personality             string                 `json:"personality"`
+    Scenario                string                 `json:"scenario"`
+    FirstMes                string                 `json:"first_mes"`
+    MesExample              string                 `json:"mes_example"`
+    CreatorNotes            string                 `json:"creator_notes"`
+    SystemPrompt            string                 `json:"system_prompt"`
+    PostHistoryInstructions string                 `json:"post_history_instructions"`
+    AlternateGreetings      []string               `json:"alternate_greetings"`
+    CharacterBook           json.RawMessage        `json:"character_book,omitempty"`
+    Tags                    []string               `json:"tags"`
+    Creator                 string                 `json:"creator"`
+    CharacterVersion        string                 `json:"character_version"`
+    Extensions              map[string]interface{} `json:"extensions"`
+}
+
+// Card is a parsed Tavern character card, the format shared by SillyTavern,
+// TavernAI and most other chat frontends. V1 cards are upgraded to the V2
+// layout.
+type Card struct {
+    Spec        string `json:"spec"`
+    SpecVersion string `json:"spec_version"`
+    Data        Data   `json:"data"`
+
+    // SourceVersion is 1 or 2, the spec the card was read from.
+    SourceVersion int `json:"-"`
+    // Unknown lists fields that are not part of the spec.
+    Unknown []string `json:"-"`
+}
+
+// v1Fields are the fields of a V1 card. Frontends add a few of their own,
+// which are ignored rather than reported.
+var v1Fields = map[string]bool{
+    "name": true, "description": true, "personality": true, "scenario": true,
+    "first_mes": true, "mes_example": true,
+    "avatar": true, "chat": true, "create_date": true, "creatorcomment": true,
+    "talkativeness": true, "fav": true, "tags": true, "spec": true,
+    "spec_version": true, "data": true,
+}
+
+// v2Fields are the fields of the "data" object of a V2 card.
+var v2Fields = map[string]bool{
+    "name": true, "description": true, "personality": true, "scenario": true,
+    "first_mes": true, "mes_example": true, "creator_notes": true,
+    "system_prompt": true, "post_history_instructions": true,
+    "alternate_greetings": true, "character_book": true, "tags": true,
+    "creator": true, "character_version": true, "extensions": true,
+}
+
+// Parse reads a card from JSON or from a PNG with the card base64-encoded
+// in a "chara" tEXt chunk.
+func Parse(data []byte) (*Card, error) {
+    if IsPNG(data) {
+        chunks, err := readPNGChunks(data)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+        }
+        encoded, ok := textChunk(chunks, "chara")
+        if !ok {
+            return nil, ErrNoCardChunk
+        }
+        decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+        if err != nil {
+            return nil, fmt.Errorf("%w: chara chunk is not base64: %v", ErrInvalidCard, err)
+        }
+        data = decoded
+    }
+    return ParseJSON(data)
+}
+
+// ParseJSON reads a V1 or V2 card from JSON.
+func ParseJSON(data []byte) (*Card, error) {
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+    }
+
+    var spec string
+    if raw, ok := fields["spec"]; ok {
+        if err := json.Unmarshal(raw, &spec); err != nil {
+            return nil, fmt.Errorf("%w: spec is not a string", ErrInvalidCard)
+        }
+    }
+
+    card := &Card{Spec: SpecV2, SpecVersion: SpecVersionV2}
+    switch spec {
+    case "":
+        card.SourceVersion = 1
+        if err := json.Unmarshal(data, &card.Data); err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+        }
+        if raw, ok := fields["creatorcomment"]; ok && card.Data.CreatorNotes == "" {
+            json.Unmarshal(raw, &card.Data.CreatorNotes)
+        }
+        card.Unknown = unknownFields(fields, v1Fields)
+    case SpecV2:
+        var version string
+        if err := json.Unmarshal(fields["spec_version"], &version); err != nil || !strings.HasPrefix(version, "2.") {
+            return nil, fmt.Errorf("%w: %s version %q", ErrUnsupportedSpec, spec, version)
+        }
+        raw, ok := fields["data"]
+        if !ok {
+            return nil, fmt.Errorf("%w: missing data", ErrInvalidCard)
+        }
+        var dataFields map[string]json.RawMessage
+        if err := json.Unmarshal(raw, &dataFields); err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+        }
+        if err := json.Unmarshal(raw, &card.Data); err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+        }
+        card.SourceVersion = 2
+        card.Unknown = unknownFields(dataFields, v2Fields)
+    default:
+        return nil, fmt.Errorf("%w: %q", ErrUnsupportedSpec, spec)
+    }
+
+    if strings.TrimSpace(card.Data.Name) == "" {
+        return nil, fmt.Errorf("%w: missing name", ErrInvalidCard)
+    }
+    return card, nil
+}
+
+func unknownFields(fields map[string]json.RawMessage, known map[string]bool) []string {
+    var unknown []string
+    for name, raw := range fields {
+        if !known[name] && !isEmptyJSON(raw) {
+            unknown = append(unknown, name)
+        }
+    }
+    sort.Strings(unknown)
+    return unknown
+}
+
+func isEmptyJSON(raw json.RawMessage) bool {
+    switch strings.TrimSpace(string(raw)) {
+    case "", "null", `""`, "[]", "{}", "false", "0":
+        return true
+    }
+    return false
+}
@@ -0,0 +1,138 @@
+package cards
+
+import (
+    "encoding/base64"
+    "errors"
+    "reflect"
+    "testing"
+)
+
+// pngWithChara returns a default avatar carrying text in a chara chunk.
+func pngWithChara(t *testing.T, text string) []byte {
+    t.Helper()
+    chunks, err := readPNGChunks(DefaultAvatar("test"))
+    if err != nil {
+        t.Fatalf("readPNGChunks: %v", err)
+    }
+    last := len(chunks) - 1
+    chara := pngChunk{Type: "tEXt", Data: append([]byte("chara\x00"), text...)}
+    chunks = append(chunks[:last:last], chara, chunks[last])
+    return writePNGChunks(chunks)
+}
+
+func TestParse(t *testing.T) {
+    v1 := `{"name": "Alice", "description": "A witch", "personality": "Curious",
+        "scenario": "A forest", "first_mes": "Hello!", "mes_example": "<START>",
+        "creatorcomment": "Made for testing", "avatar": "none", "mood": "happy"}`
+    v2 := `{"spec": "chara_card_v2", "spec_version": "2.0", "data": {
+        "name": "Bob", "description": "A knight", "first_mes": "Hail!",
+        "alternate_greetings": ["Well met", "Greetings"],
+        "post_history_instructions": "Stay in character", "tags": ["fantasy"],
+        "extensions": {}, "mood": "grim"}}`
+
+    tests := []struct {
+        name        string
+        data        []byte
+        wantErr     error
+        wantVersion int
+        wantData    Data
+        wantUnknown []string
+    }{
+        {
+            name:        "V1 JSON",
+            data:        []byte(v1),
+            wantVersion: 1,
+            wantData: Data{
+                Name: "Alice", Description: "A witch", Personality: "Curious",
+                Scenario: "A forest", FirstMes: "Hello!", MesExample: "<START>",
+                CreatorNotes: "Made for testing",
+            },
+            wantUnknown: []string{"mood"},
+        },
+        {
+            name:        "V2 JSON",
+            data:        []byte(v2),
+            wantVersion: 2,
+            wantData: Data{
+                Name: "Bob", Description: "A knight", FirstMes: "Hail!",
+                AlternateGreetings:      []string{"Well met", "Greetings"},
+                PostHistoryInstructions: "Stay in character",
+                Tags:                    []string{"fantasy"},
+                Extensions:              map[string]interface{}{},
+            },
+            wantUnknown: []string{"mood"},
+        },
+        {
+            name:        "V1 PNG",
+            data:        pngWithChara(t, base64.StdEncoding.EncodeToString([]byte(v1))),
+            wantVersion: 1,
+            wantData: Data{
+                Name: "Alice", Description: "A witch", Personality: "Curious",
+                Scenario: "A forest", FirstMes: "Hello!", MesExample: "<START>",
+                CreatorNotes: "Made for testing",
+            },
+            wantUnknown: []string{"mood"},
+        },
+        {
+            name:    "PNG without a card",
+            data:    DefaultAvatar("test"),
+            wantErr: ErrNoCardChunk,
+        },
+        {
+            name:    "PNG with a chara chunk that is not base64",
+            data:    pngWithChara(t, "not base64!"),
+            wantErr: ErrInvalidCard,
+        },
+        {
+            name:    "V3 card",
+            data:    []byte(`{"spec": "chara_card_v3", "spec_version": "3.0", "data": {"name": "Eve"}}`),
+            wantErr: ErrUnsupportedSpec,
+        },
+        {
+            name:    "V2 card of a later major version",
+            data:    []byte(`{"spec": "chara_card_v2", "spec_version": "3.0", "data": {"name": "Eve"}}`),
+            wantErr: ErrUnsupportedSpec,
+        },
+        {
+            name:    "V2 card without data",
+            data:    []byte(`{"spec": "chara_card_v2", "spec_version": "2.0"}`),
+            wantErr: ErrInvalidCard,
+        },
+        {
+            name:    "missing name",
+            data:    []byte(`{"description": "Nobody"}`),
+            wantErr: ErrInvalidCard,
+        },
+        {
+            name:    "not JSON",
+            data:    []byte("hello"),
+            wantErr: ErrInvalidCard,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            card, err := Parse(tt.data)
+            if tt.wantErr != nil {
+                if !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("Parse error = %v, want %v", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Parse: %v", err)
+            }
+            if card.Spec != SpecV2 || card.SpecVersion != SpecVersionV2 {
+                t.Errorf("card is %s %s, want it upgraded to %s %s", card.Spec, card.SpecVersion, SpecV2, SpecVersionV2)
+            }
+            if card.SourceVersion != tt.wantVersion {
+                t.Errorf("SourceVersion = %d, want %d", card.SourceVersion, tt.wantVersion)
+            }
+            if !reflect.DeepEqual(card.Data, tt.wantData) {
+                t.Errorf("Data = %+v\nwant %+v", card.Data, tt.wantData)
+            }
+            if !reflect.DeepEqual(card.Unknown, tt.wantUnknown) {
+                t.Errorf("Unknown = %v, want %v", card.Unknown, tt.wantUnknown)
+            }
+        })
+    }
+}
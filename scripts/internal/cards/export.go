@@ -0,0 +1,124 @@
+package cards
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "hash/fnv"
+    "image"
+    "image/color"
+    _ "image/gif"
+    _ "image/jpeg"
+    "image/png"
+)
+
+// Avatar size used by most frontends.
+const (
+    avatarWidth  = 400
+    avatarHeight = 600
+)
+
+// NewCard wraps data in a V2 card. Lists and extensions are never left
+// nil, since the spec requires them to be present.
+func NewCard(data Data) *Card {
+    if data.AlternateGreetings == nil {
+        data.AlternateGreetings = []string{}
+    }
+    if data.Tags == nil {
+        data.Tags = []string{}
+    }
+    if data.Extensions == nil {
+        data.Extensions = map[string]interface{}{}
+    }
+    return &Card{
+        Spec:          SpecV2,
+        SpecVersion:   SpecVersionV2,
+        Data:          data,
+        SourceVersion: 2,
+    }
+}
+
+// JSON encodes the card as V2 JSON.
+func (c *Card) JSON() ([]byte, error) {
+    return json.MarshalIndent(c, "", "  ")
+}
+
+// EmbedPNG returns avatar with the card stored in a "chara" tEXt chunk. Any
+// card already in the image is replaced. Avatars in other image formats are
+// converted to PNG first.
+func EmbedPNG(avatar []byte, card *Card) ([]byte, error) {
+    if !IsPNG(avatar) {
+        converted, err := toPNG(avatar)
+        if err != nil {
+            return nil, err
+        }
+        avatar = converted
+    }
+
+    chunks, err := readPNGChunks(avatar)
+    if err != nil {
+        return nil, err
+    }
+    encoded, err := json.Marshal(card)
+    if err != nil {
+        return nil, fmt.Errorf("error encoding card: %v", err)
+    }
+    text := append([]byte("chara\x00"), base64.StdEncoding.EncodeToString(encoded)...)
+
+    result := make([]pngChunk, 0, len(chunks)+1)
+    for _, chunk := range chunks {
+        if chunk.Type == "tEXt" && bytes.HasPrefix(chunk.Data, []byte("chara\x00")) {
+            continue
+        }
+        if chunk.Type == "IEND" {
+            result = append(result, pngChunk{Type: "tEXt", Data: text})
+        }
+        result = append(result, chunk)
+    }
+    return writePNGChunks(result), nil
+}
+
+// DefaultAvatar draws a plain avatar whose colours are derived from name,
+// for cards exported without an uploaded image.
+func DefaultAvatar(name string) []byte {
+    h := fnv.New32a()
+    h.Write([]byte(name))
+    sum := h.Sum32()
+    top := color.RGBA{R: uint8(sum), G: uint8(sum >> 8), B: uint8(sum >> 16), A: 255}
+    bottom := color.RGBA{R: top.R / 3, G: top.G / 3, B: top.B / 3, A: 255}
+
+    img := image.NewRGBA(image.Rect(0, 0, avatarWidth, avatarHeight))
+    for y := 0; y < avatarHeight; y++ {
+        c := color.RGBA{
+            R: blend(top.R, bottom.R, y, avatarHeight),
+            G: blend(top.G, bottom.G, y, avatarHeight),
+            B: blend(top.B, bottom.B, y, avatarHeight),
+            A: 255,
+        }
+        for x := 0; x < avatarWidth; x++ {
+            img.SetRGBA(x, y, c)
+        }
+    }
+
+    var buf bytes.Buffer
+    png.Encode(&buf, img)
+    return buf.Bytes()
+}
+
+func blend(from, to uint8, step, steps int) uint8 {
+    return uint8((int(from)*(steps-step) + int(to)*step) / steps)
+}
+
+// toPNG re-encodes a JPEG or GIF image as PNG.
+func toPNG(data []byte) ([]byte, error) {
+    img, _, err := image.Decode(bytes.NewReader(data))
+    if err != nil {
+        return nil, fmt.Errorf("avatar is not a PNG, JPEG or GIF image: %v", err)
+    }
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        return nil, fmt.Errorf("error converting avatar: %v", err)
+    }
+    return buf.Bytes(), nil
+}
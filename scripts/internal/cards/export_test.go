@@ -0,0 +1,93 @@
+package cards
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "reflect"
+    "testing"
+)
+
+func jpegAvatar(t *testing.T) []byte {
+    t.Helper()
+    img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+    for x := 0; x < 8; x++ {
+        img.SetRGBA(x, x, color.RGBA{R: 200, A: 255})
+    }
+    var buf bytes.Buffer
+    if err := jpeg.Encode(&buf, img, nil); err != nil {
+        t.Fatalf("jpeg.Encode: %v", err)
+    }
+    return buf.Bytes()
+}
+
+func TestExportRoundTrip(t *testing.T) {
+    data := Data{
+        Name:                    "Alice",
+        Description:             "A witch",
+        Personality:             "Curious",
+        Scenario:                "A forest",
+        FirstMes:                "Hello, {{user}}!",
+        MesExample:              "<START>\n{{user}}: Hi\n{{char}}: Hello",
+        PostHistoryInstructions: "Stay in character",
+        AlternateGreetings:      []string{"Well met"},
+    }
+    want := NewCard(data).Data
+
+    // A card already embedded in the avatar is replaced
+    stale, err := EmbedPNG(DefaultAvatar("stale"), NewCard(Data{Name: "Stale"}))
+    if err != nil {
+        t.Fatalf("EmbedPNG: %v", err)
+    }
+
+    tests := []struct {
+        name   string
+        export func(card *Card) ([]byte, error)
+    }{
+        {"JSON", func(card *Card) ([]byte, error) { return card.JSON() }},
+        {"PNG on the default avatar", func(card *Card) ([]byte, error) { return EmbedPNG(DefaultAvatar(card.Data.Name), card) }},
+        {"PNG on a JPEG avatar", func(card *Card) ([]byte, error) { return EmbedPNG(jpegAvatar(t), card) }},
+        {"PNG replacing an embedded card", func(card *Card) ([]byte, error) { return EmbedPNG(stale, card) }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            exported, err := tt.export(NewCard(data))
+            if err != nil {
+                t.Fatalf("export: %v", err)
+            }
+            card, err := Parse(exported)
+            if err != nil {
+                t.Fatalf("Parse: %v", err)
+            }
+            if card.SourceVersion != 2 {
+                t.Errorf("SourceVersion = %d, want 2", card.SourceVersion)
+            }
+            if !reflect.DeepEqual(card.Data, want) {
+                t.Errorf("Data = %+v\nwant %+v", card.Data, want)
+            }
+            if len(card.Unknown) > 0 {
+                t.Errorf("Unknown = %v, want none", card.Unknown)
+            }
+
+            if IsPNG(exported) {
+                chunks, err := readPNGChunks(exported)
+                if err != nil {
+                    t.Fatalf("readPNGChunks: %v", err)
+                }
+                charas := 0
+                for _, chunk := range chunks {
+                    if chunk.Type == "tEXt" && bytes.HasPrefix(chunk.Data, []byte("chara\x00")) {
+                        charas++
+                    }
+                }
+                if charas != 1 {
+                    t.Errorf("PNG has %d chara chunks, want 1", charas)
+                }
+                if _, _, err := image.Decode(bytes.NewReader(exported)); err != nil {
+                    t.Errorf("exported PNG does not decode: %v", err)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,94 @@
+package cards
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// maxChunkSize caps a single PNG chunk; real cards are far smaller.
+const maxChunkSize = 32 << 20
+
+var ErrNoCardChunk = errors.New("image has no character card in a chara chunk")
+
+// IsPNG reports whether data starts with the PNG signature.
+func IsPNG(data []byte) bool {
+    return bytes.HasPrefix(data, pngSignature)
+}
+
+// pngChunk is one chunk of a PNG file.
+type pngChunk struct {
+    Type string
+    Data []byte
+}
+
+// readPNGChunks splits a PNG file into its chunks, checking every CRC.
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+    if !IsPNG(data) {
+        return nil, fmt.Errorf("not a PNG file")
+    }
+
+    var chunks []pngChunk
+    rest := data[len(pngSignature):]
+    for len(rest) > 0 {
+        if len(rest) < 12 {
+            return nil, fmt.Errorf("truncated PNG chunk")
+        }
+        length := binary.BigEndian.Uint32(rest[:4])
+        if length > maxChunkSize || int(length) > len(rest)-12 {
+            return nil, fmt.Errorf("truncated PNG chunk")
+        }
+        typeAndData := rest[4 : 8+length]
+        crc := binary.BigEndian.Uint32(rest[8+length : 12+length])
+        if crc32.ChecksumIEEE(typeAndData) != crc {
+            return nil, fmt.Errorf("PNG chunk %q has a bad checksum", typeAndData[:4])
+        }
+
+        chunk := pngChunk{Type: string(typeAndData[:4]), Data: typeAndData[4:]}
+        chunks = append(chunks, chunk)
+        rest = rest[12+length:]
+        if chunk.Type == "IEND" {
+            break
+        }
+    }
+    return chunks, nil
+}
+
+// writePNGChunks joins chunks back into a PNG file.
+func writePNGChunks(chunks []pngChunk) []byte {
+    var buf bytes.Buffer
+    buf.Write(pngSignature)
+    for _, chunk := range chunks {
+        var header [8]byte
+        binary.BigEndian.PutUint32(header[:4], uint32(len(chunk.Data)))
+        copy(header[4:], chunk.Type)
+        buf.Write(header[:])
+        buf.Write(chunk.Data)
+
+        crc := crc32.NewIEEE()
+        crc.Write(header[4:])
+        crc.Write(chunk.Data)
+        var sum [4]byte
+        binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+        buf.Write(sum[:])
+    }
+    return buf.Bytes()
+}
+
+// textChunk returns the value of the tEXt chunk with the given keyword.
+func textChunk(chunks []pngChunk, keyword string) (string, bool) {
+    for _, chunk := range chunks {
+        if chunk.Type != "tEXt" {
+            continue
+        }
+        key, value, found := bytes.Cut(chunk.Data, []byte{0})
+        if found && string(key) == keyword {
+            return string(value), true
+        }
+    }
+    return "", false
+}
@@ -0,0 +1,185 @@
+package cards
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+)
+
+// World info positions, numbered as SillyTavern numbers them.
+const (
+    WorldBeforeChar     = 0
+    WorldAfterChar      = 1
+    WorldAuthorsNoteTop = 2
+    WorldAuthorsNoteEnd = 3
+    WorldAtDepth        = 4
+)
+
+// WorldEntry is one lorebook entry read from SillyTavern world info or from
+// the character_book of a V2 card.
+type WorldEntry struct {
+    Name          string
+    Keys          []string
+    SecondaryKeys []string
+    Content       string
+    Constant      bool
+    Selective     bool
+    CaseSensitive bool
+    Enabled       bool
+    Order         int
+    Position      int
+    Depth         int
+}
+
+// stEntry is an entry of a SillyTavern world info file.
+type stEntry struct {
+    Key           []string `json:"key"`
+    KeySecondary  []string `json:"keysecondary"`
+    Comment       string   `json:"comment"`
+    Content       string   `json:"content"`
+    Constant      bool     `json:"constant"`
+    Selective     bool     `json:"selective"`
+    Order         int      `json:"order"`
+    Position      int      `json:"position"`
+    Disable       bool     `json:"disable"`
+    Depth         int      `json:"depth"`
+    CaseSensitive *bool    `json:"caseSensitive"`
+}
+
+// bookEntry is an entry of a V2 card's character_book.
+type bookEntry struct {
+    Keys           []string `json:"keys"`
+    SecondaryKeys  []string `json:"secondary_keys"`
+    Name           string   `json:"name"`
+    Comment        string   `json:"comment"`
+    Content        string   `json:"content"`
+    Constant       bool     `json:"constant"`
+    Selective      bool     `json:"selective"`
+    InsertionOrder int      `json:"insertion_order"`
+    Enabled        *bool    `json:"enabled"`
+    Position       string   `json:"position"`
+    CaseSensitive  bool     `json:"case_sensitive"`
+    Extensions     struct {
+        Position *int `json:"position"`
+        Depth    int  `json:"depth"`
+    } `json:"extensions"`
+}
+
+// ParseWorldInfo reads lorebook entries from a SillyTavern world info file,
+// a bare character_book, or a character card (JSON or PNG) that embeds one.
+func ParseWorldInfo(data []byte) ([]WorldEntry, error) {
+    if IsPNG(data) {
+        card, err := Parse(data)
+        if err != nil {
+            return nil, err
+        }
+        return parseCharacterBook(card.Data.CharacterBook)
+    }
+
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+    }
+    if _, ok := fields["entries"]; !ok {
+        card, err := ParseJSON(data)
+        if err != nil {
+            return nil, err
+        }
+        return parseCharacterBook(card.Data.CharacterBook)
+    }
+
+    raw := fields["entries"]
+    var list []json.RawMessage
+    if json.Unmarshal(raw, &list) == nil {
+        return parseBookEntries(list)
+    }
+
+    // SillyTavern keys its entries by uid.
+    var byUID map[string]stEntry
+    if err := json.Unmarshal(raw, &byUID); err != nil {
+        return nil, fmt.Errorf("%w: entries: %v", ErrInvalidCard, err)
+    }
+    keys := make([]string, 0, len(byUID))
+    for key := range byUID {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        a, errA := strconv.Atoi(keys[i])
+        b, errB := strconv.Atoi(keys[j])
+        if errA == nil && errB == nil {
+            return a < b
+        }
+        return keys[i] < keys[j]
+    })
+
+    entries := make([]WorldEntry, 0, len(byUID))
+    for _, key := range keys {
+        st := byUID[key]
+        entry := WorldEntry{
+            Name:          st.Comment,
+            Keys:          st.Key,
+            SecondaryKeys: st.KeySecondary,
+            Content:       st.Content,
+            Constant:      st.Constant,
+            Selective:     st.Selective,
+            Enabled:       !st.Disable,
+            Order:         st.Order,
+            Position:      st.Position,
+            Depth:         st.Depth,
+        }
+        if st.CaseSensitive != nil {
+            entry.CaseSensitive = *st.CaseSensitive
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
+
+func parseCharacterBook(raw json.RawMessage) ([]WorldEntry, error) {
+    if isEmptyJSON(raw) {
+        return nil, fmt.Errorf("%w: card has no character book", ErrInvalidCard)
+    }
+    var book struct {
+        Entries []json.RawMessage `json:"entries"`
+    }
+    if err := json.Unmarshal(raw, &book); err != nil {
+        return nil, fmt.Errorf("%w: character_book: %v", ErrInvalidCard, err)
+    }
+    return parseBookEntries(book.Entries)
+}
+
+func parseBookEntries(list []json.RawMessage) ([]WorldEntry, error) {
+    entries := make([]WorldEntry, 0, len(list))
+    for n, raw := range list {
+        var book bookEntry
+        if err := json.Unmarshal(raw, &book); err != nil {
+            return nil, fmt.Errorf("%w: entry %d: %v", ErrInvalidCard, n+1, err)
+        }
+        entry := WorldEntry{
+            Name:          book.Name,
+            Keys:          book.Keys,
+            SecondaryKeys: book.SecondaryKeys,
+            Content:       book.Content,
+            Constant:      book.Constant,
+            Selective:     book.Selective,
+            CaseSensitive: book.CaseSensitive,
+            Enabled:       book.Enabled == nil || *book.Enabled,
+            Order:         book.InsertionOrder,
+            Position:      WorldBeforeChar,
+            Depth:         book.Extensions.Depth,
+        }
+        if entry.Name == "" {
+            entry.Name = book.Comment
+        }
+        if book.Position == "after_char" {
+            entry.Position = WorldAfterChar
+        }
+        // Cards exported by SillyTavern keep the full position here.
+        if book.Extensions.Position != nil {
+            entry.Position = *book.Extensions.Position
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
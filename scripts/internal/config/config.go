@@ -3,6 +3,7 @@ package config
 import (
     "os"
     "strconv"
+    "strings"
     "time"
     "github.com/joho/godotenv"
 )
@@ -12,6 +13,10 @@ type Config struct {
     DiscordToken  string
     GuildID       string
     
+    // Discord user IDs of the people running the bot. Only they can back up
+    // and restore the whole instance or restore other users' backups.
+    BotOwnerIDs []string
+    
     // OpenAI Proxy Configuration
     ProxyURL      string
     ProxyPassword string
@@ -22,12 +27,33 @@ type Config struct {
     DefaultModel    string
     DefaultTemp     float64
     MaxTokens      int
+    ContextTokens  int
+    
+    // Instruct mode for text-completion backends: the template name, a
+    // JSON file of custom templates, and the completions endpoint (next to
+    // PROXY_URL when empty). Chat completions are used when no template is
+    // set.
+    InstructTemplate      string
+    InstructTemplatesFile string
+    CompletionsURL        string
     
     // Timeouts and Limits
     RequestTimeout  time.Duration
     SessionTimeout time.Duration
+    SessionCleanupInterval time.Duration
     RateLimit      int
     
+    // Storage
+    DataDir                string
+    StorageBackend         string
+    JournalCompactInterval time.Duration
+    RetentionCheckInterval time.Duration
+    
+    // Encryption at rest, as "<key id>:<base64 32-byte key>". Old keys are
+    // a comma-separated list kept only to read data sealed before rotation.
+    EncryptionKey     string
+    EncryptionOldKeys string
+    
     // Development Mode
     Debug bool
 }
@@ -37,26 +63,44 @@ func Load() *Config {
 
     return &Config{
         // Discord
-        DiscordToken: getEnv("DISCORD_TOKEN"),
-        GuildID:      getEnv("GUILD_ID"),
+        DiscordToken: getEnv("DISCORD_TOKEN", ""),
+        GuildID:      getEnv("GUILD_ID", ""),
+        BotOwnerIDs:  getEnvList("BOT_OWNER_IDS"),
         
         // Proxy
-        ProxyURL:      getEnv("PROXY_URL"),
-        ProxyPassword: getEnv("PROXY_PASSWORD"),
+        ProxyURL:      getEnv("PROXY_URL", ""),
+        ProxyPassword: getEnv("PROXY_PASSWORD", ""),
         
         // Bot Settings
         DefaultPrefix: getEnv("DEFAULT_PREFIX", "/"),
         DefaultModel: getEnv("DEFAULT_MODEL", "chatgpt-4o-latest"),
         DefaultTemp:  getEnvFloat("DEFAULT_TEMPERATURE", 0.83),
         MaxTokens:    getEnvInt("MAX_TOKENS", 1096),
+        ContextTokens: getEnvInt("CONTEXT_TOKENS", 8192),
+        
+        // Instruct mode
+        InstructTemplate:      getEnv("INSTRUCT_TEMPLATE", ""),
+        InstructTemplatesFile: getEnv("INSTRUCT_TEMPLATES_FILE", ""),
+        CompletionsURL:        getEnv("COMPLETIONS_URL", ""),
         
         // Timeouts
         RequestTimeout:  time.Duration(getEnvInt("REQUEST_TIMEOUT", 30)) * time.Second,
         SessionTimeout: time.Duration(getEnvInt("SESSION_TIMEOUT", 3600)) * time.Second,
+        SessionCleanupInterval: time.Duration(getEnvInt("SESSION_CLEANUP_INTERVAL", 300)) * time.Second,
         RateLimit:      getEnvInt("RATE_LIMIT", 60),
         
+        // Storage
+        DataDir:        getEnv("DATA_DIR", "data"),
+        StorageBackend: getEnv("STORAGE_BACKEND", "sqlite"),
+        JournalCompactInterval: time.Duration(getEnvInt("JOURNAL_COMPACT_INTERVAL", 300)) * time.Second,
+        RetentionCheckInterval: time.Duration(getEnvInt("RETENTION_CHECK_INTERVAL", 3600)) * time.Second,
+        
+        // Encryption
+        EncryptionKey:     getEnv("ENCRYPTION_KEY", ""),
+        EncryptionOldKeys: getEnv("ENCRYPTION_OLD_KEYS", ""),
+        
         // Debug Mode
-        Debug: getEnvBool("DEBUG"),
+        Debug: getEnvBool("DEBUG", false),
     }
 }
 
@@ -67,6 +111,17 @@ func getEnv(key, fallback string) string {
     return fallback
 }
 
+// getEnvList splits a comma-separated variable, skipping empty entries.
+func getEnvList(key string) []string {
+    var values []string
+    for _, value := range strings.Split(os.Getenv(key), ",") {
+        if value = strings.TrimSpace(value); value != "" {
+            values = append(values, value)
+        }
+    }
+    return values
+}
+
 func getEnvInt(key string, fallback int) int {
     if value, exists := os.LookupEnv(key); exists {
         if intVal, err := strconv.Atoi(value); err == nil {
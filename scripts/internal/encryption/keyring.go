@@ -0,0 +1,265 @@
+package encryption
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Sealed values look like
+//
+//     enc:v1:<key id>:<base64 wrapped data key>:<base64 ciphertext>
+//
+// Every value gets its own random data key, which is encrypted ("wrapped")
+// with the master key named by <key id>. Rotating the master key only needs
+// the small data keys to be rewrapped, and old values stay readable for as
+// long as their master key is in the keyring.
+const sealedPrefix = "enc:v1:"
+
+const keySize = 32
+
+// signingLabel derives the signing keys from the master keys, so the same
+// key material is never used both to encrypt and to sign.
+const signingLabel = "signing:v1"
+
+var (
+    ErrUnknownKey   = errors.New("value was sealed with a key that is not in the keyring")
+    ErrMalformed    = errors.New("sealed value is malformed")
+    ErrBadSignature = errors.New("signature does not match")
+)
+
+// Keyring holds the master keys. New values are always sealed with the
+// primary key; the others are only used to open existing values.
+type Keyring struct {
+    primary string
+    keys    map[string][]byte
+}
+
+// NewKeyring creates a keyring whose primary key is primaryID. Keys must be
+// 32 bytes (AES-256).
+func NewKeyring(primaryID string, keys map[string][]byte) (*Keyring, error) {
+    if _, ok := keys[primaryID]; !ok {
+        return nil, fmt.Errorf("primary key %q is missing", primaryID)
+    }
+    for id, key := range keys {
+        if id == "" || strings.Contains(id, ":") {
+            return nil, fmt.Errorf("invalid key id %q", id)
+        }
+        if len(key) != keySize {
+            return nil, fmt.Errorf("key %q must be %d bytes, got %d", id, keySize, len(key))
+        }
+    }
+    return &Keyring{primary: primaryID, keys: keys}, nil
+}
+
+// ParseKeyring builds a keyring from config strings. primary is
+// "<id>:<base64 key>", old is a comma-separated list in the same form.
+func ParseKeyring(primary, old string) (*Keyring, error) {
+    keys := make(map[string][]byte)
+
+    primaryID, key, err := parseKey(primary)
+    if err != nil {
+        return nil, fmt.Errorf("invalid primary key: %v", err)
+    }
+    keys[primaryID] = key
+
+    for _, spec := range strings.Split(old, ",") {
+        spec = strings.TrimSpace(spec)
+        if spec == "" {
+            continue
+        }
+        id, key, err := parseKey(spec)
+        if err != nil {
+            return nil, fmt.Errorf("invalid old key: %v", err)
+        }
+        if _, exists := keys[id]; exists {
+            return nil, fmt.Errorf("duplicate key id %q", id)
+        }
+        keys[id] = key
+    }
+
+    return NewKeyring(primaryID, keys)
+}
+
+// HasOldKeys reports whether the keyring holds keys besides the primary,
+// meaning stored values may need re-encrypting.
+func (k *Keyring) HasOldKeys() bool {
+    return len(k.keys) > 1
+}
+
+// Seal encrypts plaintext with a fresh data key wrapped by the primary key.
+func (k *Keyring) Seal(plaintext string) (string, error) {
+    dataKey := make([]byte, keySize)
+    if _, err := rand.Read(dataKey); err != nil {
+        return "", fmt.Errorf("error generating data key: %v", err)
+    }
+
+    wrapped, err := gcmSeal(k.keys[k.primary], dataKey)
+    if err != nil {
+        return "", err
+    }
+    ciphertext, err := gcmSeal(dataKey, []byte(plaintext))
+    if err != nil {
+        return "", err
+    }
+
+    return sealedPrefix + k.primary + ":" +
+        base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+        base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a sealed value. Values that were never sealed are returned
+// unchanged, so data written before encryption was enabled stays readable.
+func (k *Keyring) Open(value string) (string, error) {
+    if !IsSealed(value) {
+        return value, nil
+    }
+
+    parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+    if len(parts) != 3 {
+        return "", ErrMalformed
+    }
+    masterKey, ok := k.keys[parts[0]]
+    if !ok {
+        return "", fmt.Errorf("%w: %s", ErrUnknownKey, parts[0])
+    }
+
+    wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+    if err != nil {
+        return "", ErrMalformed
+    }
+    ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+    if err != nil {
+        return "", ErrMalformed
+    }
+
+    dataKey, err := gcmOpen(masterKey, wrapped)
+    if err != nil {
+        return "", err
+    }
+    plaintext, err := gcmOpen(dataKey, ciphertext)
+    if err != nil {
+        return "", err
+    }
+    return string(plaintext), nil
+}
+
+// Sign returns an HMAC-SHA256 signature of data made with the primary key,
+// as "<key id>:<base64 MAC>".
+func (k *Keyring) Sign(data []byte) string {
+    return k.primary + ":" + base64.RawStdEncoding.EncodeToString(signMAC(k.keys[k.primary], data))
+}
+
+// Verify checks a signature made by Sign with any key in the keyring.
+func (k *Keyring) Verify(data []byte, signature string) error {
+    id, encoded, ok := strings.Cut(signature, ":")
+    if !ok {
+        return ErrMalformed
+    }
+    masterKey, ok := k.keys[id]
+    if !ok {
+        return fmt.Errorf("%w: %s", ErrUnknownKey, id)
+    }
+    mac, err := base64.RawStdEncoding.DecodeString(encoded)
+    if err != nil {
+        return ErrMalformed
+    }
+    if !hmac.Equal(mac, signMAC(masterKey, data)) {
+        return ErrBadSignature
+    }
+    return nil
+}
+
+// LoadOrCreateKeyFile returns a keyring holding the single key stored in
+// path, generating and saving a random key there first if the file does not
+// exist. It gives instances without ENCRYPTION_KEY a key to sign with.
+func LoadOrCreateKeyFile(path, id string) (*Keyring, error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        key := make([]byte, keySize)
+        if _, err := rand.Read(key); err != nil {
+            return nil, fmt.Errorf("error generating key: %v", err)
+        }
+        data = []byte(base64.StdEncoding.EncodeToString(key))
+        if err := os.WriteFile(path, data, 0o600); err != nil {
+            return nil, fmt.Errorf("error saving key: %v", err)
+        }
+    } else if err != nil {
+        return nil, fmt.Errorf("error reading key: %v", err)
+    }
+
+    key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil {
+        return nil, fmt.Errorf("key file %s is not valid base64: %v", path, err)
+    }
+    return NewKeyring(id, map[string][]byte{id: key})
+}
+
+func signMAC(masterKey, data []byte) []byte {
+    derive := hmac.New(sha256.New, masterKey)
+    derive.Write([]byte(signingLabel))
+    mac := hmac.New(sha256.New, derive.Sum(nil))
+    mac.Write(data)
+    return mac.Sum(nil)
+}
+
+// IsSealed reports whether value was produced by Seal.
+func IsSealed(value string) bool {
+    return strings.HasPrefix(value, sealedPrefix)
+}
+
+func parseKey(spec string) (string, []byte, error) {
+    id, encoded, ok := strings.Cut(spec, ":")
+    if !ok {
+        return "", nil, errors.New(`expected "<id>:<base64 key>"`)
+    }
+    key, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil {
+        return "", nil, fmt.Errorf("key %q is not valid base64: %v", id, err)
+    }
+    return id, key, nil
+}
+
+// gcmSeal encrypts data with AES-GCM, prefixing the random nonce.
+func gcmSeal(key, data []byte) ([]byte, error) {
+    gcm, err := newGCM(key)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, fmt.Errorf("error generating nonce: %v", err)
+    }
+    return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func gcmOpen(key, data []byte) ([]byte, error) {
+    gcm, err := newGCM(key)
+    if err != nil {
+        return nil, err
+    }
+    if len(data) < gcm.NonceSize() {
+        return nil, ErrMalformed
+    }
+    nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+    plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+    if err != nil {
+        return nil, fmt.Errorf("error decrypting value: %v", err)
+    }
+    return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("error creating cipher: %v", err)
+    }
+    return cipher.NewGCM(block)
+}
@@ -0,0 +1,175 @@
+package encryption
+
+import (
+    "bytes"
+    "encoding/base64"
+    "errors"
+    "strings"
+    "testing"
+)
+
+func testKey(b byte) []byte {
+    return bytes.Repeat([]byte{b}, keySize)
+}
+
+func keySpec(id string, b byte) string {
+    return id + ":" + base64.StdEncoding.EncodeToString(testKey(b))
+}
+
+func TestKeyringRotation(t *testing.T) {
+    old, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    rotated, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    retired, err := NewKeyring("k2", map[string][]byte{"k2": testKey(2)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+
+    sealedOld, err := old.Seal("secret")
+    if err != nil {
+        t.Fatalf("Seal: %v", err)
+    }
+    // Re-encrypting is opening with the rotated keyring and sealing again
+    plaintext, err := rotated.Open(sealedOld)
+    if err != nil {
+        t.Fatalf("opening an old value after rotation: %v", err)
+    }
+    resealed, err := rotated.Seal(plaintext)
+    if err != nil {
+        t.Fatalf("Seal: %v", err)
+    }
+
+    tests := []struct {
+        name    string
+        keyring *Keyring
+        value   string
+        want    string
+        wantErr error
+    }{
+        {"old value with its key", old, sealedOld, "secret", nil},
+        {"old value after rotation", rotated, sealedOld, "secret", nil},
+        {"old value after retiring its key", retired, sealedOld, "", ErrUnknownKey},
+        {"re-encrypted value after retiring the old key", retired, resealed, "secret", nil},
+        {"plaintext passes through", retired, "not sealed", "not sealed", nil},
+        {"malformed value", retired, sealedPrefix + "k2:abc", "", ErrMalformed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := tt.keyring.Open(tt.value)
+            if tt.wantErr != nil {
+                if !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("Open error = %v, want %v", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Open: %v", err)
+            }
+            if got != tt.want {
+                t.Errorf("Open = %q, want %q", got, tt.want)
+            }
+        })
+    }
+
+    if !strings.HasPrefix(resealed, sealedPrefix+"k2:") {
+        t.Errorf("re-encrypted value %q is not sealed with the primary key", resealed)
+    }
+}
+
+func TestParseKeyring(t *testing.T) {
+    tests := []struct {
+        name      string
+        primary   string
+        old       string
+        wantErr   bool
+        wantOld   bool
+    }{
+        {"primary only", keySpec("k1", 1), "", false, false},
+        {"with old keys", keySpec("k2", 2), keySpec("k1", 1) + ", " + keySpec("k0", 0), false, true},
+        {"missing id", base64.StdEncoding.EncodeToString(testKey(1)), "", true, false},
+        {"bad base64", "k1:not base64!", "", true, false},
+        {"short key", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "", true, false},
+        {"duplicate id", keySpec("k1", 1), keySpec("k1", 2), true, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            keyring, err := ParseKeyring(tt.primary, tt.old)
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatal("ParseKeyring succeeded, want an error")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseKeyring: %v", err)
+            }
+            if keyring.HasOldKeys() != tt.wantOld {
+                t.Errorf("HasOldKeys = %v, want %v", keyring.HasOldKeys(), tt.wantOld)
+            }
+        })
+    }
+}
+
+func TestSignAndVerify(t *testing.T) {
+    old, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    rotated, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    other, err := NewKeyring("k1", map[string][]byte{"k1": testKey(3)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+
+    data := []byte("manifest")
+    signature := old.Sign(data)
+    tests := []struct {
+        name      string
+        keyring   *Keyring
+        data      []byte
+        signature string
+        wantErr   error
+    }{
+        {"same keyring", old, data, signature, nil},
+        {"after rotation", rotated, data, signature, nil},
+        {"changed data", old, []byte("manifest!"), signature, ErrBadSignature},
+        {"different key with the same id", other, data, signature, ErrBadSignature},
+        {"unknown key", old, data, rotated.Sign(data), ErrUnknownKey},
+        {"no key id", old, data, "abc", ErrMalformed},
+        {"empty", old, data, "", ErrMalformed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := tt.keyring.Verify(tt.data, tt.signature); !errors.Is(err, tt.wantErr) {
+                t.Errorf("Verify error = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+
+    if signature == old.Sign([]byte("other")) {
+        t.Error("different data got the same signature")
+    }
+}
+
+func TestLoadOrCreateKeyFile(t *testing.T) {
+    path := t.TempDir() + "/signing.key"
+    first, err := LoadOrCreateKeyFile(path, "local")
+    if err != nil {
+        t.Fatalf("creating the key file: %v", err)
+    }
+    second, err := LoadOrCreateKeyFile(path, "local")
+    if err != nil {
+        t.Fatalf("loading the key file: %v", err)
+    }
+    if err := second.Verify([]byte("data"), first.Sign([]byte("data"))); err != nil {
+        t.Errorf("the reloaded key does not verify: %v", err)
+    }
+}
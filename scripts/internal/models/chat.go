@@ -18,6 +18,7 @@ type Message struct {
     ChatID    string    `json:"chat_id"`
     Role      string    `json:"role"`      // system, user, assistant
     Content   string    `json:"content"`
+    Speaker   string    `json:"speaker"`   // group chat member, assistant messages only
     CreatedAt time.Time `json:"created_at"`
     Metadata  MessageMetadata `json:"metadata"`
 }
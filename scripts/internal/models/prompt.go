@@ -1,6 +1,7 @@
 package models
 
 import (
+    "math/rand"
     "time"
 )
 
@@ -10,31 +11,106 @@ type Prompt struct {
     Type      string    `json:"type"`      // system, user, assistant
     Content   string    `json:"content"`
     Depth     int       `json:"depth"`     // Order in the prompt list
+    Block     string    `json:"block"`     // Built-in block, empty for user entries
+    Enabled   bool      `json:"enabled"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
 }
 
 type PromptDefinitions struct {
+    Name          string `json:"name"`
     Description    string `json:"description"`
     Personality   string `json:"personality"`
     Scenario      string `json:"scenario"`
     FirstMessage  string `json:"first_message"`
+    AlternateGreetings []string `json:"alternate_greetings"`
+    ExampleDialogue string `json:"example_dialogue"`
+    PostHistoryInstructions string `json:"post_history_instructions"`
+    SharedFrom    string `json:"shared_from"`
+    SharedVersion int    `json:"shared_version"`
     AuthorsNote   string `json:"authors_note"`
+    AuthorsNoteDepth int `json:"authors_note_depth"`
+    AuthorsNoteRole string `json:"authors_note_role"`
 }
 
 type UserSettings struct {
     UserID      string    `json:"user_id"`
     UserPersona string    `json:"user_persona"`
     UserToken   string    `json:"user_token"`
+    LoreScanDepth   int   `json:"lore_scan_depth"`
+    LoreTokenBudget int   `json:"lore_token_budget"`
     CreatedAt   time.Time `json:"created_at"`
     UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// Character is an inactive entry of a user's character library. The active
+// character lives in PromptList.Definitions.
+type Character struct {
+    ID              string `json:"id"`
+    UserID          string `json:"user_id"`
+    Name            string `json:"name"`
+    Description     string `json:"description"`
+    Personality     string `json:"personality"`
+    Scenario        string `json:"scenario"`
+    FirstMessage    string `json:"first_message"`
+    // AlternateGreetings are stored as a JSON array.
+    AlternateGreetings []string `json:"alternate_greetings"`
+    ExampleDialogue string `json:"example_dialogue"`
+    PostHistoryInstructions string `json:"post_history_instructions"`
+    // SharedFrom and SharedVersion track copies of a guild's shared
+    // characters.
+    SharedFrom      string `json:"shared_from"`
+    SharedVersion   int    `json:"shared_version"`
+}
+
+// LoreEntry is an entry of a user's lorebook. Keys are stored as JSON
+// arrays.
+type LoreEntry struct {
+    ID            string   `json:"id"`
+    UserID        string   `json:"user_id"`
+    Name          string   `json:"name"`
+    Keys          []string `json:"keys"`
+    SecondaryKeys []string `json:"secondary_keys"`
+    Content       string   `json:"content"`
+    Position      string   `json:"position"`
+    Depth         int      `json:"depth"`
+    Order         int      `json:"order"`
+    CaseSensitive bool     `json:"case_sensitive"`
+    Selective     bool     `json:"selective"`
+    Constant      bool     `json:"constant"`
+    Enabled       bool     `json:"enabled"`
+}
+
+// GroupChat lists the characters taking part in a group chat by ID. The
+// members are stored as a JSON array.
+type GroupChat struct {
+    Members   []string `json:"members"`
+    TurnOrder string   `json:"turn_order"`
+    Turn      int      `json:"turn"`
+}
+
+// Persona is an entry of a user's persona library. The characters and
+// channels it is bound to are stored as JSON arrays.
+type Persona struct {
+    ID          string   `json:"id"`
+    UserID      string   `json:"user_id"`
+    Name        string   `json:"name"`
+    Description string   `json:"description"`
+    Characters  []string `json:"characters"`
+    Channels    []string `json:"channels"`
+}
+
 type PromptList struct {
     UserID      string    `json:"user_id"`
     Prompts     []Prompt  `json:"prompts"`
     Definitions PromptDefinitions `json:"definitions"`
     Settings    UserSettings      `json:"settings"`
+    CharacterID string            `json:"character_id"`
+    Characters  []Character       `json:"characters"`
+    Group       GroupChat         `json:"group"`
+    LoreEntries []LoreEntry       `json:"lore_entries"`
+    PersonaID   string            `json:"persona_id"`
+    Personas    []Persona         `json:"personas"`
 }
 
 func NewPromptList(userID string) *PromptList {
@@ -21,6 +21,9 @@ type Settings struct {
     MaxTokens       int     `json:"max_tokens"`
     Model           string  `json:"model"`
     StreamResponses bool    `json:"stream_responses"`
+    PresencePenalty  float64 `json:"presence_penalty"`
+    FrequencyPenalty float64 `json:"frequency_penalty"`
+    TopP             float64 `json:"top_p"`
     Language        string  `json:"language"`
     Theme           string  `json:"theme"`
 }
@@ -35,6 +38,8 @@ func NewUser(discordID, username string) *User {
             MaxTokens:       1096,
             Model:           "gpt-4-turbo",
             StreamResponses: true,
+            FrequencyPenalty: 0.6,
+            TopP:             0.99,
             Language:        "en",
             Theme:           "default",
         },
@@ -0,0 +1,330 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/models"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+const (
+    chatKindSession = "session"
+    chatKindSaved   = "saved"
+)
+
+// ChatRepository stores chats and their messages. It implements
+// services.SessionStore: each user's active session is a chat of kind
+// "session", saved chat slots are chats of kind "saved".
+type ChatRepository struct {
+    db *sql.DB
+}
+
+var _ services.SessionStore = (*ChatRepository)(nil)
+
+// chatInfo holds the columns of a chat that models.Chat has no place for.
+type chatInfo struct {
+    Title       string
+    CharacterID string
+}
+
+func (r *ChatRepository) Load(userID string) (*services.ChatSession, error) {
+    chat, _, err := r.getChat(r.db, `user_id = ? AND kind = ?`, userID, chatKindSession)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, services.ErrSessionNotFound
+        }
+        return nil, err
+    }
+    return toSession(chat), nil
+}
+
+func (r *ChatRepository) Save(userID string, session *services.ChatSession) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    chat, _, err := r.getChat(tx, `user_id = ? AND kind = ?`, userID, chatKindSession)
+    if errors.Is(err, sql.ErrNoRows) {
+        chat = models.NewChat(userID)
+    } else if err != nil {
+        return err
+    }
+
+    chat.Messages = toModelMessages(chat.ID, session.Messages)
+    chat.UpdatedAt = session.LastActivity
+    if last := chat.GetLastMessage(); last != nil {
+        chat.LastMessageAt = last.CreatedAt
+    }
+
+    if err := r.putChat(tx, chat, chatKindSession, chatInfo{}); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (r *ChatRepository) Delete(userID string) error {
+    _, err := r.db.Exec(`DELETE FROM chats WHERE user_id = ? AND kind = ?`, userID, chatKindSession)
+    if err != nil {
+        return fmt.Errorf("error deleting session: %v", err)
+    }
+    return nil
+}
+
+func (r *ChatRepository) List() ([]string, error) {
+    rows, err := r.db.Query(`SELECT user_id FROM chats WHERE kind = ? ORDER BY user_id`, chatKindSession)
+    if err != nil {
+        return nil, fmt.Errorf("error listing sessions: %v", err)
+    }
+    defer rows.Close()
+
+    var userIDs []string
+    for rows.Next() {
+        var userID string
+        if err := rows.Scan(&userID); err != nil {
+            return nil, fmt.Errorf("error scanning session: %v", err)
+        }
+        userIDs = append(userIDs, userID)
+    }
+    return userIDs, rows.Err()
+}
+
+func (r *ChatRepository) SaveChat(saved *services.SavedChat) error {
+    chat := &models.Chat{
+        ID:            saved.ID,
+        UserID:        saved.OwnerID,
+        Messages:      toModelMessages(saved.ID, saved.Messages),
+        CreatedAt:     saved.CreatedAt,
+        UpdatedAt:     saved.CreatedAt,
+        LastMessageAt: saved.CreatedAt,
+    }
+    if last := chat.GetLastMessage(); last != nil {
+        chat.LastMessageAt = last.CreatedAt
+    }
+
+    tx, err := r.db.Begin()
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    if err := r.putChat(tx, chat, chatKindSaved, chatInfo{Title: saved.Title, CharacterID: saved.CharacterID}); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (r *ChatRepository) LoadChat(chatID string) (*services.SavedChat, error) {
+    chat, info, err := r.getChat(r.db, `id = ? AND kind = ?`, chatID, chatKindSaved)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, services.ErrChatNotFound
+        }
+        return nil, err
+    }
+    return toSavedChat(chat, info), nil
+}
+
+func (r *ChatRepository) ListChats(ownerID string) ([]*services.SavedChat, error) {
+    rows, err := r.db.Query(`SELECT id FROM chats WHERE user_id = ? AND kind = ? ORDER BY created_at DESC`, ownerID, chatKindSaved)
+    if err != nil {
+        return nil, fmt.Errorf("error listing saved chats: %v", err)
+    }
+
+    var chatIDs []string
+    for rows.Next() {
+        var chatID string
+        if err := rows.Scan(&chatID); err != nil {
+            rows.Close()
+            return nil, fmt.Errorf("error scanning saved chat: %v", err)
+        }
+        chatIDs = append(chatIDs, chatID)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    chats := make([]*services.SavedChat, 0, len(chatIDs))
+    for _, chatID := range chatIDs {
+        chat, err := r.LoadChat(chatID)
+        if err != nil {
+            return nil, err
+        }
+        chats = append(chats, chat)
+    }
+    return chats, nil
+}
+
+func (r *ChatRepository) DeleteChat(chatID string) error {
+    _, err := r.db.Exec(`DELETE FROM chats WHERE id = ? AND kind = ?`, chatID, chatKindSaved)
+    if err != nil {
+        return fmt.Errorf("error deleting saved chat: %v", err)
+    }
+    return nil
+}
+
+// SearchMessages returns up to limit messages from any user's chats whose
+// content contains text, newest first.
+func (r *ChatRepository) SearchMessages(text string, limit int) ([]models.Message, error) {
+    rows, err := r.db.Query(`
+        SELECT chat_id, id, role, content, speaker, created_at, token_count, temperature, model, is_streaming
+        FROM messages
+        WHERE content LIKE '%' || ? || '%'
+        ORDER BY created_at DESC
+        LIMIT ?`, text, limit)
+    if err != nil {
+        return nil, fmt.Errorf("error searching messages: %v", err)
+    }
+    defer rows.Close()
+    return scanMessages(rows)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getChat loads the single chat matching where, along with its title and
+// character.
+func (r *ChatRepository) getChat(q queryer, where string, args ...interface{}) (*models.Chat, chatInfo, error) {
+    chat := &models.Chat{}
+    var info chatInfo
+    err := q.QueryRow(`
+        SELECT id, user_id, title, character_id, created_at, updated_at, last_message_at
+        FROM chats
+        WHERE `+where, args...).
+        Scan(&chat.ID, &chat.UserID, &info.Title, &info.CharacterID, &chat.CreatedAt, &chat.UpdatedAt, &chat.LastMessageAt)
+    if err != nil {
+        return nil, info, err
+    }
+
+    rows, err := q.Query(`
+        SELECT chat_id, id, role, content, speaker, created_at, token_count, temperature, model, is_streaming
+        FROM messages
+        WHERE chat_id = ?
+        ORDER BY seq`, chat.ID)
+    if err != nil {
+        return nil, info, fmt.Errorf("error loading messages: %v", err)
+    }
+    defer rows.Close()
+
+    chat.Messages, err = scanMessages(rows)
+    if err != nil {
+        return nil, info, err
+    }
+    return chat, info, nil
+}
+
+// putChat inserts or updates a chat with its messages. An existing chat
+// with the same ID is only updated when it has the same owner and kind, so
+// one user can never overwrite another's chat.
+func (r *ChatRepository) putChat(q queryer, chat *models.Chat, kind string, info chatInfo) error {
+    result, err := q.Exec(`
+        INSERT INTO chats (id, user_id, kind, title, character_id, created_at, updated_at, last_message_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET
+            title = excluded.title,
+            character_id = excluded.character_id,
+            updated_at = excluded.updated_at,
+            last_message_at = excluded.last_message_at
+        WHERE chats.user_id = excluded.user_id AND chats.kind = excluded.kind`,
+        chat.ID, chat.UserID, kind, info.Title, info.CharacterID, chat.CreatedAt, chat.UpdatedAt, chat.LastMessageAt)
+    if err != nil {
+        return fmt.Errorf("error saving chat: %v", err)
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("error saving chat: %v", err)
+    }
+    if affected == 0 {
+        return services.ErrChatNotOwned
+    }
+
+    if _, err := q.Exec(`DELETE FROM messages WHERE chat_id = ?`, chat.ID); err != nil {
+        return fmt.Errorf("error clearing messages: %v", err)
+    }
+
+    for seq, msg := range chat.Messages {
+        _, err := q.Exec(`
+            INSERT INTO messages (chat_id, seq, id, role, content, speaker, created_at, token_count, temperature, model, is_streaming)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            chat.ID, seq, msg.ID, msg.Role, msg.Content, msg.Speaker, msg.CreatedAt,
+            msg.Metadata.TokenCount, msg.Metadata.Temperature, msg.Metadata.Model, msg.Metadata.IsStreaming)
+        if err != nil {
+            return fmt.Errorf("error saving message: %v", err)
+        }
+    }
+    return nil
+}
+
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
+    messages := make([]models.Message, 0)
+    for rows.Next() {
+        var msg models.Message
+        err := rows.Scan(&msg.ChatID, &msg.ID, &msg.Role, &msg.Content, &msg.Speaker, &msg.CreatedAt,
+            &msg.Metadata.TokenCount, &msg.Metadata.Temperature, &msg.Metadata.Model, &msg.Metadata.IsStreaming)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning message: %v", err)
+        }
+        messages = append(messages, msg)
+    }
+    return messages, rows.Err()
+}
+
+func toSession(chat *models.Chat) *services.ChatSession {
+    return &services.ChatSession{
+        Messages:     toServiceMessages(chat.Messages),
+        LastActivity: chat.UpdatedAt,
+    }
+}
+
+func toSavedChat(chat *models.Chat, info chatInfo) *services.SavedChat {
+    return &services.SavedChat{
+        ID:          chat.ID,
+        OwnerID:     chat.UserID,
+        Title:       info.Title,
+        CharacterID: info.CharacterID,
+        CreatedAt:   chat.CreatedAt,
+        Messages:    toServiceMessages(chat.Messages),
+    }
+}
+
+func toServiceMessages(messages []models.Message) []services.Message {
+    result := make([]services.Message, len(messages))
+    for i, msg := range messages {
+        result[i] = services.Message{
+            ID:        msg.ID,
+            Role:      msg.Role,
+            Content:   msg.Content,
+            Timestamp: msg.CreatedAt,
+            Speaker:   msg.Speaker,
+        }
+    }
+    return result
+}
+
+func toModelMessages(chatID string, messages []services.Message) []models.Message {
+    result := make([]models.Message, len(messages))
+    for i, msg := range messages {
+        createdAt := msg.Timestamp
+        if createdAt.IsZero() {
+            createdAt = time.Now()
+        }
+        result[i] = models.Message{
+            ID:        msg.ID,
+            ChatID:    chatID,
+            Role:      msg.Role,
+            Content:   msg.Content,
+            Speaker:   msg.Speaker,
+            CreatedAt: createdAt,
+        }
+    }
+    return result
+}
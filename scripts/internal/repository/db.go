@@ -0,0 +1,100 @@
+package repository
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+
+    _ "modernc.org/sqlite"
+)
+
+// DB wraps the embedded SQLite database that backs the repositories.
+type DB struct {
+    conn *sql.DB
+}
+
+// Open opens (creating if needed) the SQLite database at path and applies
+// any pending migrations.
+func Open(path string) (*DB, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, fmt.Errorf("error creating database directory: %v", err)
+    }
+
+    dsn := path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+    conn, err := sql.Open("sqlite", dsn)
+    if err != nil {
+        return nil, fmt.Errorf("error opening database: %v", err)
+    }
+
+    // SQLite allows a single writer; one connection avoids SQLITE_BUSY
+    // between our own goroutines.
+    conn.SetMaxOpenConns(1)
+
+    db := &DB{conn: conn}
+    if err := db.migrate(); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return db, nil
+}
+
+func (db *DB) Close() error {
+    return db.conn.Close()
+}
+
+// Chats returns the repository for chat sessions.
+func (db *DB) Chats() *ChatRepository {
+    return &ChatRepository{db: db.conn}
+}
+
+// Prompts returns the repository for prompt definitions.
+func (db *DB) Prompts() *PromptRepository {
+    return &PromptRepository{db: db.conn}
+}
+
+// Users returns the repository for users and their settings.
+func (db *DB) Users() *UserRepository {
+    return &UserRepository{db: db.conn}
+}
+
+func (db *DB) migrate() error {
+    _, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version    INTEGER PRIMARY KEY,
+        applied_at DATETIME NOT NULL
+    )`)
+    if err != nil {
+        return fmt.Errorf("error creating migrations table: %v", err)
+    }
+
+    var current int
+    err = db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+    if err != nil {
+        return fmt.Errorf("error reading schema version: %v", err)
+    }
+
+    for i, migration := range migrations {
+        version := i + 1
+        if version <= current {
+            continue
+        }
+
+        tx, err := db.conn.Begin()
+        if err != nil {
+            return fmt.Errorf("error starting migration %d: %v", version, err)
+        }
+        if _, err := tx.Exec(migration); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("error applying migration %d: %v", version, err)
+        }
+        if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now()); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("error recording migration %d: %v", version, err)
+        }
+        if err := tx.Commit(); err != nil {
+            return fmt.Errorf("error committing migration %d: %v", version, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+    "errors"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+func openTestDB(t *testing.T, path string) *DB {
+    t.Helper()
+    db, err := Open(path)
+    if err != nil {
+        t.Fatalf("Open: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+func schemaVersion(t *testing.T, db *DB) int {
+    t.Helper()
+    var version int
+    if err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+        t.Fatalf("reading schema version: %v", err)
+    }
+    return version
+}
+
+func TestMigrationChain(t *testing.T) {
+    tests := []struct {
+        name string
+        // from is the schema version the database is at before Open
+        // applies the rest of the chain.
+        from int
+    }{
+        {"fresh database", 0},
+        {"from the first schema", 1},
+        {"from before the prompt stack", 6},
+        {"already current", len(migrations)},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "bot.db")
+            if tt.from > 0 {
+                all := migrations
+                migrations = all[:tt.from]
+                db, err := Open(path)
+                migrations = all
+                if err != nil {
+                    t.Fatalf("applying the first %d migrations: %v", tt.from, err)
+                }
+                db.Close()
+            }
+
+            db := openTestDB(t, path)
+            if got := schemaVersion(t, db); got != len(migrations) {
+                t.Errorf("schema version = %d, want %d", got, len(migrations))
+            }
+            var applied int
+            if err := db.conn.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+                t.Fatalf("counting migrations: %v", err)
+            }
+            if applied != len(migrations) {
+                t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+            }
+        })
+    }
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "bot.db")
+    first, err := Open(path)
+    if err != nil {
+        t.Fatalf("Open: %v", err)
+    }
+    first.Close()
+
+    db := openTestDB(t, path)
+    if got := schemaVersion(t, db); got != len(migrations) {
+        t.Errorf("schema version after reopening = %d, want %d", got, len(migrations))
+    }
+}
+
+func TestChatRepositorySessions(t *testing.T) {
+    db := openTestDB(t, filepath.Join(t.TempDir(), "bot.db"))
+    chats := db.Chats()
+    now := time.Now().Truncate(time.Second)
+
+    tests := []struct {
+        name     string
+        userID   string
+        messages []services.Message
+    }{
+        {"empty session", "user-a", nil},
+        {"one message", "user-b", []services.Message{
+            {ID: "1", Role: "user", Content: "hello", Timestamp: now},
+        }},
+        {"group chat", "user-c", []services.Message{
+            {ID: "1", Role: "system", Content: "stack", Timestamp: now},
+            {ID: "2", Role: "user", Content: "hi all", Timestamp: now},
+            {ID: "3", Role: "assistant", Content: "hey", Timestamp: now, Speaker: "Alice"},
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := chats.Load(tt.userID); !errors.Is(err, services.ErrSessionNotFound) {
+                t.Fatalf("Load before Save: err = %v, want ErrSessionNotFound", err)
+            }
+            session := &services.ChatSession{Messages: tt.messages, LastActivity: now}
+            if err := chats.Save(tt.userID, session); err != nil {
+                t.Fatalf("Save: %v", err)
+            }
+            // Saving again replaces the session rather than adding one
+            if err := chats.Save(tt.userID, session); err != nil {
+                t.Fatalf("second Save: %v", err)
+            }
+
+            loaded, err := chats.Load(tt.userID)
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            if len(loaded.Messages) != len(tt.messages) {
+                t.Fatalf("loaded %d messages, want %d", len(loaded.Messages), len(tt.messages))
+            }
+            for i, want := range tt.messages {
+                got := loaded.Messages[i]
+                if got.ID != want.ID || got.Role != want.Role || got.Content != want.Content || got.Speaker != want.Speaker {
+                    t.Errorf("message %d = %+v, want %+v", i, got, want)
+                }
+            }
+        })
+    }
+
+    userIDs, err := chats.List()
+    if err != nil {
+        t.Fatalf("List: %v", err)
+    }
+    if len(userIDs) != len(tests) {
+        t.Errorf("List = %v, want %d sessions", userIDs, len(tests))
+    }
+    if err := chats.Delete("user-b"); err != nil {
+        t.Fatalf("Delete: %v", err)
+    }
+    if _, err := chats.Load("user-b"); !errors.Is(err, services.ErrSessionNotFound) {
+        t.Errorf("Load after Delete: err = %v, want ErrSessionNotFound", err)
+    }
+}
+
+func TestSaveChatKeepsOwners(t *testing.T) {
+    db := openTestDB(t, filepath.Join(t.TempDir(), "bot.db"))
+    chats := db.Chats()
+    now := time.Now().Truncate(time.Second)
+
+    if err := chats.Save("alice", &services.ChatSession{LastActivity: now}); err != nil {
+        t.Fatalf("Save: %v", err)
+    }
+    var sessionID string
+    if err := db.conn.QueryRow(`SELECT id FROM chats WHERE user_id = ? AND kind = ?`, "alice", chatKindSession).Scan(&sessionID); err != nil {
+        t.Fatalf("reading the session ID: %v", err)
+    }
+    saved := func(id, owner, title string) *services.SavedChat {
+        return &services.SavedChat{ID: id, OwnerID: owner, Title: title, CreatedAt: now,
+            Messages: []services.Message{{ID: "1", Role: "user", Content: title, Timestamp: now}}}
+    }
+    if err := chats.SaveChat(saved("chat-1", "alice", "Alice's chat")); err != nil {
+        t.Fatalf("SaveChat: %v", err)
+    }
+
+    tests := []struct {
+        name    string
+        chat    *services.SavedChat
+        wantErr error
+    }{
+        {"owner updates their chat", saved("chat-1", "alice", "Renamed"), nil},
+        {"another user's chat", saved("chat-1", "bob", "Bob's chat"), services.ErrChatNotOwned},
+        {"another user's session", saved(sessionID, "bob", "Bob's chat"), services.ErrChatNotOwned},
+        {"the owner's session", saved(sessionID, "alice", "Alice's chat"), services.ErrChatNotOwned},
+        {"new chat", saved("chat-2", "bob", "Bob's chat"), nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := chats.SaveChat(tt.chat); !errors.Is(err, tt.wantErr) {
+                t.Fatalf("SaveChat error = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+
+    chat, err := chats.LoadChat("chat-1")
+    if err != nil {
+        t.Fatalf("LoadChat: %v", err)
+    }
+    if chat.OwnerID != "alice" || chat.Title != "Renamed" || chat.Messages[0].Content != "Renamed" {
+        t.Errorf("chat-1 = %+v, want Alice's renamed chat", chat)
+    }
+    if _, err := chats.Load("alice"); err != nil {
+        t.Errorf("Alice's session after the rejected saves: %v", err)
+    }
+}
@@ -0,0 +1,205 @@
+package repository
+
+// migrations are applied in order; the schema version is the index of the
+// last applied entry plus one. Never edit an entry once it has shipped,
+// append a new one instead.
+var migrations = []string{
+    // 1: users, chats, messages and prompt lists
+    `
+    CREATE TABLE users (
+        id                TEXT PRIMARY KEY,
+        discord_id        TEXT NOT NULL UNIQUE,
+        username          TEXT NOT NULL DEFAULT '',
+        model             TEXT NOT NULL,
+        temperature       REAL NOT NULL,
+        max_tokens        INTEGER NOT NULL,
+        stream_responses  INTEGER NOT NULL,
+        presence_penalty  REAL NOT NULL DEFAULT 0,
+        frequency_penalty REAL NOT NULL DEFAULT 0,
+        top_p             REAL NOT NULL DEFAULT 1,
+        language          TEXT NOT NULL DEFAULT 'en',
+        theme             TEXT NOT NULL DEFAULT 'default',
+        created_at        DATETIME NOT NULL,
+        updated_at        DATETIME NOT NULL,
+        last_active_at    DATETIME NOT NULL
+    );
+
+    CREATE TABLE chats (
+        id              TEXT PRIMARY KEY,
+        user_id         TEXT NOT NULL,
+        kind            TEXT NOT NULL DEFAULT 'session',
+        created_at      DATETIME NOT NULL,
+        updated_at      DATETIME NOT NULL,
+        last_message_at DATETIME NOT NULL
+    );
+    CREATE UNIQUE INDEX idx_chats_session ON chats (user_id) WHERE kind = 'session';
+
+    CREATE TABLE messages (
+        chat_id      TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+        seq          INTEGER NOT NULL,
+        id           TEXT NOT NULL DEFAULT '',
+        role         TEXT NOT NULL,
+        content      TEXT NOT NULL,
+        created_at   DATETIME NOT NULL,
+        token_count  INTEGER NOT NULL DEFAULT 0,
+        temperature  REAL NOT NULL DEFAULT 0,
+        model        TEXT NOT NULL DEFAULT '',
+        is_streaming INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (chat_id, seq)
+    );
+    CREATE INDEX idx_messages_id ON messages (id);
+
+    CREATE TABLE prompt_lists (
+        user_id       TEXT PRIMARY KEY,
+        description   TEXT NOT NULL DEFAULT '',
+        personality   TEXT NOT NULL DEFAULT '',
+        scenario      TEXT NOT NULL DEFAULT '',
+        first_message TEXT NOT NULL DEFAULT '',
+        authors_note  TEXT NOT NULL DEFAULT '',
+        user_persona  TEXT NOT NULL DEFAULT '',
+        user_token    TEXT NOT NULL DEFAULT '',
+        created_at    DATETIME NOT NULL,
+        updated_at    DATETIME NOT NULL
+    );
+
+    CREATE TABLE prompts (
+        id         TEXT PRIMARY KEY,
+        user_id    TEXT NOT NULL REFERENCES prompt_lists (user_id) ON DELETE CASCADE,
+        type       TEXT NOT NULL,
+        content    TEXT NOT NULL,
+        depth      INTEGER NOT NULL,
+        created_at DATETIME NOT NULL,
+        updated_at DATETIME NOT NULL
+    );
+    CREATE INDEX idx_prompts_user ON prompts (user_id, depth);
+    `,
+
+    // 2: titles for saved chat slots
+    `
+    ALTER TABLE chats ADD COLUMN title TEXT NOT NULL DEFAULT '';
+    CREATE INDEX idx_chats_user_kind ON chats (user_id, kind);
+    `,
+
+    // 3: character name and example dialogue from imported cards
+    `
+    ALTER TABLE prompt_lists ADD COLUMN name TEXT NOT NULL DEFAULT '';
+    ALTER TABLE prompt_lists ADD COLUMN example_dialogue TEXT NOT NULL DEFAULT '';
+    `,
+
+    // 4: character library; sessions of inactive characters are parked as
+    // saved chats tagged with the character
+    `
+    ALTER TABLE prompt_lists ADD COLUMN character_id TEXT NOT NULL DEFAULT '';
+
+    CREATE TABLE characters (
+        id               TEXT NOT NULL,
+        user_id          TEXT NOT NULL REFERENCES prompt_lists (user_id) ON DELETE CASCADE,
+        position         INTEGER NOT NULL,
+        name             TEXT NOT NULL,
+        description      TEXT NOT NULL DEFAULT '',
+        personality      TEXT NOT NULL DEFAULT '',
+        scenario         TEXT NOT NULL DEFAULT '',
+        first_message    TEXT NOT NULL DEFAULT '',
+        example_dialogue TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (user_id, id)
+    );
+
+    ALTER TABLE chats ADD COLUMN character_id TEXT NOT NULL DEFAULT '';
+    `,
+
+    // 5: author's note placement
+    `
+    ALTER TABLE prompt_lists ADD COLUMN authors_note_depth INTEGER NOT NULL DEFAULT 4;
+    ALTER TABLE prompt_lists ADD COLUMN authors_note_role TEXT NOT NULL DEFAULT 'system';
+    `,
+
+    // 6: lorebooks
+    `
+    ALTER TABLE prompt_lists ADD COLUMN lore_scan_depth INTEGER NOT NULL DEFAULT 4;
+    ALTER TABLE prompt_lists ADD COLUMN lore_token_budget INTEGER NOT NULL DEFAULT 512;
+
+    CREATE TABLE lore_entries (
+        id              TEXT NOT NULL,
+        user_id         TEXT NOT NULL REFERENCES prompt_lists (user_id) ON DELETE CASCADE,
+        position        INTEGER NOT NULL,
+        name            TEXT NOT NULL,
+        keys            TEXT NOT NULL DEFAULT '[]',
+        secondary_keys  TEXT NOT NULL DEFAULT '[]',
+        content         TEXT NOT NULL,
+        placement       TEXT NOT NULL DEFAULT 'before',
+        depth           INTEGER NOT NULL DEFAULT 0,
+        insertion_order INTEGER NOT NULL DEFAULT 0,
+        case_sensitive  INTEGER NOT NULL DEFAULT 0,
+        selective       INTEGER NOT NULL DEFAULT 0,
+        constant        INTEGER NOT NULL DEFAULT 0,
+        enabled         INTEGER NOT NULL DEFAULT 1,
+        PRIMARY KEY (user_id, id)
+    );
+    `,
+
+    // 7: editable prompt stack; the built-in blocks are stored as entries.
+    // Their IDs are the block names, so entries are keyed per user.
+    `
+    CREATE TABLE prompts_by_user (
+        id         TEXT NOT NULL,
+        user_id    TEXT NOT NULL REFERENCES prompt_lists (user_id) ON DELETE CASCADE,
+        type       TEXT NOT NULL,
+        content    TEXT NOT NULL,
+        depth      INTEGER NOT NULL,
+        block      TEXT NOT NULL DEFAULT '',
+        enabled    INTEGER NOT NULL DEFAULT 1,
+        created_at DATETIME NOT NULL,
+        updated_at DATETIME NOT NULL,
+        PRIMARY KEY (user_id, id)
+    );
+    INSERT INTO prompts_by_user (id, user_id, type, content, depth, created_at, updated_at)
+        SELECT id, user_id, type, content, depth, created_at, updated_at FROM prompts;
+    DROP TABLE prompts;
+    ALTER TABLE prompts_by_user RENAME TO prompts;
+    CREATE INDEX idx_prompts_user ON prompts (user_id, depth);
+    `,
+
+    // 8: alternate greetings
+    `
+    ALTER TABLE prompt_lists ADD COLUMN alternate_greetings TEXT NOT NULL DEFAULT '[]';
+    ALTER TABLE characters ADD COLUMN alternate_greetings TEXT NOT NULL DEFAULT '[]';
+    `,
+
+    // 9: post-history instructions
+    `
+    ALTER TABLE prompt_lists ADD COLUMN post_history_instructions TEXT NOT NULL DEFAULT '';
+    ALTER TABLE characters ADD COLUMN post_history_instructions TEXT NOT NULL DEFAULT '';
+    `,
+
+    // 10: group chats
+    `
+    ALTER TABLE prompt_lists ADD COLUMN group_members TEXT NOT NULL DEFAULT '[]';
+    ALTER TABLE prompt_lists ADD COLUMN group_turn_order TEXT NOT NULL DEFAULT '';
+    ALTER TABLE prompt_lists ADD COLUMN group_turn INTEGER NOT NULL DEFAULT 0;
+    ALTER TABLE messages ADD COLUMN speaker TEXT NOT NULL DEFAULT '';
+    `,
+
+    // 11: copies of guild shared characters
+    `
+    ALTER TABLE prompt_lists ADD COLUMN shared_from TEXT NOT NULL DEFAULT '';
+    ALTER TABLE prompt_lists ADD COLUMN shared_version INTEGER NOT NULL DEFAULT 0;
+    ALTER TABLE characters ADD COLUMN shared_from TEXT NOT NULL DEFAULT '';
+    ALTER TABLE characters ADD COLUMN shared_version INTEGER NOT NULL DEFAULT 0;
+    `,
+
+    // 12: persona library
+    `
+    ALTER TABLE prompt_lists ADD COLUMN persona_id TEXT NOT NULL DEFAULT '';
+
+    CREATE TABLE personas (
+        id          TEXT NOT NULL,
+        user_id     TEXT NOT NULL REFERENCES prompt_lists (user_id) ON DELETE CASCADE,
+        position    INTEGER NOT NULL,
+        name        TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        characters  TEXT NOT NULL DEFAULT '[]',
+        channels    TEXT NOT NULL DEFAULT '[]',
+        PRIMARY KEY (user_id, id)
+    );
+    `,
+}
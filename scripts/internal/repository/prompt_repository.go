@@ -0,0 +1,509 @@
+package repository
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/models"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+// PromptRepository stores prompt lists. It implements services.PromptStore.
+type PromptRepository struct {
+    db *sql.DB
+}
+
+var _ services.PromptStore = (*PromptRepository)(nil)
+
+func (r *PromptRepository) Load(userID string) (*services.UserPrompts, error) {
+    list := models.NewPromptList(userID)
+    defs := &list.Definitions
+    settings := &list.Settings
+
+    var greetings, members string
+    err := r.db.QueryRow(`
+        SELECT name, description, personality, scenario, first_message, alternate_greetings, example_dialogue,
+               post_history_instructions, authors_note, authors_note_depth, authors_note_role, user_persona, user_token, character_id,
+               lore_scan_depth, lore_token_budget, group_members, group_turn_order, group_turn, shared_from, shared_version,
+               persona_id, created_at, updated_at
+        FROM prompt_lists
+        WHERE user_id = ?`, userID).
+        Scan(&defs.Name, &defs.Description, &defs.Personality, &defs.Scenario, &defs.FirstMessage, &greetings, &defs.ExampleDialogue,
+            &defs.PostHistoryInstructions, &defs.AuthorsNote, &defs.AuthorsNoteDepth, &defs.AuthorsNoteRole, &settings.UserPersona, &settings.UserToken, &list.CharacterID,
+            &settings.LoreScanDepth, &settings.LoreTokenBudget, &members, &list.Group.TurnOrder, &list.Group.Turn,
+            &defs.SharedFrom, &defs.SharedVersion, &list.PersonaID, &settings.CreatedAt, &settings.UpdatedAt)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, services.ErrPromptsNotFound
+        }
+        return nil, fmt.Errorf("error loading prompts: %v", err)
+    }
+    if err := json.Unmarshal([]byte(greetings), &defs.AlternateGreetings); err != nil {
+        return nil, fmt.Errorf("error decoding alternate greetings: %v", err)
+    }
+    if err := json.Unmarshal([]byte(members), &list.Group.Members); err != nil {
+        return nil, fmt.Errorf("error decoding group members: %v", err)
+    }
+
+    rows, err := r.db.Query(`
+        SELECT id, type, content, depth, block, enabled, created_at, updated_at
+        FROM prompts
+        WHERE user_id = ?
+        ORDER BY depth`, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error loading prompt entries: %v", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        prompt := models.Prompt{UserID: userID}
+        if err := rows.Scan(&prompt.ID, &prompt.Type, &prompt.Content, &prompt.Depth, &prompt.Block, &prompt.Enabled,
+            &prompt.CreatedAt, &prompt.UpdatedAt); err != nil {
+            return nil, fmt.Errorf("error scanning prompt entry: %v", err)
+        }
+        list.Prompts = append(list.Prompts, prompt)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    if list.Characters, err = r.loadCharacters(userID); err != nil {
+        return nil, err
+    }
+    if list.LoreEntries, err = r.loadLoreEntries(userID); err != nil {
+        return nil, err
+    }
+    if list.Personas, err = r.loadPersonas(userID); err != nil {
+        return nil, err
+    }
+    return toUserPrompts(list), nil
+}
+
+func (r *PromptRepository) Save(userID string, prompts *services.UserPrompts) error {
+    list := toPromptList(userID, prompts)
+
+    tx, err := r.db.Begin()
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    defs := list.Definitions
+    settings := list.Settings
+    greetings, err := json.Marshal(defs.AlternateGreetings)
+    if err != nil {
+        return fmt.Errorf("error encoding alternate greetings: %v", err)
+    }
+    members, err := json.Marshal(list.Group.Members)
+    if err != nil {
+        return fmt.Errorf("error encoding group members: %v", err)
+    }
+    _, err = tx.Exec(`
+        INSERT INTO prompt_lists (user_id, name, description, personality, scenario, first_message, alternate_greetings,
+                                  example_dialogue, post_history_instructions, authors_note, authors_note_depth,
+                                  authors_note_role, user_persona, user_token, character_id, lore_scan_depth,
+                                  lore_token_budget, group_members, group_turn_order, group_turn, shared_from,
+                                  shared_version, persona_id, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (user_id) DO UPDATE SET
+            name = excluded.name,
+            description = excluded.description,
+            personality = excluded.personality,
+            scenario = excluded.scenario,
+            first_message = excluded.first_message,
+            alternate_greetings = excluded.alternate_greetings,
+            example_dialogue = excluded.example_dialogue,
+            post_history_instructions = excluded.post_history_instructions,
+            authors_note = excluded.authors_note,
+            authors_note_depth = excluded.authors_note_depth,
+            authors_note_role = excluded.authors_note_role,
+            user_persona = excluded.user_persona,
+            user_token = excluded.user_token,
+            character_id = excluded.character_id,
+            lore_scan_depth = excluded.lore_scan_depth,
+            lore_token_budget = excluded.lore_token_budget,
+            group_members = excluded.group_members,
+            group_turn_order = excluded.group_turn_order,
+            group_turn = excluded.group_turn,
+            shared_from = excluded.shared_from,
+            shared_version = excluded.shared_version,
+            persona_id = excluded.persona_id,
+            updated_at = excluded.updated_at`,
+        userID, defs.Name, defs.Description, defs.Personality, defs.Scenario, defs.FirstMessage, string(greetings), defs.ExampleDialogue,
+        defs.PostHistoryInstructions, defs.AuthorsNote, defs.AuthorsNoteDepth, defs.AuthorsNoteRole, settings.UserPersona, settings.UserToken, list.CharacterID,
+        settings.LoreScanDepth, settings.LoreTokenBudget, string(members), list.Group.TurnOrder, list.Group.Turn,
+        defs.SharedFrom, defs.SharedVersion, list.PersonaID, settings.CreatedAt, settings.UpdatedAt)
+    if err != nil {
+        return fmt.Errorf("error saving prompts: %v", err)
+    }
+
+    if _, err := tx.Exec(`DELETE FROM prompts WHERE user_id = ?`, userID); err != nil {
+        return fmt.Errorf("error clearing prompt entries: %v", err)
+    }
+    for _, prompt := range list.Prompts {
+        _, err := tx.Exec(`
+            INSERT INTO prompts (id, user_id, type, content, depth, block, enabled, created_at, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            prompt.ID, userID, prompt.Type, prompt.Content, prompt.Depth, prompt.Block, prompt.Enabled,
+            prompt.CreatedAt, prompt.UpdatedAt)
+        if err != nil {
+            return fmt.Errorf("error saving prompt entry: %v", err)
+        }
+    }
+
+    if _, err := tx.Exec(`DELETE FROM characters WHERE user_id = ?`, userID); err != nil {
+        return fmt.Errorf("error clearing characters: %v", err)
+    }
+    for position, character := range list.Characters {
+        greetings, err := json.Marshal(character.AlternateGreetings)
+        if err != nil {
+            return fmt.Errorf("error encoding alternate greetings: %v", err)
+        }
+        _, err = tx.Exec(`
+            INSERT INTO characters (id, user_id, position, name, description, personality, scenario,
+                                    first_message, alternate_greetings, example_dialogue, post_history_instructions,
+                                    shared_from, shared_version)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            character.ID, userID, position, character.Name, character.Description, character.Personality,
+            character.Scenario, character.FirstMessage, string(greetings), character.ExampleDialogue,
+            character.PostHistoryInstructions, character.SharedFrom, character.SharedVersion)
+        if err != nil {
+            return fmt.Errorf("error saving character: %v", err)
+        }
+    }
+
+    if _, err := tx.Exec(`DELETE FROM lore_entries WHERE user_id = ?`, userID); err != nil {
+        return fmt.Errorf("error clearing lorebook: %v", err)
+    }
+    for position, entry := range list.LoreEntries {
+        keys, err := json.Marshal(entry.Keys)
+        if err != nil {
+            return fmt.Errorf("error encoding lorebook keys: %v", err)
+        }
+        secondaryKeys, err := json.Marshal(entry.SecondaryKeys)
+        if err != nil {
+            return fmt.Errorf("error encoding lorebook keys: %v", err)
+        }
+        _, err = tx.Exec(`
+            INSERT INTO lore_entries (id, user_id, position, name, keys, secondary_keys, content, placement,
+                                      depth, insertion_order, case_sensitive, selective, constant, enabled)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            entry.ID, userID, position, entry.Name, string(keys), string(secondaryKeys), entry.Content, entry.Position,
+            entry.Depth, entry.Order, entry.CaseSensitive, entry.Selective, entry.Constant, entry.Enabled)
+        if err != nil {
+            return fmt.Errorf("error saving lorebook entry: %v", err)
+        }
+    }
+
+    if _, err := tx.Exec(`DELETE FROM personas WHERE user_id = ?`, userID); err != nil {
+        return fmt.Errorf("error clearing personas: %v", err)
+    }
+    for position, persona := range list.Personas {
+        characters, err := json.Marshal(persona.Characters)
+        if err != nil {
+            return fmt.Errorf("error encoding persona bindings: %v", err)
+        }
+        channels, err := json.Marshal(persona.Channels)
+        if err != nil {
+            return fmt.Errorf("error encoding persona bindings: %v", err)
+        }
+        _, err = tx.Exec(`
+            INSERT INTO personas (id, user_id, position, name, description, characters, channels)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+            persona.ID, userID, position, persona.Name, persona.Description, string(characters), string(channels))
+        if err != nil {
+            return fmt.Errorf("error saving persona: %v", err)
+        }
+    }
+
+    return tx.Commit()
+}
+
+func (r *PromptRepository) loadCharacters(userID string) ([]models.Character, error) {
+    rows, err := r.db.Query(`
+        SELECT id, name, description, personality, scenario, first_message, alternate_greetings, example_dialogue,
+               post_history_instructions, shared_from, shared_version
+        FROM characters
+        WHERE user_id = ?
+        ORDER BY position`, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error loading characters: %v", err)
+    }
+    defer rows.Close()
+
+    var characters []models.Character
+    for rows.Next() {
+        character := models.Character{UserID: userID}
+        var greetings string
+        err := rows.Scan(&character.ID, &character.Name, &character.Description, &character.Personality,
+            &character.Scenario, &character.FirstMessage, &greetings, &character.ExampleDialogue,
+            &character.PostHistoryInstructions, &character.SharedFrom, &character.SharedVersion)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning character: %v", err)
+        }
+        if err := json.Unmarshal([]byte(greetings), &character.AlternateGreetings); err != nil {
+            return nil, fmt.Errorf("error decoding alternate greetings: %v", err)
+        }
+        characters = append(characters, character)
+    }
+    return characters, rows.Err()
+}
+
+func (r *PromptRepository) loadLoreEntries(userID string) ([]models.LoreEntry, error) {
+    rows, err := r.db.Query(`
+        SELECT id, name, keys, secondary_keys, content, placement, depth, insertion_order,
+               case_sensitive, selective, constant, enabled
+        FROM lore_entries
+        WHERE user_id = ?
+        ORDER BY position`, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error loading lorebook: %v", err)
+    }
+    defer rows.Close()
+
+    var entries []models.LoreEntry
+    for rows.Next() {
+        entry := models.LoreEntry{UserID: userID}
+        var keys, secondaryKeys string
+        err := rows.Scan(&entry.ID, &entry.Name, &keys, &secondaryKeys, &entry.Content, &entry.Position,
+            &entry.Depth, &entry.Order, &entry.CaseSensitive, &entry.Selective, &entry.Constant, &entry.Enabled)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning lorebook entry: %v", err)
+        }
+        if err := json.Unmarshal([]byte(keys), &entry.Keys); err != nil {
+            return nil, fmt.Errorf("error decoding lorebook keys: %v", err)
+        }
+        if err := json.Unmarshal([]byte(secondaryKeys), &entry.SecondaryKeys); err != nil {
+            return nil, fmt.Errorf("error decoding lorebook keys: %v", err)
+        }
+        entries = append(entries, entry)
+    }
+    return entries, rows.Err()
+}
+
+func (r *PromptRepository) loadPersonas(userID string) ([]models.Persona, error) {
+    rows, err := r.db.Query(`
+        SELECT id, name, description, characters, channels
+        FROM personas
+        WHERE user_id = ?
+        ORDER BY position`, userID)
+    if err != nil {
+        return nil, fmt.Errorf("error loading personas: %v", err)
+    }
+    defer rows.Close()
+
+    var personas []models.Persona
+    for rows.Next() {
+        persona := models.Persona{UserID: userID}
+        var characters, channels string
+        if err := rows.Scan(&persona.ID, &persona.Name, &persona.Description, &characters, &channels); err != nil {
+            return nil, fmt.Errorf("error scanning persona: %v", err)
+        }
+        if err := json.Unmarshal([]byte(characters), &persona.Characters); err != nil {
+            return nil, fmt.Errorf("error decoding persona bindings: %v", err)
+        }
+        if err := json.Unmarshal([]byte(channels), &persona.Channels); err != nil {
+            return nil, fmt.Errorf("error decoding persona bindings: %v", err)
+        }
+        personas = append(personas, persona)
+    }
+    return personas, rows.Err()
+}
+
+func (r *PromptRepository) Delete(userID string) error {
+    if _, err := r.db.Exec(`DELETE FROM prompt_lists WHERE user_id = ?`, userID); err != nil {
+        return fmt.Errorf("error deleting prompts: %v", err)
+    }
+    return nil
+}
+
+func (r *PromptRepository) List() ([]string, error) {
+    rows, err := r.db.Query(`SELECT user_id FROM prompt_lists ORDER BY user_id`)
+    if err != nil {
+        return nil, fmt.Errorf("error listing prompts: %v", err)
+    }
+    defer rows.Close()
+
+    var userIDs []string
+    for rows.Next() {
+        var userID string
+        if err := rows.Scan(&userID); err != nil {
+            return nil, fmt.Errorf("error scanning prompts: %v", err)
+        }
+        userIDs = append(userIDs, userID)
+    }
+    return userIDs, rows.Err()
+}
+
+func toPromptList(userID string, prompts *services.UserPrompts) *models.PromptList {
+    list := models.NewPromptList(userID)
+    list.Definitions = models.PromptDefinitions{
+        Name:            prompts.Name,
+        Description:     prompts.Description,
+        Personality:     prompts.Personality,
+        Scenario:        prompts.Scenario,
+        FirstMessage:    prompts.FirstMessage,
+        AlternateGreetings: prompts.AlternateGreetings,
+        ExampleDialogue: prompts.ExampleDialogue,
+        PostHistoryInstructions: prompts.PostHistoryInstructions,
+        SharedFrom:      prompts.SharedFrom,
+        SharedVersion:   prompts.SharedVersion,
+        AuthorsNote:     prompts.AuthorsNote,
+        AuthorsNoteDepth: prompts.AuthorsNoteDepth,
+        AuthorsNoteRole:  prompts.AuthorsNoteRole,
+    }
+    list.Settings.UserPersona = prompts.UserPersona
+    list.Settings.UserToken = prompts.UserToken
+    list.Settings.LoreScanDepth = prompts.Lorebook.ScanDepth
+    list.Settings.LoreTokenBudget = prompts.Lorebook.TokenBudget
+    list.Settings.UpdatedAt = time.Now()
+    list.CharacterID = prompts.ID
+    list.PersonaID = prompts.PersonaID
+    list.Group = models.GroupChat{
+        Members:   prompts.Group.Members,
+        TurnOrder: prompts.Group.TurnOrder,
+        Turn:      prompts.Group.Turn,
+    }
+
+    for _, character := range prompts.Characters {
+        list.Characters = append(list.Characters, models.Character{
+            ID:              character.ID,
+            UserID:          userID,
+            Name:            character.Name,
+            Description:     character.Description,
+            Personality:     character.Personality,
+            Scenario:        character.Scenario,
+            FirstMessage:    character.FirstMessage,
+            AlternateGreetings: character.AlternateGreetings,
+            ExampleDialogue: character.ExampleDialogue,
+            PostHistoryInstructions: character.PostHistoryInstructions,
+            SharedFrom:      character.SharedFrom,
+            SharedVersion:   character.SharedVersion,
+        })
+    }
+
+    for _, entry := range prompts.Lorebook.Entries {
+        list.LoreEntries = append(list.LoreEntries, models.LoreEntry{
+            ID:            entry.ID,
+            UserID:        userID,
+            Name:          entry.Name,
+            Keys:          entry.Keys,
+            SecondaryKeys: entry.SecondaryKeys,
+            Content:       entry.Content,
+            Position:      entry.Position,
+            Depth:         entry.Depth,
+            Order:         entry.Order,
+            CaseSensitive: entry.CaseSensitive,
+            Selective:     entry.Selective,
+            Constant:      entry.Constant,
+            Enabled:       entry.Enabled,
+        })
+    }
+
+    for _, persona := range prompts.Personas {
+        list.Personas = append(list.Personas, models.Persona{
+            ID:          persona.ID,
+            UserID:      userID,
+            Name:        persona.Name,
+            Description: persona.Description,
+            Characters:  persona.Characters,
+            Channels:    persona.Channels,
+        })
+    }
+
+    for i, entry := range prompts.Prompts {
+        list.AddPrompt(entry.Content, entry.Role, i)
+        prompt := &list.Prompts[len(list.Prompts)-1]
+        prompt.ID = entry.ID
+        prompt.Block = entry.Block
+        prompt.Enabled = entry.Enabled
+    }
+    return list
+}
+
+func toUserPrompts(list *models.PromptList) *services.UserPrompts {
+    prompts := &services.UserPrompts{
+        Character: services.Character{
+            ID:              list.CharacterID,
+            Name:            list.Definitions.Name,
+            Description:     list.Definitions.Description,
+            Personality:     list.Definitions.Personality,
+            Scenario:        list.Definitions.Scenario,
+            FirstMessage:    list.Definitions.FirstMessage,
+            AlternateGreetings: list.Definitions.AlternateGreetings,
+            ExampleDialogue: list.Definitions.ExampleDialogue,
+            PostHistoryInstructions: list.Definitions.PostHistoryInstructions,
+            SharedFrom:      list.Definitions.SharedFrom,
+            SharedVersion:   list.Definitions.SharedVersion,
+        },
+        AuthorsNote:   list.Definitions.AuthorsNote,
+        AuthorsNoteDepth: list.Definitions.AuthorsNoteDepth,
+        AuthorsNoteRole:  list.Definitions.AuthorsNoteRole,
+        UserPersona:   list.Settings.UserPersona,
+        UserToken:     list.Settings.UserToken,
+        Prompts:       make([]services.PromptEntry, 0, len(list.Prompts)),
+        Lorebook: services.Lorebook{
+            ScanDepth:   list.Settings.LoreScanDepth,
+            TokenBudget: list.Settings.LoreTokenBudget,
+        },
+        Group: services.GroupChat{
+            Members:   list.Group.Members,
+            TurnOrder: list.Group.TurnOrder,
+            Turn:      list.Group.Turn,
+        },
+        PersonaID: list.PersonaID,
+    }
+    for _, prompt := range list.Prompts {
+        prompts.Prompts = append(prompts.Prompts, services.PromptEntry{
+            ID:      prompt.ID,
+            Role:    prompt.Type,
+            Content: prompt.Content,
+            Block:   prompt.Block,
+            Enabled: prompt.Enabled,
+        })
+    }
+    for _, character := range list.Characters {
+        prompts.Characters = append(prompts.Characters, services.Character{
+            ID:              character.ID,
+            Name:            character.Name,
+            Description:     character.Description,
+            Personality:     character.Personality,
+            Scenario:        character.Scenario,
+            FirstMessage:    character.FirstMessage,
+            AlternateGreetings: character.AlternateGreetings,
+            ExampleDialogue: character.ExampleDialogue,
+            PostHistoryInstructions: character.PostHistoryInstructions,
+            SharedFrom:      character.SharedFrom,
+            SharedVersion:   character.SharedVersion,
+        })
+    }
+    for _, entry := range list.LoreEntries {
+        prompts.Lorebook.Entries = append(prompts.Lorebook.Entries, services.LoreEntry{
+            ID:            entry.ID,
+            Name:          entry.Name,
+            Keys:          entry.Keys,
+            SecondaryKeys: entry.SecondaryKeys,
+            Content:       entry.Content,
+            Position:      entry.Position,
+            Depth:         entry.Depth,
+            Order:         entry.Order,
+            CaseSensitive: entry.CaseSensitive,
+            Selective:     entry.Selective,
+            Constant:      entry.Constant,
+            Enabled:       entry.Enabled,
+        })
+    }
+    for _, persona := range list.Personas {
+        prompts.Personas = append(prompts.Personas, services.Persona{
+            ID:          persona.ID,
+            Name:        persona.Name,
+            Description: persona.Description,
+            Characters:  persona.Characters,
+            Channels:    persona.Channels,
+        })
+    }
+    return prompts
+}
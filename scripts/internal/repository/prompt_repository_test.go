@@ -0,0 +1,130 @@
+package repository
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+func stackIDs(stack []services.PromptEntry) []string {
+    ids := make([]string, len(stack))
+    for i, entry := range stack {
+        ids[i] = entry.ID
+    }
+    return ids
+}
+
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// TestPromptStacksForTwoUsers saves the built-in blocks, whose IDs are the
+// same for everyone, for two users.
+func TestPromptStacksForTwoUsers(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "bot.db")
+    db := openTestDB(t, path)
+    pm := services.NewPromptManager(db.Prompts())
+
+    users := []struct {
+        id     string
+        prompt string
+        moved  string
+    }{
+        {"alice", "Be brief", services.BlockPersona},
+        {"bob", "Be verbose", services.BlockScenario},
+    }
+    want := make(map[string][]string)
+    for _, user := range users {
+        if _, err := pm.AddPrompt(user.id, "system", user.prompt, 1); err != nil {
+            t.Fatalf("AddPrompt for %s: %v", user.id, err)
+        }
+        if _, err := pm.MovePrompt(user.id, user.moved, 1); err != nil {
+            t.Fatalf("MovePrompt for %s: %v", user.id, err)
+        }
+        want[user.id] = stackIDs(pm.PromptStack(user.id))
+    }
+
+    // A new manager reads both stacks back from the database
+    reloaded := services.NewPromptManager(db.Prompts())
+    for _, user := range users {
+        t.Run(user.id, func(t *testing.T) {
+            stack := reloaded.PromptStack(user.id)
+            if got := stackIDs(stack); !equalStrings(got, want[user.id]) {
+                t.Errorf("stack = %q, want %q", got, want[user.id])
+            }
+            if stack[0].Block != user.moved || stack[1].Content != user.prompt {
+                t.Errorf("stack starts with %+v, %+v", stack[0], stack[1])
+            }
+        })
+    }
+}
+
+// TestPromptStackMigration upgrades prompt entries written before the
+// stack was editable.
+func TestPromptStackMigration(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "bot.db")
+    all := migrations
+    migrations = all[:6]
+    old, err := Open(path)
+    migrations = all
+    if err != nil {
+        t.Fatalf("applying the first 6 migrations: %v", err)
+    }
+    now := time.Now()
+    for _, userID := range []string{"alice", "bob"} {
+        if _, err := old.conn.Exec(`INSERT INTO prompt_lists (user_id, created_at, updated_at) VALUES (?, ?, ?)`,
+            userID, now, now); err != nil {
+            t.Fatalf("inserting prompt list: %v", err)
+        }
+        if _, err := old.conn.Exec(`
+            INSERT INTO prompts (id, user_id, type, content, depth, created_at, updated_at)
+            VALUES (?, ?, 'system', ?, 0, ?, ?)`, userID+"-1", userID, "Hello from "+userID, now, now); err != nil {
+            t.Fatalf("inserting prompt entry: %v", err)
+        }
+    }
+    old.Close()
+
+    db := openTestDB(t, path)
+    pm := services.NewPromptManager(db.Prompts())
+    for _, userID := range []string{"alice", "bob"} {
+        stack := pm.PromptStack(userID)
+        var own []services.PromptEntry
+        blocks := 0
+        for _, entry := range stack {
+            if entry.Block != "" {
+                blocks++
+            } else {
+                own = append(own, entry)
+            }
+        }
+        if len(own) != 1 || own[0].Content != "Hello from "+userID || !own[0].Enabled {
+            t.Errorf("%s has own entries %+v, want the migrated one", userID, own)
+        }
+        if blocks != 7 {
+            t.Errorf("%s has %d built-in blocks, want 7", userID, blocks)
+        }
+
+        // Saving the normalized stack, built-in blocks included, works for
+        // every user
+        if _, err := pm.MovePrompt(userID, services.BlockName, 0); err != nil {
+            t.Fatalf("MovePrompt for %s: %v", userID, err)
+        }
+    }
+    reloaded := services.NewPromptManager(db.Prompts())
+    for _, userID := range []string{"alice", "bob"} {
+        stack := reloaded.PromptStack(userID)
+        if last := stack[len(stack)-1]; last.Block != services.BlockName {
+            t.Errorf("%s stack ends with %+v, want the name block", userID, last)
+        }
+    }
+}
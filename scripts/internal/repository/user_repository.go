@@ -0,0 +1,131 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/models"
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/services"
+)
+
+// UserRepository stores users and their model settings. It implements
+// services.ConfigStore, keyed by Discord user ID.
+type UserRepository struct {
+    db *sql.DB
+}
+
+var _ services.ConfigStore = (*UserRepository)(nil)
+
+func (r *UserRepository) Load(discordID string) (*services.UserConfig, error) {
+    user, err := r.getUser(discordID)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, services.ErrConfigNotFound
+        }
+        return nil, fmt.Errorf("error loading user: %v", err)
+    }
+    return toUserConfig(user.Settings), nil
+}
+
+func (r *UserRepository) Save(discordID string, config *services.UserConfig) error {
+    user, err := r.getUser(discordID)
+    if errors.Is(err, sql.ErrNoRows) {
+        user = models.NewUser(discordID, "")
+    } else if err != nil {
+        return fmt.Errorf("error loading user: %v", err)
+    }
+
+    user.UpdateSettings(toSettings(user.Settings, config))
+    user.UpdateActivity()
+
+    s := user.Settings
+    _, err = r.db.Exec(`
+        INSERT INTO users (id, discord_id, username, model, temperature, max_tokens, stream_responses,
+                           presence_penalty, frequency_penalty, top_p, language, theme,
+                           created_at, updated_at, last_active_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (discord_id) DO UPDATE SET
+            model = excluded.model,
+            temperature = excluded.temperature,
+            max_tokens = excluded.max_tokens,
+            stream_responses = excluded.stream_responses,
+            presence_penalty = excluded.presence_penalty,
+            frequency_penalty = excluded.frequency_penalty,
+            top_p = excluded.top_p,
+            updated_at = excluded.updated_at,
+            last_active_at = excluded.last_active_at`,
+        user.ID, user.DiscordID, user.Username, s.Model, s.Temperature, s.MaxTokens, s.StreamResponses,
+        s.PresencePenalty, s.FrequencyPenalty, s.TopP, s.Language, s.Theme,
+        user.CreatedAt, user.UpdatedAt, user.LastActiveAt)
+    if err != nil {
+        return fmt.Errorf("error saving user: %v", err)
+    }
+    return nil
+}
+
+func (r *UserRepository) Delete(discordID string) error {
+    if _, err := r.db.Exec(`DELETE FROM users WHERE discord_id = ?`, discordID); err != nil {
+        return fmt.Errorf("error deleting user: %v", err)
+    }
+    return nil
+}
+
+func (r *UserRepository) List() ([]string, error) {
+    rows, err := r.db.Query(`SELECT discord_id FROM users ORDER BY discord_id`)
+    if err != nil {
+        return nil, fmt.Errorf("error listing users: %v", err)
+    }
+    defer rows.Close()
+
+    var discordIDs []string
+    for rows.Next() {
+        var discordID string
+        if err := rows.Scan(&discordID); err != nil {
+            return nil, fmt.Errorf("error scanning user: %v", err)
+        }
+        discordIDs = append(discordIDs, discordID)
+    }
+    return discordIDs, rows.Err()
+}
+
+func (r *UserRepository) getUser(discordID string) (*models.User, error) {
+    user := &models.User{DiscordID: discordID}
+    s := &user.Settings
+    err := r.db.QueryRow(`
+        SELECT id, username, model, temperature, max_tokens, stream_responses,
+               presence_penalty, frequency_penalty, top_p, language, theme,
+               created_at, updated_at, last_active_at
+        FROM users
+        WHERE discord_id = ?`, discordID).
+        Scan(&user.ID, &user.Username, &s.Model, &s.Temperature, &s.MaxTokens, &s.StreamResponses,
+            &s.PresencePenalty, &s.FrequencyPenalty, &s.TopP, &s.Language, &s.Theme,
+            &user.CreatedAt, &user.UpdatedAt, &user.LastActiveAt)
+    if err != nil {
+        return nil, err
+    }
+    return user, nil
+}
+
+func toUserConfig(s models.Settings) *services.UserConfig {
+    return &services.UserConfig{
+        Model:            s.Model,
+        Temperature:      s.Temperature,
+        Stream:           s.StreamResponses,
+        MaxTokens:        s.MaxTokens,
+        PresencePenalty:  s.PresencePenalty,
+        FrequencyPenalty: s.FrequencyPenalty,
+        TopP:             s.TopP,
+    }
+}
+
+func toSettings(base models.Settings, config *services.UserConfig) models.Settings {
+    base.Model = config.Model
+    base.Temperature = config.Temperature
+    base.StreamResponses = config.Stream
+    base.MaxTokens = config.MaxTokens
+    base.PresencePenalty = config.PresencePenalty
+    base.FrequencyPenalty = config.FrequencyPenalty
+    base.TopP = config.TopP
+    return base
+}
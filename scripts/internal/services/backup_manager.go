@@ -0,0 +1,759 @@
+package services
+
+import (
+    "archive/zip"
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// BackupFormatVersion is written to every manifest. Restore refuses
+// archives with a newer version than it understands. Version 2 added data
+// exports, which carry a README and leave out the user token. Version 3
+// signs the manifest.
+const BackupFormatVersion = 3
+
+// exportReadme is the name of the README added to data exports.
+const exportReadme = "README.md"
+
+const (
+    BackupScopeUser     = "user"
+    BackupScopeInstance = "instance"
+)
+
+// maxBackupEntrySize caps a single decompressed archive entry.
+const maxBackupEntrySize = 64 << 20
+
+var (
+    ErrBackupNotFound  = errors.New("backup not found")
+    ErrBackupInvalid   = errors.New("backup archive is invalid")
+    ErrBackupForbidden = errors.New("not allowed to restore this backup")
+)
+
+// BackupManifest describes the contents of a backup archive.
+type BackupManifest struct {
+    Version   int               `json:"version"`
+    ID        string            `json:"id"`
+    Scope     string            `json:"scope"`
+    OwnerID   string            `json:"owner_id"`
+    CreatedAt time.Time         `json:"created_at"`
+    UserIDs   []string          `json:"user_ids"`
+    Export    bool              `json:"export,omitempty"`
+    Files     map[string]string `json:"files"`
+    Checksum  string            `json:"checksum"`
+    Signature string            `json:"signature,omitempty"`
+}
+
+// Signer authenticates backup manifests, so archives cannot be forged or
+// changed outside the instance that wrote them.
+type Signer interface {
+    Sign(data []byte) string
+    Verify(data []byte, signature string) error
+}
+
+// BackupInfo is returned after a backup has been written.
+type BackupInfo struct {
+    Manifest *BackupManifest
+    Path     string
+    Size     int64
+}
+
+// userBackup is everything stored about one user.
+type userBackup struct {
+    Prompts *UserPrompts
+    Config  *UserConfig
+    Session *ChatSession
+    Chats   []*SavedChat
+}
+
+// BackupManager writes and restores archives of user state.
+type BackupManager struct {
+    dir           string
+    promptManager *PromptManager
+    chatManager   *ChatManager
+    proxyClient   *ProxyClient
+    sealer        Sealer
+    signer        Signer
+    botOwners     map[string]bool
+    mu            sync.Mutex
+}
+
+// NewBackupManager stores archives in dir. When sealer is not nil the
+// archives are encrypted on disk.
+func NewBackupManager(dir string, pm *PromptManager, cm *ChatManager, pc *ProxyClient, sealer Sealer) (*BackupManager, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, fmt.Errorf("error creating backup directory: %v", err)
+    }
+    return &BackupManager{
+        dir:           dir,
+        promptManager: pm,
+        chatManager:   cm,
+        proxyClient:   pc,
+        sealer:        sealer,
+    }, nil
+}
+
+// UseSigner signs the manifest of every archive written from now on and
+// makes Restore accept only archives signed by signer, apart from data
+// exports restored by their owner. Call it before the manager is used.
+func (bm *BackupManager) UseSigner(signer Signer) {
+    bm.signer = signer
+}
+
+// SetBotOwners sets the users who run the bot. Only they can back up and
+// restore the whole instance or restore other users' backups.
+func (bm *BackupManager) SetBotOwners(userIDs []string) {
+    bm.botOwners = make(map[string]bool, len(userIDs))
+    for _, userID := range userIDs {
+        bm.botOwners[userID] = true
+    }
+}
+
+// IsBotOwner reports whether userID is one of the bot's owners.
+func (bm *BackupManager) IsBotOwner(userID string) bool {
+    return bm.botOwners[userID]
+}
+
+// CreateUserBackup archives everything stored about userID.
+func (bm *BackupManager) CreateUserBackup(userID string) (*BackupInfo, error) {
+    bm.mu.Lock()
+    defer bm.mu.Unlock()
+
+    return bm.create(BackupScopeUser, userID, []string{userID})
+}
+
+// CreateInstanceBackup archives the state of every known user.
+func (bm *BackupManager) CreateInstanceBackup(ownerID string) (*BackupInfo, error) {
+    bm.mu.Lock()
+    defer bm.mu.Unlock()
+
+    userIDs, err := bm.knownUserIDs()
+    if err != nil {
+        return nil, err
+    }
+    return bm.create(BackupScopeInstance, ownerID, userIDs)
+}
+
+// CreateDataExport bundles everything stored about userID into an archive
+// the user can download. Unlike backups it is not kept on disk, carries a
+// README and leaves out the user token. It can be restored on any instance
+// of the bot by the same user.
+func (bm *BackupManager) CreateDataExport(userID string) (*BackupManifest, []byte, error) {
+    backup, err := bm.collect(userID)
+    if err != nil {
+        return nil, nil, err
+    }
+    exported, err := bm.promptManager.ExportPrompts(userID)
+    if err != nil {
+        return nil, nil, fmt.Errorf("error exporting prompts for %s: %v", userID, err)
+    }
+    backup.Prompts = &UserPrompts{}
+    if err := json.Unmarshal(exported, backup.Prompts); err != nil {
+        return nil, nil, fmt.Errorf("error exporting prompts for %s: %v", userID, err)
+    }
+
+    files := make(map[string][]byte)
+    if err := addUserFiles(files, userID, backup); err != nil {
+        return nil, nil, err
+    }
+
+    now := time.Now()
+    manifest := &BackupManifest{
+        Version:   BackupFormatVersion,
+        ID:        fmt.Sprintf("export-%s-%s", userID, now.Format("20060102150405")),
+        Scope:     BackupScopeUser,
+        OwnerID:   userID,
+        CreatedAt: now,
+        UserIDs:   []string{userID},
+        Export:    true,
+    }
+    files[exportReadme] = exportReadmeText(manifest, backup)
+    if err := bm.finishManifest(manifest, files); err != nil {
+        return nil, nil, err
+    }
+
+    archive, err := writeBackupArchive(manifest, files)
+    if err != nil {
+        return nil, nil, err
+    }
+    return manifest, archive, nil
+}
+
+func exportReadmeText(manifest *BackupManifest, backup *userBackup) []byte {
+    var sb strings.Builder
+    dir := "users/" + manifest.OwnerID
+    fmt.Fprintf(&sb, "# Your chatbot data\n\n")
+    fmt.Fprintf(&sb, "Exported %s for Discord user %s.\n\n", manifest.CreatedAt.UTC().Format(time.RFC1123), manifest.OwnerID)
+    fmt.Fprintf(&sb, "## Contents\n\n")
+    fmt.Fprintf(&sb, "- `manifest.json`: format version and SHA-256 checksums of every file\n")
+    fmt.Fprintf(&sb, "- `%s/prompts.json`: character definitions, persona, author's note, prompt stack and lorebook\n", dir)
+    fmt.Fprintf(&sb, "- `%s/config.json`: model and sampling settings\n", dir)
+    fmt.Fprintf(&sb, "- `%s/session.json`: the active chat (%d messages)\n", dir, len(backup.Session.Messages))
+    fmt.Fprintf(&sb, "- `%s/chats/`: saved chats and the chats of your inactive characters (%d)\n\n", dir, len(backup.Chats))
+    fmt.Fprintf(&sb, "All files are JSON. Your personal API token is not included.\n\n")
+    fmt.Fprintf(&sb, "## Importing\n\n")
+    fmt.Fprintf(&sb, "Attach this zip unchanged to `/restore file:` on any instance of the bot. ")
+    fmt.Fprintf(&sb, "It replaces your prompts, settings and chats there and keeps the token you set on that instance.\n")
+    return []byte(sb.String())
+}
+
+// ReadBackup returns the raw archive for a stored backup.
+func (bm *BackupManager) ReadBackup(backupID string) ([]byte, error) {
+    if !isSafeFileKey(backupID) {
+        return nil, ErrBackupNotFound
+    }
+    data, err := os.ReadFile(filepath.Join(bm.dir, backupID+".zip"))
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, ErrBackupNotFound
+        }
+        return nil, fmt.Errorf("error reading backup: %v", err)
+    }
+    if bm.sealer != nil {
+        opened, err := bm.sealer.Open(string(data))
+        if err != nil {
+            return nil, fmt.Errorf("error decrypting backup: %v", err)
+        }
+        data = []byte(opened)
+    }
+    return data, nil
+}
+
+// Reencrypt rewrites every stored archive with the sealer's current key.
+// It returns the number of archives rewritten.
+func (bm *BackupManager) Reencrypt() (int, error) {
+    if bm.sealer == nil {
+        return 0, nil
+    }
+
+    bm.mu.Lock()
+    defer bm.mu.Unlock()
+
+    entries, err := os.ReadDir(bm.dir)
+    if err != nil {
+        return 0, fmt.Errorf("error listing backups: %v", err)
+    }
+
+    count := 0
+    for _, entry := range entries {
+        if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".zip") {
+            continue
+        }
+        archive, err := bm.ReadBackup(strings.TrimSuffix(entry.Name(), ".zip"))
+        if err != nil {
+            return count, err
+        }
+        if err := bm.writeArchive(filepath.Join(bm.dir, entry.Name()), archive); err != nil {
+            return count, err
+        }
+        count++
+    }
+    return count, nil
+}
+
+// DeleteUserBackups removes every user backup owned by userID and rewrites
+// instance backups without the user's data. It returns how many archives
+// were deleted and how many were rewritten. Archives that cannot be read
+// are skipped.
+func (bm *BackupManager) DeleteUserBackups(userID string) (int, int, error) {
+    bm.mu.Lock()
+    defer bm.mu.Unlock()
+
+    entries, err := os.ReadDir(bm.dir)
+    if err != nil {
+        return 0, 0, fmt.Errorf("error listing backups: %v", err)
+    }
+
+    deleted, rewritten := 0, 0
+    for _, entry := range entries {
+        if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".zip") {
+            continue
+        }
+        archivePath := filepath.Join(bm.dir, entry.Name())
+        archive, err := bm.ReadBackup(strings.TrimSuffix(entry.Name(), ".zip"))
+        if err != nil {
+            log.Printf("Skipping unreadable backup %s: %v", entry.Name(), err)
+            continue
+        }
+        manifest, users, err := readBackupArchive(archive)
+        if err != nil {
+            log.Printf("Skipping invalid backup %s: %v", entry.Name(), err)
+            continue
+        }
+        if _, ok := users[userID]; !ok {
+            continue
+        }
+
+        if manifest.Scope == BackupScopeUser || len(users) == 1 {
+            if err := os.Remove(archivePath); err != nil {
+                return deleted, rewritten, fmt.Errorf("error deleting backup %s: %v", manifest.ID, err)
+            }
+            deleted++
+            continue
+        }
+
+        delete(users, userID)
+        if err := bm.rewriteWithout(archivePath, manifest, users, userID); err != nil {
+            return deleted, rewritten, err
+        }
+        rewritten++
+    }
+    return deleted, rewritten, nil
+}
+
+// rewriteWithout writes the archive at archivePath again with only the
+// given users, keeping the rest of its manifest.
+func (bm *BackupManager) rewriteWithout(archivePath string, manifest *BackupManifest, users map[string]*userBackup, userID string) error {
+    files := make(map[string][]byte)
+    for id, backup := range users {
+        if err := addUserFiles(files, id, backup); err != nil {
+            return err
+        }
+    }
+
+    var userIDs []string
+    for _, id := range manifest.UserIDs {
+        if id != userID {
+            userIDs = append(userIDs, id)
+        }
+    }
+    manifest.UserIDs = userIDs
+    if err := bm.finishManifest(manifest, files); err != nil {
+        return err
+    }
+
+    archive, err := writeBackupArchive(manifest, files)
+    if err != nil {
+        return err
+    }
+    return bm.writeArchive(archivePath, archive)
+}
+
+// Restore validates an archive and applies it. Archives must be signed by
+// this instance, except data exports, which their owner can restore on any
+// instance. User backups can be restored by their owner or a bot owner,
+// instance backups only by a bot owner. If applying fails part way, the
+// previous state of the affected users is put back.
+func (bm *BackupManager) Restore(requesterID string, archive []byte) (*BackupManifest, error) {
+    manifest, users, err := readBackupArchive(archive)
+    if err != nil {
+        return nil, err
+    }
+
+    signed := bm.verifyManifest(manifest) == nil
+    if !signed && !manifest.Export {
+        return nil, fmt.Errorf("%w: manifest signature is missing or invalid", ErrBackupInvalid)
+    }
+    switch manifest.Scope {
+    case BackupScopeUser:
+        if manifest.OwnerID != requesterID && (!signed || !bm.IsBotOwner(requesterID)) {
+            return nil, ErrBackupForbidden
+        }
+    case BackupScopeInstance:
+        if !bm.IsBotOwner(requesterID) {
+            return nil, ErrBackupForbidden
+        }
+    }
+
+    bm.mu.Lock()
+    defer bm.mu.Unlock()
+
+    previous := make(map[string]*userBackup, len(users))
+    for userID := range users {
+        previous[userID], err = bm.collect(userID)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    if manifest.Export {
+        // Exports never carry the token, so keep the one already set
+        for userID, backup := range users {
+            if backup.Prompts.UserToken == "" {
+                backup.Prompts.UserToken = previous[userID].Prompts.UserToken
+            }
+        }
+    }
+
+    if err := bm.apply(users); err != nil {
+        if rollbackErr := bm.apply(previous); rollbackErr != nil {
+            return nil, fmt.Errorf("restore failed: %v (rollback also failed: %v)", err, rollbackErr)
+        }
+        return nil, fmt.Errorf("restore failed and was rolled back: %v", err)
+    }
+    return manifest, nil
+}
+
+// finishManifest records the checksums of files in the manifest and signs
+// it.
+func (bm *BackupManager) finishManifest(manifest *BackupManifest, files map[string][]byte) error {
+    manifest.Files = make(map[string]string, len(files))
+    for name, data := range files {
+        manifest.Files[name] = sha256Hex(data)
+    }
+    manifest.Checksum = manifestChecksum(manifest.Files)
+    manifest.Signature = ""
+    if bm.signer == nil {
+        return nil
+    }
+    data, err := json.Marshal(manifest)
+    if err != nil {
+        return fmt.Errorf("error encoding manifest: %v", err)
+    }
+    manifest.Signature = bm.signer.Sign(data)
+    return nil
+}
+
+// verifyManifest checks that the manifest was signed by this instance.
+func (bm *BackupManager) verifyManifest(manifest *BackupManifest) error {
+    if bm.signer == nil || manifest.Signature == "" {
+        return errors.New("manifest is not signed")
+    }
+    unsigned := *manifest
+    unsigned.Signature = ""
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return fmt.Errorf("error encoding manifest: %v", err)
+    }
+    return bm.signer.Verify(data, manifest.Signature)
+}
+
+func (bm *BackupManager) create(scope, ownerID string, userIDs []string) (*BackupInfo, error) {
+    files := make(map[string][]byte)
+    for _, userID := range userIDs {
+        backup, err := bm.collect(userID)
+        if err != nil {
+            return nil, err
+        }
+        if err := addUserFiles(files, userID, backup); err != nil {
+            return nil, err
+        }
+    }
+
+    now := time.Now()
+    manifest := &BackupManifest{
+        Version:   BackupFormatVersion,
+        ID:        fmt.Sprintf("%s-%s-%s", scope, ownerID, now.Format("20060102150405")),
+        Scope:     scope,
+        OwnerID:   ownerID,
+        CreatedAt: now,
+        UserIDs:   userIDs,
+    }
+    if err := bm.finishManifest(manifest, files); err != nil {
+        return nil, err
+    }
+
+    archive, err := writeBackupArchive(manifest, files)
+    if err != nil {
+        return nil, err
+    }
+
+    archivePath := filepath.Join(bm.dir, manifest.ID+".zip")
+    if err := bm.writeArchive(archivePath, archive); err != nil {
+        return nil, err
+    }
+
+    return &BackupInfo{
+        Manifest: manifest,
+        Path:     archivePath,
+        Size:     int64(len(archive)),
+    }, nil
+}
+
+// writeArchive stores an archive at archivePath, encrypting it when the
+// manager has a sealer.
+func (bm *BackupManager) writeArchive(archivePath string, archive []byte) error {
+    stored := archive
+    if bm.sealer != nil {
+        sealed, err := bm.sealer.Seal(string(archive))
+        if err != nil {
+            return fmt.Errorf("error encrypting backup: %v", err)
+        }
+        stored = []byte(sealed)
+    }
+
+    tmp := archivePath + ".tmp"
+    if err := os.WriteFile(tmp, stored, 0o600); err != nil {
+        return fmt.Errorf("error writing backup: %v", err)
+    }
+    if err := os.Rename(tmp, archivePath); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("error writing backup: %v", err)
+    }
+    return nil
+}
+
+func (bm *BackupManager) collect(userID string) (*userBackup, error) {
+    chats, err := bm.chatManager.allChats(userID)
+    if err != nil {
+        return nil, fmt.Errorf("error listing saved chats for %s: %v", userID, err)
+    }
+    return &userBackup{
+        Prompts: bm.promptManager.snapshot(userID),
+        Config:  bm.proxyClient.snapshot(userID),
+        Session: bm.chatManager.snapshot(userID),
+        Chats:   chats,
+    }, nil
+}
+
+func (bm *BackupManager) apply(users map[string]*userBackup) error {
+    userIDs := make([]string, 0, len(users))
+    for userID := range users {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+
+    for _, userID := range userIDs {
+        backup := users[userID]
+        if err := bm.promptManager.SetUserPrompts(userID, backup.Prompts); err != nil {
+            return fmt.Errorf("restoring prompts for %s: %v", userID, err)
+        }
+        if err := bm.proxyClient.SetUserConfig(userID, backup.Config); err != nil {
+            return fmt.Errorf("restoring config for %s: %v", userID, err)
+        }
+        if err := bm.chatManager.ReplaceUserChats(userID, backup.Session, backup.Chats); err != nil {
+            return fmt.Errorf("restoring chats for %s: %v", userID, err)
+        }
+    }
+    return nil
+}
+
+func (bm *BackupManager) knownUserIDs() ([]string, error) {
+    if err := bm.chatManager.SaveAllSessions(); err != nil {
+        return nil, err
+    }
+
+    seen := make(map[string]bool)
+    for _, list := range []func() ([]string, error){
+        bm.promptManager.UserIDs,
+        bm.proxyClient.UserIDs,
+        bm.chatManager.UserIDs,
+    } {
+        userIDs, err := list()
+        if err != nil {
+            return nil, err
+        }
+        for _, userID := range userIDs {
+            seen[userID] = true
+        }
+    }
+
+    userIDs := make([]string, 0, len(seen))
+    for userID := range seen {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+    return userIDs, nil
+}
+
+func addUserFiles(files map[string][]byte, userID string, backup *userBackup) error {
+    entries := map[string]interface{}{
+        "prompts.json": backup.Prompts,
+        "config.json":  backup.Config,
+        "session.json": backup.Session,
+    }
+    for _, chat := range backup.Chats {
+        entries["chats/"+chat.ID+".json"] = chat
+    }
+
+    for name, v := range entries {
+        data, err := json.MarshalIndent(v, "", "  ")
+        if err != nil {
+            return fmt.Errorf("error encoding %s for %s: %v", name, userID, err)
+        }
+        files[path.Join("users", userID, name)] = data
+    }
+    return nil
+}
+
+func writeBackupArchive(manifest *BackupManifest, files map[string][]byte) ([]byte, error) {
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+
+    manifestData, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return nil, fmt.Errorf("error encoding manifest: %v", err)
+    }
+
+    names := make([]string, 0, len(files))
+    for name := range files {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    write := func(name string, data []byte) error {
+        w, err := zw.Create(name)
+        if err != nil {
+            return err
+        }
+        _, err = w.Write(data)
+        return err
+    }
+
+    if err := write("manifest.json", manifestData); err != nil {
+        return nil, fmt.Errorf("error writing manifest: %v", err)
+    }
+    for _, name := range names {
+        if err := write(name, files[name]); err != nil {
+            return nil, fmt.Errorf("error writing %s: %v", name, err)
+        }
+    }
+    if err := zw.Close(); err != nil {
+        return nil, fmt.Errorf("error finishing archive: %v", err)
+    }
+    return buf.Bytes(), nil
+}
+
+// readBackupArchive parses and fully validates an archive before anything
+// is applied.
+func readBackupArchive(archive []byte) (*BackupManifest, map[string]*userBackup, error) {
+    zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+    if err != nil {
+        return nil, nil, fmt.Errorf("%w: %v", ErrBackupInvalid, err)
+    }
+
+    files := make(map[string][]byte)
+    for _, f := range zr.File {
+        if f.FileInfo().IsDir() {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return nil, nil, fmt.Errorf("%w: %v", ErrBackupInvalid, err)
+        }
+        data, err := io.ReadAll(io.LimitReader(rc, maxBackupEntrySize+1))
+        rc.Close()
+        if err != nil {
+            return nil, nil, fmt.Errorf("%w: %v", ErrBackupInvalid, err)
+        }
+        if len(data) > maxBackupEntrySize {
+            return nil, nil, fmt.Errorf("%w: %s is too large", ErrBackupInvalid, f.Name)
+        }
+        files[f.Name] = data
+    }
+
+    manifestData, ok := files["manifest.json"]
+    if !ok {
+        return nil, nil, fmt.Errorf("%w: missing manifest", ErrBackupInvalid)
+    }
+    delete(files, "manifest.json")
+
+    var manifest BackupManifest
+    if err := json.Unmarshal(manifestData, &manifest); err != nil {
+        return nil, nil, fmt.Errorf("%w: bad manifest: %v", ErrBackupInvalid, err)
+    }
+    if manifest.Version < 1 || manifest.Version > BackupFormatVersion {
+        return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrBackupInvalid, manifest.Version)
+    }
+    if manifest.Scope != BackupScopeUser && manifest.Scope != BackupScopeInstance {
+        return nil, nil, fmt.Errorf("%w: unknown scope %q", ErrBackupInvalid, manifest.Scope)
+    }
+    if manifestChecksum(manifest.Files) != manifest.Checksum {
+        return nil, nil, fmt.Errorf("%w: manifest checksum mismatch", ErrBackupInvalid)
+    }
+    if len(files) != len(manifest.Files) {
+        return nil, nil, fmt.Errorf("%w: archive does not match manifest", ErrBackupInvalid)
+    }
+    for name, data := range files {
+        if manifest.Files[name] != sha256Hex(data) {
+            return nil, nil, fmt.Errorf("%w: checksum mismatch for %s", ErrBackupInvalid, name)
+        }
+    }
+
+    allowed := make(map[string]bool, len(manifest.UserIDs))
+    for _, userID := range manifest.UserIDs {
+        allowed[userID] = true
+    }
+    if manifest.Scope == BackupScopeUser && (len(manifest.UserIDs) != 1 || manifest.UserIDs[0] != manifest.OwnerID) {
+        return nil, nil, fmt.Errorf("%w: user backup must contain only its owner", ErrBackupInvalid)
+    }
+
+    users := make(map[string]*userBackup)
+    for name, data := range files {
+        if name == exportReadme && manifest.Export {
+            continue
+        }
+        parts := strings.Split(name, "/")
+        if len(parts) < 3 || parts[0] != "users" || !isSafeFileKey(parts[1]) || !allowed[parts[1]] {
+            return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrBackupInvalid, name)
+        }
+        backup, ok := users[parts[1]]
+        if !ok {
+            backup = &userBackup{}
+            users[parts[1]] = backup
+        }
+
+        var target interface{}
+        switch {
+        case len(parts) == 3 && parts[2] == "prompts.json":
+            backup.Prompts = &UserPrompts{}
+            target = backup.Prompts
+        case len(parts) == 3 && parts[2] == "config.json":
+            backup.Config = &UserConfig{}
+            target = backup.Config
+        case len(parts) == 3 && parts[2] == "session.json":
+            backup.Session = &ChatSession{}
+            target = backup.Session
+        case len(parts) == 4 && parts[2] == "chats":
+            chat := &SavedChat{}
+            backup.Chats = append(backup.Chats, chat)
+            target = chat
+        default:
+            return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrBackupInvalid, name)
+        }
+        if err := json.Unmarshal(data, target); err != nil {
+            return nil, nil, fmt.Errorf("%w: bad %s: %v", ErrBackupInvalid, name, err)
+        }
+    }
+
+    for _, userID := range manifest.UserIDs {
+        if _, ok := users[userID]; !ok {
+            return nil, nil, fmt.Errorf("%w: no data for user %s", ErrBackupInvalid, userID)
+        }
+    }
+    for userID, backup := range users {
+        if backup.Prompts == nil || backup.Config == nil || backup.Session == nil {
+            return nil, nil, fmt.Errorf("%w: incomplete data for user %s", ErrBackupInvalid, userID)
+        }
+        for _, chat := range backup.Chats {
+            if chat.OwnerID != userID || !isSafeFileKey(chat.ID) || (chat.IsParked() && !isSafeFileKey(chat.CharacterID)) {
+                return nil, nil, fmt.Errorf("%w: saved chat %s has the wrong owner", ErrBackupInvalid, chat.ID)
+            }
+        }
+    }
+    return &manifest, users, nil
+}
+
+// manifestChecksum hashes the sorted file list so the file list is covered
+// by the checksum. It has no key; the signature is what authenticates the
+// manifest.
+func manifestChecksum(files map[string]string) string {
+    names := make([]string, 0, len(files))
+    for name := range files {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    h := sha256.New()
+    for _, name := range names {
+        fmt.Fprintf(h, "%s:%s\n", name, files[name])
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+func sha256Hex(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/encryption"
+)
+
+// backupFixture is a backup manager over in-memory stores, signing with a
+// test key. "root" owns the bot.
+type backupFixture struct {
+    bm       *BackupManager
+    prompts  *PromptManager
+    chats    *ChatManager
+    proxy    *ProxyClient
+    sessions SessionStore
+}
+
+func testKeyring(t *testing.T, id string, b byte) *encryption.Keyring {
+    t.Helper()
+    keyring, err := encryption.NewKeyring(id, map[string][]byte{id: bytes.Repeat([]byte{b}, 32)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    return keyring
+}
+
+func newBackupFixture(t *testing.T, sessions SessionStore, sealer Sealer) *backupFixture {
+    t.Helper()
+    if sessions == nil {
+        sessions = NewMemorySessionStore()
+    }
+    pm := NewPromptManager(nil)
+    cm := NewChatManager(nil, pm, sessions)
+    pc := NewProxyClient("", "", nil)
+    bm, err := NewBackupManager(t.TempDir(), pm, cm, pc, sealer)
+    if err != nil {
+        t.Fatalf("NewBackupManager: %v", err)
+    }
+    bm.UseSigner(testKeyring(t, "k1", 1))
+    bm.SetBotOwners([]string{"root"})
+    return &backupFixture{bm: bm, prompts: pm, chats: cm, proxy: pc, sessions: sessions}
+}
+
+// fill gives userID a description, token, temperature, a chat message and a
+// saved chat, all mentioning tag.
+func (f *backupFixture) fill(t *testing.T, userID, tag string) {
+    t.Helper()
+    f.prompts.UpdateDefinitions(userID, map[string]string{"description": "description " + tag})
+    f.prompts.SetUserToken(userID, "token "+tag)
+    f.proxy.SetTemperature(userID, 0.5)
+    f.chats.AddMessage(userID, "user", "message "+tag)
+    if _, err := f.chats.SaveChat(userID, "chat "+tag); err != nil {
+        t.Fatalf("SaveChat: %v", err)
+    }
+}
+
+// describe summarizes what is stored about userID for comparing.
+func (f *backupFixture) describe(t *testing.T, userID string) string {
+    t.Helper()
+    prompts := f.prompts.GetUserPrompts(userID)
+    var sb strings.Builder
+    sb.WriteString(prompts.Description + "|" + prompts.UserToken + "|")
+    if config := f.proxy.snapshot(userID); config.Temperature == 0.5 {
+        sb.WriteString("tuned|")
+    }
+    for _, msg := range f.chats.GetChatHistory(userID) {
+        if msg.Role == "user" {
+            sb.WriteString(msg.Content + "|")
+        }
+    }
+    chats, err := f.chats.ListChats(userID)
+    if err != nil {
+        t.Fatalf("ListChats: %v", err)
+    }
+    for _, chat := range chats {
+        sb.WriteString(chat.Title + "|")
+    }
+    return sb.String()
+}
+
+func (f *backupFixture) userArchive(t *testing.T, userID string) []byte {
+    t.Helper()
+    info, err := f.bm.CreateUserBackup(userID)
+    if err != nil {
+        t.Fatalf("CreateUserBackup: %v", err)
+    }
+    archive, err := f.bm.ReadBackup(info.Manifest.ID)
+    if err != nil {
+        t.Fatalf("ReadBackup: %v", err)
+    }
+    return archive
+}
+
+// editArchive rewrites an archive after change has edited its manifest and
+// files, without signing it again.
+func editArchive(t *testing.T, archive []byte, change func(manifest *BackupManifest, files map[string][]byte)) []byte {
+    t.Helper()
+    zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+    if err != nil {
+        t.Fatalf("reading archive: %v", err)
+    }
+    files := make(map[string][]byte)
+    for _, f := range zr.File {
+        rc, err := f.Open()
+        if err != nil {
+            t.Fatalf("opening %s: %v", f.Name, err)
+        }
+        data, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            t.Fatalf("reading %s: %v", f.Name, err)
+        }
+        files[f.Name] = data
+    }
+    var manifest BackupManifest
+    if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+        t.Fatalf("decoding manifest: %v", err)
+    }
+    delete(files, "manifest.json")
+
+    change(&manifest, files)
+    edited, err := writeBackupArchive(&manifest, files)
+    if err != nil {
+        t.Fatalf("writing archive: %v", err)
+    }
+    return edited
+}
+
+// rehash updates the checksums after files were changed, as a forger would.
+func rehash(manifest *BackupManifest, files map[string][]byte) {
+    manifest.Files = make(map[string]string, len(files))
+    for name, data := range files {
+        manifest.Files[name] = sha256Hex(data)
+    }
+    manifest.Checksum = manifestChecksum(manifest.Files)
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+    tests := []struct {
+        name   string
+        sealer Sealer
+    }{
+        {"plain archives", nil},
+        {"encrypted archives", testKeyring(t, "enc", 9)},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := newBackupFixture(t, nil, tt.sealer)
+            f.fill(t, "alice", "before")
+            want := f.describe(t, "alice")
+            archive := f.userArchive(t, "alice")
+
+            f.prompts.UpdateDefinitions("alice", map[string]string{"description": "changed"})
+            f.prompts.SetUserToken("alice", "token changed")
+            f.chats.ClearChat("alice")
+            if _, err := f.chats.SaveChat("alice", "extra chat"); err != nil {
+                t.Fatalf("SaveChat: %v", err)
+            }
+
+            manifest, err := f.bm.Restore("alice", archive)
+            if err != nil {
+                t.Fatalf("Restore: %v", err)
+            }
+            if manifest.OwnerID != "alice" || manifest.Version != BackupFormatVersion {
+                t.Errorf("manifest = %+v", manifest)
+            }
+            if got := f.describe(t, "alice"); got != want {
+                t.Errorf("after restore %q, want %q", got, want)
+            }
+        })
+    }
+
+    t.Run("instance", func(t *testing.T) {
+        f := newBackupFixture(t, nil, nil)
+        f.fill(t, "alice", "a")
+        f.fill(t, "bob", "b")
+        want := f.describe(t, "alice") + f.describe(t, "bob")
+        info, err := f.bm.CreateInstanceBackup("root")
+        if err != nil {
+            t.Fatalf("CreateInstanceBackup: %v", err)
+        }
+        archive, err := f.bm.ReadBackup(info.Manifest.ID)
+        if err != nil {
+            t.Fatalf("ReadBackup: %v", err)
+        }
+        f.fill(t, "alice", "later")
+        f.fill(t, "bob", "later")
+
+        if _, err := f.bm.Restore("root", archive); err != nil {
+            t.Fatalf("Restore: %v", err)
+        }
+        if got := f.describe(t, "alice") + f.describe(t, "bob"); got != want {
+            t.Errorf("after restore %q, want %q", got, want)
+        }
+    })
+}
+
+func TestRestoreRejectsTamperedArchives(t *testing.T) {
+    f := newBackupFixture(t, nil, nil)
+    f.fill(t, "alice", "a")
+    archive := f.userArchive(t, "alice")
+    prompts := "users/alice/prompts.json"
+
+    forger := newBackupFixture(t, nil, nil)
+    forger.bm.UseSigner(testKeyring(t, "k1", 2))
+    forger.fill(t, "alice", "forged")
+    forged := forger.userArchive(t, "alice")
+
+    tests := []struct {
+        name    string
+        archive []byte
+    }{
+        {"file changed", editArchive(t, archive, func(m *BackupManifest, files map[string][]byte) {
+            files[prompts] = bytes.Replace(files[prompts], []byte("description a"), []byte("description x"), 1)
+        })},
+        {"file checksum changed", editArchive(t, archive, func(m *BackupManifest, files map[string][]byte) {
+            files[prompts] = bytes.Replace(files[prompts], []byte("description a"), []byte("description x"), 1)
+            m.Files[prompts] = sha256Hex(files[prompts])
+        })},
+        {"file and manifest checksums changed", editArchive(t, archive, func(m *BackupManifest, files map[string][]byte) {
+            files[prompts] = bytes.Replace(files[prompts], []byte("description a"), []byte("description x"), 1)
+            rehash(m, files)
+        })},
+        {"file added", editArchive(t, archive, func(m *BackupManifest, files map[string][]byte) {
+            files["users/alice/extra.json"] = []byte("{}")
+        })},
+        {"signature removed", editArchive(t, archive, func(m *BackupManifest, files map[string][]byte) {
+            m.Signature = ""
+        })},
+        {"signed by another instance", forged},
+        {"not a zip", []byte("not a zip")},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := f.bm.Restore("alice", tt.archive); !errors.Is(err, ErrBackupInvalid) {
+                t.Fatalf("Restore error = %v, want %v", err, ErrBackupInvalid)
+            }
+            if got := f.prompts.GetUserPrompts("alice").Description; got != "description a" {
+                t.Errorf("description = %q after a rejected restore", got)
+            }
+        })
+    }
+}
+
+func TestRestorePermissions(t *testing.T) {
+    f := newBackupFixture(t, nil, nil)
+    f.fill(t, "alice", "a")
+    f.fill(t, "bob", "b")
+    backup := f.userArchive(t, "alice")
+    _, export, err := f.bm.CreateDataExport("alice")
+    if err != nil {
+        t.Fatalf("CreateDataExport: %v", err)
+    }
+    info, err := f.bm.CreateInstanceBackup("root")
+    if err != nil {
+        t.Fatalf("CreateInstanceBackup: %v", err)
+    }
+    instance, err := f.bm.ReadBackup(info.Manifest.ID)
+    if err != nil {
+        t.Fatalf("ReadBackup: %v", err)
+    }
+
+    // An export from another instance carries a signature this one cannot
+    // check
+    other := newBackupFixture(t, nil, nil)
+    other.bm.UseSigner(testKeyring(t, "other", 3))
+    other.fill(t, "alice", "elsewhere")
+    _, foreignExport, err := other.bm.CreateDataExport("alice")
+    if err != nil {
+        t.Fatalf("CreateDataExport: %v", err)
+    }
+    // A backup claiming another owner, signed by another instance
+    stolen := other.userArchive(t, "alice")
+
+    tests := []struct {
+        name      string
+        requester string
+        archive   []byte
+        wantErr   error
+    }{
+        {"owner restores their backup", "alice", backup, nil},
+        {"another user restores it", "bob", backup, ErrBackupForbidden},
+        {"bot owner restores it", "root", backup, nil},
+        {"owner restores their export", "alice", export, nil},
+        {"another user restores the export", "bob", export, ErrBackupForbidden},
+        {"owner restores a foreign export", "alice", foreignExport, nil},
+        {"bot owner restores a foreign export", "root", foreignExport, ErrBackupForbidden},
+        {"another user restores a foreign export", "bob", foreignExport, ErrBackupForbidden},
+        {"owner restores a foreign backup", "alice", stolen, ErrBackupInvalid},
+        {"bot owner restores a foreign backup", "root", stolen, ErrBackupInvalid},
+        {"user restores the instance", "alice", instance, ErrBackupForbidden},
+        {"bot owner restores the instance", "root", instance, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := f.bm.Restore(tt.requester, tt.archive)
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("Restore error = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+
+    t.Run("no bot owners configured", func(t *testing.T) {
+        f.bm.SetBotOwners(nil)
+        defer f.bm.SetBotOwners([]string{"root"})
+        if _, err := f.bm.Restore("root", instance); !errors.Is(err, ErrBackupForbidden) {
+            t.Errorf("Restore error = %v, want %v", err, ErrBackupForbidden)
+        }
+    })
+}
+
+// failingSessionStore fails the next fails session saves.
+type failingSessionStore struct {
+    SessionStore
+    fails int
+}
+
+func (s *failingSessionStore) Save(userID string, session *ChatSession) error {
+    if s.fails > 0 {
+        s.fails--
+        return errors.New("disk full")
+    }
+    return s.SessionStore.Save(userID, session)
+}
+
+func TestRestoreRollsBack(t *testing.T) {
+    tests := []struct {
+        name         string
+        fails        int
+        wantRollback bool
+    }{
+        {"rolled back", 1, true},
+        {"rollback fails too", 2, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            store := &failingSessionStore{SessionStore: NewMemorySessionStore()}
+            f := newBackupFixture(t, store, nil)
+            f.fill(t, "alice", "old")
+            archive := f.userArchive(t, "alice")
+            f.fill(t, "alice", "new")
+            want := f.describe(t, "alice")
+
+            store.fails = tt.fails
+            _, err := f.bm.Restore("alice", archive)
+            if err == nil {
+                t.Fatal("Restore succeeded while the session store was failing")
+            }
+            if rolledBack := strings.Contains(err.Error(), "was rolled back"); rolledBack != tt.wantRollback {
+                t.Errorf("Restore error = %v, want rolled back %v", err, tt.wantRollback)
+            }
+            if tt.wantRollback {
+                if got := f.describe(t, "alice"); got != want {
+                    t.Errorf("after rollback %q, want %q", got, want)
+                }
+            }
+        })
+    }
+}
+
+func TestDataExportKeepsToken(t *testing.T) {
+    f := newBackupFixture(t, nil, nil)
+    f.fill(t, "alice", "exported")
+    manifest, export, err := f.bm.CreateDataExport("alice")
+    if err != nil {
+        t.Fatalf("CreateDataExport: %v", err)
+    }
+    if !manifest.Export {
+        t.Error("export manifest is not marked as an export")
+    }
+    if bytes.Contains(export, []byte("token exported")) {
+        t.Error("the export contains the user token")
+    }
+    backup := f.userArchive(t, "alice")
+
+    tests := []struct {
+        name      string
+        archive   []byte
+        wantToken string
+    }{
+        {"export keeps the current token", export, "token current"},
+        {"backup restores its token", backup, "token exported"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f.prompts.SetUserToken("alice", "token current")
+            f.prompts.UpdateDefinitions("alice", map[string]string{"description": "changed"})
+            if _, err := f.bm.Restore("alice", tt.archive); err != nil {
+                t.Fatalf("Restore: %v", err)
+            }
+            prompts := f.prompts.GetUserPrompts("alice")
+            if prompts.UserToken != tt.wantToken {
+                t.Errorf("token = %q, want %q", prompts.UserToken, tt.wantToken)
+            }
+            if prompts.Description != "description exported" {
+                t.Errorf("description = %q, want the exported one", prompts.Description)
+            }
+        })
+    }
+}
+
+func TestRestoreKeepsOtherUsersChats(t *testing.T) {
+    f := newBackupFixture(t, nil, nil)
+    f.fill(t, "alice", "a")
+    now := time.Now()
+    if err := f.sessions.SaveChat(&SavedChat{ID: "shared", OwnerID: "alice", Title: "Alice's", CreatedAt: now}); err != nil {
+        t.Fatalf("SaveChat: %v", err)
+    }
+    archive := f.userArchive(t, "alice")
+    if _, err := f.chats.DeleteChat("alice", "shared"); err != nil {
+        t.Fatalf("DeleteChat: %v", err)
+    }
+    bobs := &SavedChat{ID: "shared", OwnerID: "bob", Title: "Bob's", CreatedAt: now}
+    if err := f.sessions.SaveChat(bobs); err != nil {
+        t.Fatalf("SaveChat: %v", err)
+    }
+
+    if _, err := f.bm.Restore("alice", archive); err != nil {
+        t.Fatalf("Restore: %v", err)
+    }
+    chat, err := f.sessions.LoadChat("shared")
+    if err != nil || chat.OwnerID != "bob" || chat.Title != "Bob's" {
+        t.Errorf("Bob's chat after the restore = %+v, %v", chat, err)
+    }
+    chats, err := f.chats.ListChats("alice")
+    if err != nil {
+        t.Fatalf("ListChats: %v", err)
+    }
+    titles := make([]string, 0, len(chats))
+    for _, chat := range chats {
+        if chat.ID == "shared" {
+            t.Errorf("Alice's restored chat kept Bob's ID")
+        }
+        titles = append(titles, chat.Title)
+    }
+    if !strings.Contains(strings.Join(titles, "|"), "Alice's") {
+        t.Errorf("Alice's chats = %q, want the restored one", titles)
+    }
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "unicode/utf8"
+)
+
+// maxCharacterName is the longest character name accepted. It keeps names
+// usable as autocomplete choices.
+const maxCharacterName = 64
+
+// defaultCharacterName is given to an unnamed character when it is moved
+// into the library, so it can be switched back to.
+const defaultCharacterName = "Default"
+
+var (
+    ErrCharacterNotFound = errors.New("character not found")
+    ErrCharacterExists   = errors.New("a character with that name already exists")
+    ErrCharacterActive   = errors.New("the active character cannot be deleted, switch to another one first")
+    ErrCharacterName     = fmt.Errorf("character names must be 1 to %d characters long", maxCharacterName)
+)
+
+// ListCharacters returns every character in the user's library sorted by
+// name, and the ID of the active one.
+func (pm *PromptManager) ListCharacters(userID string) ([]Character, string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    characters := append([]Character{prompts.Character}, prompts.Characters...)
+    if characters[0].Name == "" {
+        characters[0].Name = defaultCharacterName
+    }
+    sort.Slice(characters, func(i, j int) bool {
+        return strings.ToLower(characters[i].Name) < strings.ToLower(characters[j].Name)
+    })
+    return characters, prompts.ID
+}
+
+// CreateCharacter adds an empty character to the user's library and makes
+// it active. It returns the character that was active before and the new
+// one.
+func (pm *PromptManager) CreateCharacter(userID, name string) (Character, Character, error) {
+    name = strings.TrimSpace(name)
+    if err := validCharacterName(name); err != nil {
+        return Character{}, Character{}, err
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if prompts.findCharacter(name) >= 0 || strings.EqualFold(prompts.Name, name) {
+        return Character{}, Character{}, ErrCharacterExists
+    }
+
+    previous := prompts.stashActive()
+    prompts.Character = Character{ID: GenerateID(), Name: name}
+    pm.persist(userID)
+    return previous, prompts.Character, nil
+}
+
+// SwitchCharacter makes the named character active. It returns the
+// character that was active before and the new one.
+func (pm *PromptManager) SwitchCharacter(userID, name string) (Character, Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    index := prompts.findCharacter(strings.TrimSpace(name))
+    if index < 0 {
+        return Character{}, Character{}, ErrCharacterNotFound
+    }
+
+    next := prompts.Characters[index]
+    prompts.Characters = append(prompts.Characters[:index], prompts.Characters[index+1:]...)
+    previous := prompts.stashActive()
+    prompts.Character = next
+    pm.persist(userID)
+    return previous, next, nil
+}
+
+// DeleteCharacter removes a character that is not active from the user's
+// library and returns it.
+func (pm *PromptManager) DeleteCharacter(userID, name string) (Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    name = strings.TrimSpace(name)
+    index := prompts.findCharacter(name)
+    if index < 0 {
+        if strings.EqualFold(prompts.Name, name) || (prompts.Name == "" && strings.EqualFold(name, defaultCharacterName)) {
+            return Character{}, ErrCharacterActive
+        }
+        return Character{}, ErrCharacterNotFound
+    }
+
+    deleted := prompts.Characters[index]
+    prompts.Characters = append(prompts.Characters[:index], prompts.Characters[index+1:]...)
+    prompts.leaveGroup(deleted.ID)
+    prompts.unbindCharacter(deleted.ID)
+    pm.persist(userID)
+    return deleted, nil
+}
+
+// RenameCharacter renames one of the user's characters, active or not.
+func (pm *PromptManager) RenameCharacter(userID, oldName, newName string) (Character, error) {
+    newName = strings.TrimSpace(newName)
+    if err := validCharacterName(newName); err != nil {
+        return Character{}, err
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    oldName = strings.TrimSpace(oldName)
+
+    var target *Character
+    if index := prompts.findCharacter(oldName); index >= 0 {
+        target = &prompts.Characters[index]
+    } else if strings.EqualFold(prompts.Name, oldName) || (prompts.Name == "" && strings.EqualFold(oldName, defaultCharacterName)) {
+        target = &prompts.Character
+    } else {
+        return Character{}, ErrCharacterNotFound
+    }
+
+    if !strings.EqualFold(oldName, newName) && (prompts.findCharacter(newName) >= 0 || strings.EqualFold(prompts.Name, newName)) {
+        return Character{}, ErrCharacterExists
+    }
+    target.Name = newName
+    if target.ID == "" {
+        target.ID = GenerateID()
+    }
+    pm.persist(userID)
+    return *target, nil
+}
+
+// UseSharedCharacter makes a copy of a guild's shared character the user's
+// active character. A copy the user made before is updated to the shared
+// version and keeps its name and its chat; otherwise a new character is
+// added. It returns the character that was active before and the copy.
+func (pm *PromptManager) UseSharedCharacter(userID, guildID string, shared SharedCharacter) (Character, Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    copied := shared.Character
+    copied.AlternateGreetings = append([]string(nil), copied.AlternateGreetings...)
+    copied.SharedFrom = shared.Origin(guildID)
+    copied.SharedVersion = shared.Version
+
+    if prompts.SharedFrom == copied.SharedFrom {
+        previous := prompts.Character
+        copied.ID = prompts.ID
+        copied.Name = prompts.Name
+        prompts.Character = copied
+        pm.persist(userID)
+        return previous, copied, nil
+    }
+
+    if index := prompts.findShared(copied.SharedFrom); index >= 0 {
+        existing := prompts.Characters[index]
+        copied.ID = existing.ID
+        copied.Name = existing.Name
+        prompts.Characters = append(prompts.Characters[:index], prompts.Characters[index+1:]...)
+    } else {
+        copied.ID = GenerateID()
+        for n := 2; prompts.findCharacter(copied.Name) >= 0 || strings.EqualFold(prompts.Name, copied.Name); n++ {
+            // Keep names unique within the user's character library
+            copied.Name = fmt.Sprintf("%s %d", shared.Character.Name, n)
+        }
+    }
+    previous := prompts.stashActive()
+    prompts.Character = copied
+    pm.persist(userID)
+    return previous, copied, nil
+}
+
+// Character returns a character of the user's library, active or not, by
+// name.
+func (pm *PromptManager) Character(userID, name string) (Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if index := prompts.findCharacter(strings.TrimSpace(name)); index >= 0 {
+        return prompts.Characters[index], nil
+    }
+    if strings.EqualFold(prompts.Name, strings.TrimSpace(name)) {
+        return prompts.Character, nil
+    }
+    return Character{}, ErrCharacterNotFound
+}
+
+// findShared returns the index in the library of the copy of the shared
+// character with the given origin, or -1.
+func (p *UserPrompts) findShared(origin string) int {
+    for i, character := range p.Characters {
+        if character.SharedFrom == origin {
+            return i
+        }
+    }
+    return -1
+}
+
+// stashActive moves the active character into the library and returns it.
+// Unnamed characters get a default name so they can be switched back to.
+func (p *UserPrompts) stashActive() Character {
+    active := p.Character
+    if active.ID == "" {
+        active.ID = GenerateID()
+    }
+    if active.Name == "" {
+        active.Name = defaultCharacterName
+        for n := 2; p.findCharacter(active.Name) >= 0; n++ {
+            active.Name = fmt.Sprintf("%s %d", defaultCharacterName, n)
+        }
+    }
+    p.Characters = append(p.Characters, active)
+    return active
+}
+
+// findCharacter returns the index of the named character in the library,
+// ignoring case, or -1.
+func (p *UserPrompts) findCharacter(name string) int {
+    for i, character := range p.Characters {
+        if strings.EqualFold(character.Name, name) {
+            return i
+        }
+    }
+    return -1
+}
+
+func validCharacterName(name string) error {
+    if name == "" || utf8.RuneCountInString(name) > maxCharacterName {
+        return ErrCharacterName
+    }
+    return nil
+}
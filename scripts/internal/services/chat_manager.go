@@ -3,63 +3,148 @@ package services
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
+    "log"
+    "sort"
+    "strings"
     "sync"
     "time"
 )
 
 var startTime = time.Now()
 
+var ErrChatNotOwned = errors.New("saved chat belongs to another user")
+
 type ChatManager struct {
     openAI        *OpenAIService
     promptManager *PromptManager
     sessions      map[string]*ChatSession
+    store         SessionStore
+    journal       *Journal
+    dirty         map[string]bool
+    stopCompact   chan struct{}
     mu            sync.RWMutex
 }
 
 type ChatSession struct {
-    Messages     []Message
-    LastActivity time.Time
-    IsStreaming  bool
+    Messages     []Message `json:"messages"`
+    LastActivity time.Time `json:"last_activity"`
+    IsStreaming  bool      `json:"-"`
+}
+
+// SavedChat is a named copy of a session that its owner can load later.
+// The session of a character that is not active is parked as a saved chat
+// with CharacterID set; those are hidden from the user's list of chats.
+type SavedChat struct {
+    ID          string    `json:"id"`
+    OwnerID     string    `json:"owner_id"`
+    Title       string    `json:"title"`
+    CharacterID string    `json:"character_id,omitempty"`
+    CreatedAt   time.Time `json:"created_at"`
+    Messages    []Message `json:"messages"`
+}
+
+func (c *SavedChat) MessageCount() int {
+    return len(c.Messages)
+}
+
+func (c *SavedChat) IsParked() bool {
+    return c.CharacterID != ""
+}
+
+// parkedChatID is the ID of the parked session of one of the user's
+// characters. Saved chat IDs are shared by every user, so it includes the
+// user's ID.
+func parkedChatID(userID, characterID string) string {
+    return userID + "-character-" + characterID
+}
+
+func (c *SavedChat) clone() *SavedChat {
+    copied := *c
+    copied.Messages = append([]Message(nil), c.Messages...)
+    return &copied
 }
 
-func NewChatManager(openAI *OpenAIService, promptManager *PromptManager) *ChatManager {
+// NewChatManager creates a chat manager backed by store. A nil store keeps
+// sessions in memory only.
+func NewChatManager(openAI *OpenAIService, promptManager *PromptManager, store SessionStore) *ChatManager {
+    if store == nil {
+        store = NewMemorySessionStore()
+    }
     return &ChatManager{
         openAI:        openAI,
         promptManager: promptManager,
         sessions:      make(map[string]*ChatSession),
+        store:         store,
+        dirty:         make(map[string]bool),
+    }
+}
+
+func (s *ChatSession) clone() *ChatSession {
+    messages := make([]Message, len(s.Messages))
+    copy(messages, s.Messages)
+    return &ChatSession{
+        Messages:     messages,
+        LastActivity: s.LastActivity,
+        IsStreaming:  s.IsStreaming,
     }
 }
 
 func (cm *ChatManager) CreateNewChat(userID string) {
+    cm.CreateNewChatWithGreeting(userID, 0)
+}
+
+// CreateNewChatWithGreeting starts a new chat that opens with greeting
+// (0-based, see Character.Greetings).
+func (cm *ChatManager) CreateNewChatWithGreeting(userID string, greeting int) {
     cm.mu.Lock()
     defer cm.mu.Unlock()
-    prompts := cm.promptManager.BuildPromptList(userID)
+    prompts := cm.promptManager.BuildPromptListWithGreeting(userID, greeting)
     cm.sessions[userID] = &ChatSession{
         Messages:     prompts,
         LastActivity: time.Now(),
         IsStreaming:  false,
     }
+    cm.commit(userID, JournalEntry{Op: JournalClear, Messages: prompts})
 }
 
 func (cm *ChatManager) AddMessage(userID string, role string, content string) {
+    cm.addMessage(userID, role, content, "")
+}
+
+// addMessage adds a message written by speaker, a group chat member.
+func (cm *ChatManager) addMessage(userID, role, content, speaker string) {
     cm.mu.Lock()
     session := cm.getOrCreateSession(userID)
-    session.Messages = append(session.Messages, Message{
+    msg := Message{
         ID:        GenerateID(),
         Role:      role,
         Content:   content,
         Timestamp: time.Now(),
-    })
+        Speaker:   speaker,
+    }
+    session.Messages = append(session.Messages, msg)
     session.LastActivity = time.Now()
+    cm.commit(userID, JournalEntry{Op: JournalAdd, Message: &msg})
     cm.mu.Unlock()
 }
 
 func (cm *ChatManager) GenerateResponse(userID string) (string, error) {
+    return cm.GenerateResponseAs(userID, "")
+}
+
+// GenerateResponseAs generates the next reply. In a group chat it comes
+// from the named member, or from the next one in turn order when name is
+// empty, and is returned prefixed with the member's name.
+func (cm *ChatManager) GenerateResponseAs(userID, name string) (string, error) {
     cm.mu.Lock()
     session := cm.getOrCreateSession(userID)
-    messages := session.Messages
+    messages, speaker, err := cm.prepareRequest(userID, name, session.Messages, true)
     cm.mu.Unlock()
+    if err != nil {
+        return "", err
+    }
 
     response, err := cm.openAI.GenerateCompletion(context.Background(), CompletionRequest{
         UserID:   userID,
@@ -67,14 +152,54 @@ func (cm *ChatManager) GenerateResponse(userID string) (string, error) {
     })
 
     if err == nil {
-        cm.AddMessage(userID, "assistant", response)
+        if speaker != "" {
+            // Models often start with the name they were given in the history
+            response = strings.TrimSpace(strings.TrimPrefix(response, speaker+":"))
+        }
+        cm.addMessage(userID, "assistant", response, speaker)
+        if speaker != "" {
+            response = fmt.Sprintf("**%s:** %s", speaker, response)
+        }
     }
     return response, err
 }
 
+// prepareRequest prepares the messages of the reply to history and
+// returns them with the group member who writes it, if any. takeTurn moves
+// the group's turn on. Callers must hold cm.mu.
+func (cm *ChatManager) prepareRequest(userID, name string, history []Message, takeTurn bool) ([]Message, string, error) {
+    group, _ := cm.promptManager.Group(userID)
+    if !group.Active() {
+        if name != "" {
+            return nil, "", ErrNoGroup
+        }
+        return cm.promptManager.PrepareContext(userID, history), "", nil
+    }
+
+    pick := cm.promptManager.UpcomingSpeaker
+    if takeTurn {
+        pick = cm.promptManager.NextSpeaker
+    }
+    character, err := pick(userID, name, lastUserMessage(history))
+    if err != nil {
+        return nil, "", err
+    }
+    return cm.promptManager.PrepareGroupContext(userID, history, character), character.Name, nil
+}
+
+// lastUserMessage returns the content of the latest user message.
+func lastUserMessage(messages []Message) string {
+    for i := len(messages) - 1; i >= 0; i-- {
+        if messages[i].Role == "user" {
+            return messages[i].Content
+        }
+    }
+    return ""
+}
+
 func (cm *ChatManager) GetChatHistory(userID string) []Message {
-    cm.mu.RLock()
-    defer cm.mu.RUnlock()
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
     session := cm.getOrCreateSession(userID)
     return session.Messages
 }
@@ -87,6 +212,7 @@ func (cm *ChatManager) ClearChat(userID string) {
         Messages:     prompts,
         LastActivity: time.Now(),
     }
+    cm.commit(userID, JournalEntry{Op: JournalClear, Messages: prompts})
 }
 
 func (cm *ChatManager) RemoveLastMessage(userID string) bool {
@@ -96,6 +222,7 @@ func (cm *ChatManager) RemoveLastMessage(userID string) bool {
     if len(session.Messages) > 0 {
         session.Messages = session.Messages[:len(session.Messages)-1]
         session.LastActivity = time.Now()
+        cm.commit(userID, JournalEntry{Op: JournalUndo})
         return true
     }
     return false
@@ -119,10 +246,11 @@ func (cm *ChatManager) UpdateMessage(messageID, content string) bool {
     cm.mu.Lock()
     defer cm.mu.Unlock()
     
-    for _, session := range cm.sessions {
+    for userID, session := range cm.sessions {
         for i, msg := range session.Messages {
             if msg.ID == messageID {
                 session.Messages[i].Content = content
+                cm.commit(userID, JournalEntry{Op: JournalUpdate, MessageID: messageID, Message: &session.Messages[i]})
                 return true
             }
         }
@@ -134,10 +262,11 @@ func (cm *ChatManager) DeleteMessage(messageID string) bool {
     cm.mu.Lock()
     defer cm.mu.Unlock()
     
-    for _, session := range cm.sessions {
+    for userID, session := range cm.sessions {
         for i, msg := range session.Messages {
             if msg.ID == messageID {
                 session.Messages = append(session.Messages[:i], session.Messages[i+1:]...)
+                cm.commit(userID, JournalEntry{Op: JournalDelete, MessageID: messageID})
                 return true
             }
         }
@@ -151,32 +280,400 @@ func (cm *ChatManager) GetActiveUserCount() int {
     return len(cm.sessions)
 }
 
-func (cm *ChatManager) CleanupInactiveSessions(timeout time.Duration) {
+// CleanupInactiveSessions saves sessions idle for longer than timeout to the
+// store and evicts them from memory. A session that cannot be saved stays in
+// memory. It returns the number of sessions evicted.
+func (cm *ChatManager) CleanupInactiveSessions(timeout time.Duration) int {
     cm.mu.Lock()
     defer cm.mu.Unlock()
     now := time.Now()
+    evicted := 0
     for userID, session := range cm.sessions {
-        if now.Sub(session.LastActivity) > timeout {
-            delete(cm.sessions, userID)
+        if session.IsStreaming || now.Sub(session.LastActivity) <= timeout {
+            continue
+        }
+        if err := cm.store.Save(userID, session); err != nil {
+            log.Printf("Error archiving idle session for %s: %v", userID, err)
+            continue
+        }
+        delete(cm.dirty, userID)
+        delete(cm.sessions, userID)
+        evicted++
+    }
+    return evicted
+}
+
+// ReplaceUserChats swaps the user's active session and saved chats for the
+// given ones. Saved chats that are not in chats are deleted. A chat whose ID
+// already belongs to another user is stored under a new ID.
+func (cm *ChatManager) ReplaceUserChats(userID string, session *ChatSession, chats []*SavedChat) error {
+    existing, err := cm.store.ListChats(userID)
+    if err != nil {
+        return err
+    }
+
+    keep := make(map[string]bool, len(chats))
+    for _, chat := range chats {
+        if chat.IsParked() {
+            chat.ID = parkedChatID(userID, chat.CharacterID)
+        }
+        current, err := cm.store.LoadChat(chat.ID)
+        switch {
+        case err == nil && current.OwnerID != userID:
+            chat.ID = GenerateID()
+        case err != nil && !errors.Is(err, ErrChatNotFound):
+            return err
+        }
+        keep[chat.ID] = true
+        if err := cm.store.SaveChat(chat); err != nil {
+            return err
+        }
+    }
+    for _, chat := range existing {
+        if !keep[chat.ID] {
+            if err := cm.store.DeleteChat(chat.ID); err != nil {
+                return err
+            }
+        }
+    }
+
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    if err := cm.store.Save(userID, session); err != nil {
+        return err
+    }
+    cm.sessions[userID] = session.clone()
+    cm.commit(userID, JournalEntry{Op: JournalClear, Messages: session.Messages})
+    return nil
+}
+
+// DeleteUserData removes the user's active session and every chat they
+// saved. It reports whether there was a session and how many saved chats
+// were deleted. The journal is compacted afterwards so it no longer holds
+// the user's messages.
+func (cm *ChatManager) DeleteUserData(userID string) (bool, int, error) {
+    chats, err := cm.store.ListChats(userID)
+    if err != nil {
+        return false, 0, err
+    }
+    deleted := 0
+    for _, chat := range chats {
+        if err := cm.store.DeleteChat(chat.ID); err != nil {
+            return false, deleted, err
+        }
+        deleted++
+    }
+
+    cm.mu.Lock()
+    _, hadSession := cm.sessions[userID]
+    if _, err := cm.store.Load(userID); !errors.Is(err, ErrSessionNotFound) {
+        hadSession = true
+    }
+    if err := cm.store.Delete(userID); err != nil {
+        cm.mu.Unlock()
+        return hadSession, deleted, err
+    }
+    delete(cm.sessions, userID)
+    delete(cm.dirty, userID)
+    if cm.journal != nil {
+        // Without this record a replay would rebuild the session from the
+        // entries written before it was deleted.
+        if err := cm.journal.Append(JournalEntry{Op: JournalForget, UserID: userID}); err != nil {
+            log.Printf("Error journaling %s for %s: %v", JournalForget, userID, err)
+        }
+    }
+    cm.mu.Unlock()
+
+    if cm.openAI != nil {
+        cm.openAI.ClearHistory(userID)
+    }
+    if err := cm.Compact(); err != nil {
+        log.Printf("Error compacting journal after deleting %s: %v", userID, err)
+    }
+    return hadSession, deleted, nil
+}
+
+// PruneHistory deletes the user's chat messages sent before cutoff, along
+// with saved chats created before it. System prompts are kept. It returns
+// the number of messages and saved chats removed.
+func (cm *ChatManager) PruneHistory(userID string, cutoff time.Time) (int, int, error) {
+    chats, err := cm.store.ListChats(userID)
+    if err != nil {
+        return 0, 0, err
+    }
+    deletedChats := 0
+    for _, chat := range chats {
+        if !chat.CreatedAt.Before(cutoff) {
+            continue
+        }
+        if err := cm.store.DeleteChat(chat.ID); err != nil {
+            return 0, deletedChats, err
+        }
+        deletedChats++
+    }
+
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    session, inMemory := cm.sessions[userID]
+    if !inMemory {
+        // Idle sessions are pruned in the store so they are not pulled
+        // back into memory.
+        session, err = cm.store.Load(userID)
+        if errors.Is(err, ErrSessionNotFound) {
+            return 0, deletedChats, nil
+        }
+        if err != nil {
+            return 0, deletedChats, err
         }
     }
+
+    kept := make([]Message, 0, len(session.Messages))
+    for _, msg := range session.Messages {
+        if msg.Role == "system" || msg.Timestamp.IsZero() || !msg.Timestamp.Before(cutoff) {
+            kept = append(kept, msg)
+        }
+    }
+    pruned := len(session.Messages) - len(kept)
+    if pruned == 0 {
+        return 0, deletedChats, nil
+    }
+
+    session.Messages = kept
+    if inMemory {
+        cm.commit(userID, JournalEntry{Op: JournalClear, Messages: kept})
+        return pruned, deletedChats, nil
+    }
+    if err := cm.store.Save(userID, session); err != nil {
+        return 0, deletedChats, err
+    }
+    return pruned, deletedChats, nil
+}
+
+// UserIDs returns every user with a session in memory or in the store.
+func (cm *ChatManager) UserIDs() ([]string, error) {
+    cm.mu.RLock()
+    defer cm.mu.RUnlock()
+
+    stored, err := cm.store.List()
+    if err != nil {
+        return nil, err
+    }
+    return mergeUserIDs(stored, cm.sessions), nil
 }
 
+// snapshot returns a copy of the user's active session.
+func (cm *ChatManager) snapshot(userID string) *ChatSession {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    return cm.getOrCreateSession(userID).clone()
+}
+
+// getOrCreateSession returns the in-memory session for userID, loading it
+// from the store the first time it is needed. Callers must hold cm.mu.
 func (cm *ChatManager) getOrCreateSession(userID string) *ChatSession {
     session, exists := cm.sessions[userID]
-    if !exists {
+    if exists {
+        return session
+    }
+
+    session, err := cm.store.Load(userID)
+    if err != nil {
+        if !errors.Is(err, ErrSessionNotFound) {
+            log.Printf("Error loading session for %s: %v", userID, err)
+        }
         prompts := cm.promptManager.BuildPromptList(userID)
         session = &ChatSession{
             Messages:     prompts,
             LastActivity: time.Now(),
         }
-        cm.sessions[userID] = session
     }
+    cm.sessions[userID] = session
     return session
 }
 
-func (cm *ChatManager) SaveAllSessions() {
-    // Implementation for saving sessions to persistent storage
+// persist flushes the in-memory session for userID to the store. Callers
+// must hold cm.mu.
+func (cm *ChatManager) persist(userID string) {
+    session, exists := cm.sessions[userID]
+    if !exists {
+        return
+    }
+    if err := cm.store.Save(userID, session); err != nil {
+        log.Printf("Error saving session for %s: %v", userID, err)
+    }
+}
+
+// commit makes a change to the session of userID durable. With a journal
+// the change is appended to it and the session is snapshotted at the next
+// compaction; without one the whole session is saved right away. Callers
+// must hold cm.mu.
+func (cm *ChatManager) commit(userID string, entry JournalEntry) {
+    if cm.journal == nil {
+        cm.persist(userID)
+        return
+    }
+
+    entry.UserID = userID
+    if err := cm.journal.Append(entry); err != nil {
+        log.Printf("Error journaling %s for %s: %v", entry.Op, userID, err)
+        cm.persist(userID)
+        return
+    }
+    cm.dirty[userID] = true
+}
+
+// UseJournal makes the chat manager record every session change in j. Any
+// entries already in j are replayed on top of the stored sessions and then
+// compacted.
+func (cm *ChatManager) UseJournal(j *Journal) error {
+    cm.mu.Lock()
+    count, err := j.Replay(cm.applyJournalEntry)
+    cm.journal = j
+    cm.mu.Unlock()
+    if err != nil {
+        return err
+    }
+
+    if count > 0 {
+        log.Printf("Replayed %d journal entries", count)
+    }
+    return cm.Compact()
+}
+
+// applyJournalEntry redoes one journaled change. Callers must hold cm.mu.
+func (cm *ChatManager) applyJournalEntry(entry JournalEntry) {
+    if entry.Op == JournalForget {
+        delete(cm.sessions, entry.UserID)
+        delete(cm.dirty, entry.UserID)
+        return
+    }
+
+    session := cm.getOrCreateSession(entry.UserID)
+    switch entry.Op {
+    case JournalAdd:
+        if entry.Message != nil {
+            session.Messages = append(session.Messages, *entry.Message)
+        }
+    case JournalUpdate:
+        for i, msg := range session.Messages {
+            if msg.ID == entry.MessageID && entry.Message != nil {
+                session.Messages[i].Content = entry.Message.Content
+                break
+            }
+        }
+    case JournalDelete:
+        for i, msg := range session.Messages {
+            if msg.ID == entry.MessageID {
+                session.Messages = append(session.Messages[:i], session.Messages[i+1:]...)
+                break
+            }
+        }
+    case JournalUndo:
+        if len(session.Messages) > 0 {
+            session.Messages = session.Messages[:len(session.Messages)-1]
+        }
+    case JournalClear:
+        session.Messages = append([]Message(nil), entry.Messages...)
+    default:
+        log.Printf("Skipping unknown journal op %q", entry.Op)
+        return
+    }
+    session.LastActivity = entry.Time
+    cm.dirty[entry.UserID] = true
+}
+
+// Compact snapshots every session changed since the last compaction to the
+// store and empties the journal.
+func (cm *ChatManager) Compact() error {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    if cm.journal == nil {
+        return nil
+    }
+
+    var errs []error
+    for userID := range cm.dirty {
+        session, exists := cm.sessions[userID]
+        if !exists {
+            delete(cm.dirty, userID)
+            continue
+        }
+        if err := cm.store.Save(userID, session); err != nil {
+            errs = append(errs, fmt.Errorf("saving session %s: %v", userID, err))
+            continue
+        }
+        delete(cm.dirty, userID)
+    }
+    if len(errs) > 0 {
+        // Keep the journal so nothing is lost; the next compaction retries.
+        return errors.Join(errs...)
+    }
+    return cm.journal.Truncate()
+}
+
+// StartCompaction compacts the journal every interval until StopCompaction
+// is called.
+func (cm *ChatManager) StartCompaction(interval time.Duration) {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    if cm.stopCompact != nil || interval <= 0 {
+        return
+    }
+    stop := make(chan struct{})
+    cm.stopCompact = stop
+
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                if err := cm.Compact(); err != nil {
+                    log.Printf("Error compacting journal: %v", err)
+                }
+            case <-stop:
+                return
+            }
+        }
+    }()
+}
+
+func (cm *ChatManager) StopCompaction() {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    if cm.stopCompact != nil {
+        close(cm.stopCompact)
+        cm.stopCompact = nil
+    }
+}
+
+// SaveAllSessions snapshots every in-memory session to the store. With a
+// journal, it is emptied once everything has been saved.
+func (cm *ChatManager) SaveAllSessions() error {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    var errs []error
+    for userID, session := range cm.sessions {
+        if err := cm.store.Save(userID, session); err != nil {
+            errs = append(errs, fmt.Errorf("saving session %s: %v", userID, err))
+            continue
+        }
+        delete(cm.dirty, userID)
+    }
+    if len(errs) > 0 {
+        return errors.Join(errs...)
+    }
+    if cm.journal != nil {
+        return cm.journal.Truncate()
+    }
+    return nil
 }
 
 func (cm *ChatManager) GetUptime() time.Duration {
@@ -208,10 +705,13 @@ func (cm *ChatManager) RegenerateLastResponse(userID string) string {
         return "No message to regenerate!"
     }
     
+    // In a group chat the same member tries again
+    speaker := session.Messages[len(session.Messages)-1].Speaker
     session.Messages = session.Messages[:len(session.Messages)-1]
+    cm.commit(userID, JournalEntry{Op: JournalUndo})
     cm.mu.Unlock()
     
-    response, _ := cm.GenerateResponse(userID)
+    response, _ := cm.GenerateResponseAs(userID, speaker)
     return response
 }
 
@@ -221,8 +721,8 @@ func (cm *ChatManager) GetMemoryStats(userID string) struct {
     MessageCount int
     ContextSize  float64
 } {
-    cm.mu.RLock()
-    defer cm.mu.RUnlock()
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
     
     session := cm.getOrCreateSession(userID)
     return struct {
@@ -242,32 +742,174 @@ func (cm *ChatManager) ContinueChat(userID string) string {
     cm.mu.Lock()
     session := cm.getOrCreateSession(userID)
     lastMessage := session.Messages[len(session.Messages)-1].Content
+    speaker := session.Messages[len(session.Messages)-1].Speaker
     cm.mu.Unlock()
     
-    response, _ := cm.GenerateResponse(userID)
+    response, _ := cm.GenerateResponseAs(userID, speaker)
     return "Continuing from: " + lastMessage + "\n\n" + response
 }
 
-func (cm *ChatManager) SaveChat(userID string) string {
-    chatID := GenerateID()
+// SaveChat stores a copy of the user's current session under a new saved
+// chat slot. An empty title is replaced by one based on the current time.
+func (cm *ChatManager) SaveChat(userID, title string) (*SavedChat, error) {
+    cm.mu.Lock()
     session := cm.getOrCreateSession(userID)
-    
-    cm.sessions[chatID] = &ChatSession{
-        Messages:     session.Messages,
-        LastActivity: time.Now(),
+    messages := append([]Message(nil), session.Messages...)
+    cm.mu.Unlock()
+
+    now := time.Now()
+    if title == "" {
+        title = "Chat from " + now.Format("Jan 2 15:04")
     }
-    
-    return chatID
+
+    chat := &SavedChat{
+        ID:        GenerateID(),
+        OwnerID:   userID,
+        Title:     title,
+        CreatedAt: now,
+        Messages:  messages,
+    }
+    if err := cm.store.SaveChat(chat); err != nil {
+        return nil, err
+    }
+    return chat, nil
 }
 
+// LoadChat replaces the user's current session with a saved chat. Users can
+// only load chats they own.
 func (cm *ChatManager) LoadChat(userID, chatID string) error {
+    chat, err := cm.ownedChat(userID, chatID)
+    if err != nil {
+        return err
+    }
+
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+    cm.sessions[userID] = &ChatSession{
+        Messages:     chat.Messages,
+        LastActivity: time.Now(),
+    }
+    cm.commit(userID, JournalEntry{Op: JournalClear, Messages: chat.Messages})
+    return nil
+}
+
+// ListChats returns the user's saved chats, newest first.
+func (cm *ChatManager) ListChats(userID string) ([]*SavedChat, error) {
+    chats, err := cm.allChats(userID)
+    if err != nil {
+        return nil, err
+    }
+
+    visible := chats[:0]
+    for _, chat := range chats {
+        if !chat.IsParked() {
+            visible = append(visible, chat)
+        }
+    }
+    return visible, nil
+}
+
+// allChats returns the user's saved chats, including the parked sessions
+// of their inactive characters, newest first.
+func (cm *ChatManager) allChats(userID string) ([]*SavedChat, error) {
+    chats, err := cm.store.ListChats(userID)
+    if err != nil {
+        return nil, err
+    }
+    sort.Slice(chats, func(i, j int) bool {
+        return chats[i].CreatedAt.After(chats[j].CreatedAt)
+    })
+    return chats, nil
+}
+
+// SwitchCharacter parks the user's session under the character they are
+// leaving and resumes the parked session of the one they switch to, or
+// starts a new session for it. The prompt manager must already have
+// switched, so a new session is built for the new character.
+func (cm *ChatManager) SwitchCharacter(userID string, from, to Character) error {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    session := cm.getOrCreateSession(userID)
+    parked := &SavedChat{
+        ID:          parkedChatID(userID, from.ID),
+        OwnerID:     userID,
+        Title:       from.Name,
+        CharacterID: from.ID,
+        CreatedAt:   time.Now(),
+        Messages:    append([]Message(nil), session.Messages...),
+    }
+    if err := cm.store.SaveChat(parked); err != nil {
+        return err
+    }
+
+    var messages []Message
+    resumed, err := cm.store.LoadChat(parkedChatID(userID, to.ID))
+    switch {
+    case err == nil && resumed.OwnerID == userID:
+        messages = resumed.Messages
+        if err := cm.store.DeleteChat(resumed.ID); err != nil {
+            log.Printf("Error removing parked session %s: %v", resumed.ID, err)
+        }
+    case err == nil || errors.Is(err, ErrChatNotFound):
+        messages = cm.promptManager.BuildPromptList(userID)
+    default:
+        return err
+    }
+
+    cm.sessions[userID] = &ChatSession{
+        Messages:     messages,
+        LastActivity: time.Now(),
+    }
+    cm.commit(userID, JournalEntry{Op: JournalClear, Messages: messages})
     return nil
 }
 
+// DeleteCharacterHistory removes the parked session of a character.
+func (cm *ChatManager) DeleteCharacterHistory(userID, characterID string) error {
+    chat, err := cm.store.LoadChat(parkedChatID(userID, characterID))
+    if errors.Is(err, ErrChatNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if chat.OwnerID != userID {
+        return ErrChatNotOwned
+    }
+    return cm.store.DeleteChat(chat.ID)
+}
+
+// DeleteChat removes one of the user's saved chats and returns it.
+func (cm *ChatManager) DeleteChat(userID, chatID string) (*SavedChat, error) {
+    chat, err := cm.ownedChat(userID, chatID)
+    if err != nil {
+        return nil, err
+    }
+    if err := cm.store.DeleteChat(chatID); err != nil {
+        return nil, err
+    }
+    return chat, nil
+}
+
+func (cm *ChatManager) ownedChat(userID, chatID string) (*SavedChat, error) {
+    chat, err := cm.store.LoadChat(chatID)
+    if err != nil {
+        return nil, err
+    }
+    if chat.IsParked() {
+        return nil, ErrChatNotFound
+    }
+    if chat.OwnerID != userID {
+        return nil, ErrChatNotOwned
+    }
+    return chat, nil
+}
+
 func (cm *ChatManager) ExportChat(userID, format string) string {
-    cm.mu.RLock()
+    cm.mu.Lock()
     session := cm.getOrCreateSession(userID)
-    cm.mu.RUnlock()
+    cm.mu.Unlock()
     
     var export string
     if format == "json" {
@@ -275,7 +917,11 @@ func (cm *ChatManager) ExportChat(userID, format string) string {
         export = string(data)
     } else {
         for _, msg := range session.Messages {
-            export += fmt.Sprintf("%s: %s\n", msg.Role, msg.Content)
+            role := msg.Role
+            if msg.Speaker != "" {
+                role = msg.Speaker
+            }
+            export += fmt.Sprintf("%s: %s\n", role, msg.Content)
         }
     }
     
@@ -0,0 +1,96 @@
+package services
+
+import (
+    "testing"
+)
+
+// userTurns lists the content of the user messages in a history.
+func userTurns(messages []Message) []string {
+    var turns []string
+    for _, msg := range messages {
+        if msg.Role == "user" {
+            turns = append(turns, msg.Content)
+        }
+    }
+    return turns
+}
+
+func TestSwitchCharacterParksSessions(t *testing.T) {
+    store := NewMemorySessionStore()
+    pm := NewPromptManager(nil)
+    cm := NewChatManager(nil, pm, store)
+
+    steps := []struct {
+        character string
+        create    bool
+        want      []string
+        say       string
+    }{
+        {character: "Anna", create: true, want: nil, say: "hi Anna"},
+        {character: "Bea", create: true, want: nil, say: "hi Bea"},
+        {character: "Anna", want: []string{"hi Anna"}, say: "again Anna"},
+        {character: "Bea", want: []string{"hi Bea"}},
+        {character: "Anna", want: []string{"hi Anna", "again Anna"}},
+    }
+    characters := make(map[string]Character)
+    for i, step := range steps {
+        var previous, next Character
+        var err error
+        if step.create {
+            previous, next, err = pm.CreateCharacter("alice", step.character)
+        } else {
+            previous, next, err = pm.SwitchCharacter("alice", step.character)
+        }
+        if err != nil {
+            t.Fatalf("step %d: %v", i, err)
+        }
+        characters[next.Name] = next
+        if err := cm.SwitchCharacter("alice", previous, next); err != nil {
+            t.Fatalf("step %d: SwitchCharacter: %v", i, err)
+        }
+        if got := userTurns(cm.GetChatHistory("alice")); !equalStrings(got, step.want) {
+            t.Errorf("step %d: history for %s = %q, want %q", i, next.Name, got, step.want)
+        }
+        if step.say != "" {
+            cm.AddMessage("alice", "user", step.say)
+        }
+    }
+
+    // Parked sessions are not listed as saved chats
+    if chats, err := cm.ListChats("alice"); err != nil || len(chats) != 0 {
+        t.Errorf("ListChats = %d chats, %v, want none", len(chats), err)
+    }
+
+    // Another user parking a session under the same character ID, as a
+    // restored archive could, does not touch Alice's
+    bea := characters["Bea"]
+    cm.AddMessage("bob", "user", "hi from Bob")
+    if err := cm.SwitchCharacter("bob", bea, Character{ID: "other", Name: "Other"}); err != nil {
+        t.Fatalf("SwitchCharacter for bob: %v", err)
+    }
+    if err := cm.DeleteCharacterHistory("bob", bea.ID); err != nil {
+        t.Fatalf("DeleteCharacterHistory for bob: %v", err)
+    }
+    parked, err := store.LoadChat(parkedChatID("alice", bea.ID))
+    if err != nil {
+        t.Fatalf("Alice's parked session for Bea: %v", err)
+    }
+    if got := userTurns(parked.Messages); !equalStrings(got, []string{"hi Bea"}) {
+        t.Errorf("Alice's parked session for Bea = %q", got)
+    }
+
+    // Deleting a character's history removes only its parked session
+    if err := cm.DeleteCharacterHistory("alice", bea.ID); err != nil {
+        t.Fatalf("DeleteCharacterHistory: %v", err)
+    }
+    previous, next, err := pm.SwitchCharacter("alice", "Bea")
+    if err != nil {
+        t.Fatalf("SwitchCharacter: %v", err)
+    }
+    if err := cm.SwitchCharacter("alice", previous, next); err != nil {
+        t.Fatalf("SwitchCharacter: %v", err)
+    }
+    if got := userTurns(cm.GetChatHistory("alice")); len(got) != 0 {
+        t.Errorf("Bea's history after deleting it = %q, want a new chat", got)
+    }
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "sync"
+)
+
+var ErrConfigNotFound = errors.New("config not found")
+
+// ConfigStore persists per-user sampling settings.
+type ConfigStore interface {
+    Load(userID string) (*UserConfig, error)
+    Save(userID string, config *UserConfig) error
+    Delete(userID string) error
+    List() ([]string, error)
+}
+
+// FileConfigStore keeps one JSON file per user in a directory.
+type FileConfigStore struct {
+    files *jsonDir
+}
+
+func NewFileConfigStore(dir string) (*FileConfigStore, error) {
+    files, err := newJSONDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    return &FileConfigStore{files: files}, nil
+}
+
+func (s *FileConfigStore) Load(userID string) (*UserConfig, error) {
+    var config UserConfig
+    if err := s.files.load(userID, &config); err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, ErrConfigNotFound
+        }
+        return nil, fmt.Errorf("error reading config: %v", err)
+    }
+    return &config, nil
+}
+
+func (s *FileConfigStore) Save(userID string, config *UserConfig) error {
+    if err := s.files.save(userID, config); err != nil {
+        return fmt.Errorf("error writing config: %v", err)
+    }
+    return nil
+}
+
+func (s *FileConfigStore) Delete(userID string) error {
+    if err := s.files.delete(userID); err != nil {
+        return fmt.Errorf("error deleting config: %v", err)
+    }
+    return nil
+}
+
+func (s *FileConfigStore) List() ([]string, error) {
+    userIDs, err := s.files.list()
+    if err != nil {
+        return nil, fmt.Errorf("error listing configs: %v", err)
+    }
+    return userIDs, nil
+}
+
+// MemoryConfigStore keeps user configs in memory.
+type MemoryConfigStore struct {
+    configs map[string]*UserConfig
+    mu      sync.RWMutex
+}
+
+func NewMemoryConfigStore() *MemoryConfigStore {
+    return &MemoryConfigStore{
+        configs: make(map[string]*UserConfig),
+    }
+}
+
+func (s *MemoryConfigStore) Load(userID string) (*UserConfig, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    config, exists := s.configs[userID]
+    if !exists {
+        return nil, ErrConfigNotFound
+    }
+    copied := *config
+    return &copied, nil
+}
+
+func (s *MemoryConfigStore) Save(userID string, config *UserConfig) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    copied := *config
+    s.configs[userID] = &copied
+    return nil
+}
+
+func (s *MemoryConfigStore) Delete(userID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.configs, userID)
+    return nil
+}
+
+func (s *MemoryConfigStore) List() ([]string, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    userIDs := make([]string, 0, len(s.configs))
+    for userID := range s.configs {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+    return userIDs, nil
+}
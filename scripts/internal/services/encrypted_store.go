@@ -0,0 +1,193 @@
+package services
+
+import (
+    "fmt"
+)
+
+// Sealer encrypts values before they are persisted and decrypts them when
+// they are read back. Open must return values that were never sealed
+// unchanged, so stores written before encryption was enabled keep working.
+type Sealer interface {
+    Seal(plaintext string) (string, error)
+    Open(sealed string) (string, error)
+}
+
+// EncryptedSessionStore wraps a SessionStore so message contents and saved
+// chat titles are encrypted before they reach it.
+type EncryptedSessionStore struct {
+    SessionStore
+    sealer Sealer
+}
+
+func NewEncryptedSessionStore(store SessionStore, sealer Sealer) *EncryptedSessionStore {
+    return &EncryptedSessionStore{SessionStore: store, sealer: sealer}
+}
+
+func (s *EncryptedSessionStore) Load(userID string) (*ChatSession, error) {
+    session, err := s.SessionStore.Load(userID)
+    if err != nil {
+        return nil, err
+    }
+    if session.Messages, err = transformMessages(session.Messages, s.sealer.Open); err != nil {
+        return nil, fmt.Errorf("error decrypting session for %s: %v", userID, err)
+    }
+    return session, nil
+}
+
+func (s *EncryptedSessionStore) Save(userID string, session *ChatSession) error {
+    sealed := session.clone()
+    var err error
+    if sealed.Messages, err = transformMessages(session.Messages, s.sealer.Seal); err != nil {
+        return fmt.Errorf("error encrypting session for %s: %v", userID, err)
+    }
+    return s.SessionStore.Save(userID, sealed)
+}
+
+func (s *EncryptedSessionStore) SaveChat(chat *SavedChat) error {
+    sealed, err := transformChat(chat, s.sealer.Seal)
+    if err != nil {
+        return fmt.Errorf("error encrypting saved chat %s: %v", chat.ID, err)
+    }
+    return s.SessionStore.SaveChat(sealed)
+}
+
+func (s *EncryptedSessionStore) LoadChat(chatID string) (*SavedChat, error) {
+    chat, err := s.SessionStore.LoadChat(chatID)
+    if err != nil {
+        return nil, err
+    }
+    opened, err := transformChat(chat, s.sealer.Open)
+    if err != nil {
+        return nil, fmt.Errorf("error decrypting saved chat %s: %v", chatID, err)
+    }
+    return opened, nil
+}
+
+func (s *EncryptedSessionStore) ListChats(ownerID string) ([]*SavedChat, error) {
+    chats, err := s.SessionStore.ListChats(ownerID)
+    if err != nil {
+        return nil, err
+    }
+    for i, chat := range chats {
+        if chats[i], err = transformChat(chat, s.sealer.Open); err != nil {
+            return nil, fmt.Errorf("error decrypting saved chat %s: %v", chat.ID, err)
+        }
+    }
+    return chats, nil
+}
+
+// Reencrypt rewrites every session and saved chat with the current primary
+// key. It returns the number of records rewritten.
+func (s *EncryptedSessionStore) Reencrypt() (int, error) {
+    userIDs, err := s.List()
+    if err != nil {
+        return 0, err
+    }
+
+    count := 0
+    for _, userID := range userIDs {
+        session, err := s.Load(userID)
+        if err != nil {
+            return count, err
+        }
+        if err := s.Save(userID, session); err != nil {
+            return count, err
+        }
+        count++
+
+        chats, err := s.ListChats(userID)
+        if err != nil {
+            return count, err
+        }
+        for _, chat := range chats {
+            if err := s.SaveChat(chat); err != nil {
+                return count, err
+            }
+            count++
+        }
+    }
+    return count, nil
+}
+
+// EncryptedPromptStore wraps a PromptStore so user tokens are encrypted
+// before they reach it.
+type EncryptedPromptStore struct {
+    PromptStore
+    sealer Sealer
+}
+
+func NewEncryptedPromptStore(store PromptStore, sealer Sealer) *EncryptedPromptStore {
+    return &EncryptedPromptStore{PromptStore: store, sealer: sealer}
+}
+
+func (s *EncryptedPromptStore) Load(userID string) (*UserPrompts, error) {
+    prompts, err := s.PromptStore.Load(userID)
+    if err != nil {
+        return nil, err
+    }
+    if prompts.UserToken, err = s.sealer.Open(prompts.UserToken); err != nil {
+        return nil, fmt.Errorf("error decrypting token for %s: %v", userID, err)
+    }
+    return prompts, nil
+}
+
+func (s *EncryptedPromptStore) Save(userID string, prompts *UserPrompts) error {
+    sealed := prompts.clone()
+    if sealed.UserToken != "" {
+        var err error
+        if sealed.UserToken, err = s.sealer.Seal(sealed.UserToken); err != nil {
+            return fmt.Errorf("error encrypting token for %s: %v", userID, err)
+        }
+    }
+    return s.PromptStore.Save(userID, sealed)
+}
+
+// Reencrypt rewrites every user's prompts with the current primary key. It
+// returns the number of records rewritten.
+func (s *EncryptedPromptStore) Reencrypt() (int, error) {
+    userIDs, err := s.List()
+    if err != nil {
+        return 0, err
+    }
+
+    for i, userID := range userIDs {
+        prompts, err := s.Load(userID)
+        if err != nil {
+            return i, err
+        }
+        if err := s.Save(userID, prompts); err != nil {
+            return i, err
+        }
+    }
+    return len(userIDs), nil
+}
+
+// transformMessages returns a copy of messages with fn applied to every
+// non-empty content.
+func transformMessages(messages []Message, fn func(string) (string, error)) ([]Message, error) {
+    result := make([]Message, len(messages))
+    for i, msg := range messages {
+        result[i] = msg
+        if msg.Content == "" {
+            continue
+        }
+        content, err := fn(msg.Content)
+        if err != nil {
+            return nil, err
+        }
+        result[i].Content = content
+    }
+    return result, nil
+}
+
+func transformChat(chat *SavedChat, fn func(string) (string, error)) (*SavedChat, error) {
+    result := chat.clone()
+    var err error
+    if result.Messages, err = transformMessages(chat.Messages, fn); err != nil {
+        return nil, err
+    }
+    if result.Title, err = fn(chat.Title); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
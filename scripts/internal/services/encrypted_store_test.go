@@ -0,0 +1,86 @@
+package services
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/encryption"
+)
+
+func TestReencryptWithPrimaryKey(t *testing.T) {
+    key := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+    old, err := encryption.NewKeyring("k1", map[string][]byte{"k1": key(1)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    rotated, err := encryption.NewKeyring("k2", map[string][]byte{"k1": key(1), "k2": key(2)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+    retired, err := encryption.NewKeyring("k2", map[string][]byte{"k2": key(2)})
+    if err != nil {
+        t.Fatalf("NewKeyring: %v", err)
+    }
+
+    sessions := NewMemorySessionStore()
+    prompts := NewMemoryPromptStore()
+    users := []struct {
+        id      string
+        message string
+        token   string
+    }{
+        {"alice", "hello", "token-a"},
+        {"bob", "hi there", "token-b"},
+        {"carol", "", ""},
+    }
+    for _, user := range users {
+        session := &ChatSession{Messages: []Message{{ID: "1", Role: "user", Content: user.message}}}
+        if err := NewEncryptedSessionStore(sessions, old).Save(user.id, session); err != nil {
+            t.Fatalf("Save session: %v", err)
+        }
+        if err := NewEncryptedPromptStore(prompts, old).Save(user.id, &UserPrompts{UserToken: user.token}); err != nil {
+            t.Fatalf("Save prompts: %v", err)
+        }
+    }
+
+    sessionCount, err := NewEncryptedSessionStore(sessions, rotated).Reencrypt()
+    if err != nil {
+        t.Fatalf("Reencrypt sessions: %v", err)
+    }
+    promptCount, err := NewEncryptedPromptStore(prompts, rotated).Reencrypt()
+    if err != nil {
+        t.Fatalf("Reencrypt prompts: %v", err)
+    }
+    if sessionCount != len(users) || promptCount != len(users) {
+        t.Errorf("re-encrypted %d sessions and %d prompts, want %d each", sessionCount, promptCount, len(users))
+    }
+
+    // Everything opens once the old key is gone
+    for _, user := range users {
+        t.Run(user.id, func(t *testing.T) {
+            raw, err := sessions.Load(user.id)
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            if content := raw.Messages[0].Content; content != "" && !strings.HasPrefix(content, "enc:v1:k2:") {
+                t.Errorf("stored message %q is not sealed with the primary key", content)
+            }
+
+            session, err := NewEncryptedSessionStore(sessions, retired).Load(user.id)
+            if err != nil {
+                t.Fatalf("Load session: %v", err)
+            }
+            if got := session.Messages[0].Content; got != user.message {
+                t.Errorf("message = %q, want %q", got, user.message)
+            }
+            loaded, err := NewEncryptedPromptStore(prompts, retired).Load(user.id)
+            if err != nil {
+                t.Fatalf("Load prompts: %v", err)
+            }
+            if loaded.UserToken != user.token {
+                t.Errorf("token = %q, want %q", loaded.UserToken, user.token)
+            }
+        })
+    }
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+    "regexp"
+    "strings"
+)
+
+// DefaultContextTokens is the prompt size, in estimated tokens, example
+// dialogue is fitted into when no other budget is set.
+const DefaultContextTokens = 8192
+
+// Markers sent around the example conversations, as SillyTavern does, so
+// the model does not take them for the real chat.
+const (
+    exampleChatMarker = "[Example Chat]"
+    newChatMarker     = "[Start a new chat]"
+)
+
+var startPattern = regexp.MustCompile(`(?i)<START>`)
+
+// speakerPrefixes are the example dialogue prefixes recognized anywhere,
+// so dialogue typed on one line in a slash command still parses.
+const speakerPrefixes = `(\{\{user\}\}|<USER>|\{\{char\}\}|<BOT>)\s*:`
+
+// ParseExampleDialogue splits a character's example dialogue (mes_example)
+// into its <START>-separated conversations. Each conversation is a list of
+// user and assistant turns; lines are assigned by their {{user}}: and
+// {{char}}: prefixes (or <USER>: and <BOT>:), and by "Name:" prefixes with
+// the names in ctx at the start of a line. Text before the first prefix of
+// a conversation becomes a system message. Macros are expanded.
+func ParseExampleDialogue(text string, ctx MacroContext) [][]Message {
+    pattern := `(?im)` + speakerPrefixes
+    var names []string
+    for _, name := range []string{ctx.User, ctx.Char} {
+        if name != "" {
+            names = append(names, regexp.QuoteMeta(name))
+        }
+    }
+    if len(names) > 0 {
+        pattern += `|^[ \t]*(` + strings.Join(names, "|") + `)\s*:`
+    }
+    speakers := regexp.MustCompile(pattern)
+
+    var blocks [][]Message
+    for _, block := range startPattern.Split(text, -1) {
+        var turns []Message
+        addTurn := func(role, content string) {
+            content = strings.TrimSpace(ExpandMacros(content, ctx))
+            if content != "" {
+                turns = append(turns, Message{Role: role, Content: content})
+            }
+        }
+
+        role, start := "system", 0
+        for _, match := range speakers.FindAllStringSubmatchIndex(block, -1) {
+            addTurn(role, block[start:match[0]])
+            speaker := ""
+            if match[2] >= 0 {
+                speaker = block[match[2]:match[3]]
+            } else {
+                speaker = block[match[4]:match[5]]
+            }
+            role = speakerRole(speaker, ctx)
+            start = match[1]
+        }
+        addTurn(role, block[start:])
+
+        if len(turns) > 0 {
+            blocks = append(blocks, turns)
+        }
+    }
+    return blocks
+}
+
+// speakerRole maps an example dialogue prefix to a chat role. A name that
+// is both the user's and the character's counts as the character.
+func speakerRole(speaker string, ctx MacroContext) string {
+    switch strings.ToLower(speaker) {
+    case "{{user}}", "<user>":
+        return "user"
+    case "{{char}}", "<bot>":
+        return "assistant"
+    }
+    if strings.EqualFold(speaker, ctx.User) && !strings.EqualFold(speaker, ctx.Char) {
+        return "user"
+    }
+    return "assistant"
+}
+
+// SetContextBudget sets how many estimated tokens a prepared context may
+// use before example dialogue is dropped.
+func (pm *PromptManager) SetContextBudget(tokens int) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if tokens <= 0 {
+        tokens = DefaultContextTokens
+    }
+    pm.contextTokens = tokens
+}
+
+// fitExamples returns the example conversations that fit in budget next
+// to messages, as messages ready to insert. Conversations are dropped
+// whole, the last ones first.
+func fitExamples(blocks [][]Message, messages []Message, budget int) []Message {
+    used := estimateMessages(messages) + EstimateTokens(newChatMarker)
+    var examples []Message
+    for _, block := range blocks {
+        tokens := EstimateTokens(exampleChatMarker) + estimateMessages(block)
+        if used+tokens > budget {
+            break
+        }
+        used += tokens
+        examples = append(examples, Message{Role: "system", Content: exampleChatMarker})
+        examples = append(examples, block...)
+    }
+    if len(examples) > 0 {
+        examples = append(examples, Message{Role: "system", Content: newChatMarker})
+    }
+    for i := range examples {
+        examples[i].Section = SectionExamples
+    }
+    return examples
+}
+
+// estimateMessages is EstimateTokens summed over messages.
+func estimateMessages(messages []Message) int {
+    total := 0
+    for _, msg := range messages {
+        total += EstimateTokens(msg.Content)
+    }
+    return total
+}
+
+// blockEnabled reports whether the built-in block is enabled in the
+// user's prompt stack.
+func (p *UserPrompts) blockEnabled(block string) bool {
+    for _, entry := range p.Prompts {
+        if entry.Block == block {
+            return entry.Enabled
+        }
+    }
+    return false
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+    "strings"
+    "testing"
+)
+
+// describeTurns writes messages as "role: content" for comparing.
+func describeTurns(messages []Message) []string {
+    turns := make([]string, len(messages))
+    for i, msg := range messages {
+        turns[i] = msg.Role + ": " + msg.Content
+    }
+    return turns
+}
+
+func TestParseExampleDialogue(t *testing.T) {
+    ctx := MacroContext{Char: "Alice", User: "Bob"}
+    tests := []struct {
+        name string
+        text string
+        want [][]string
+    }{
+        {
+            name: "macro prefixes",
+            text: "<START>\n{{user}}: Hi\n{{char}}: Hello, {{user}}!",
+            want: [][]string{{"user: Hi", "assistant: Hello, Bob!"}},
+        },
+        {
+            name: "several conversations",
+            text: "<START>\n{{user}}: Hi\n{{char}}: Hello\n<start>\n<USER>: Bye\n<BOT>: See you",
+            want: [][]string{
+                {"user: Hi", "assistant: Hello"},
+                {"user: Bye", "assistant: See you"},
+            },
+        },
+        {
+            name: "names at the start of a line",
+            text: "<START>\nBob: Where are we?\nAlice: In the forest.\nThe trees say Bob: nothing",
+            want: [][]string{{"user: Where are we?", "assistant: In the forest.\nThe trees say Bob: nothing"}},
+        },
+        {
+            name: "typed on one line",
+            text: "<START> {{user}}: Hi {{char}}: Hello",
+            want: [][]string{{"user: Hi", "assistant: Hello"}},
+        },
+        {
+            name: "text before the first prefix",
+            text: "<START>\nAlice is in a good mood.\n{{char}}: Hello!",
+            want: [][]string{{"system: Alice is in a good mood.", "assistant: Hello!"}},
+        },
+        {
+            name: "multi-line turns",
+            text: "{{char}}: First line\nsecond line\n{{user}}: Ok",
+            want: [][]string{{"assistant: First line\nsecond line", "user: Ok"}},
+        },
+        {
+            name: "empty conversations are skipped",
+            text: "<START>\n<START>\n{{user}}: Hi\n<START>  ",
+            want: [][]string{{"user: Hi"}},
+        },
+        {
+            name: "empty",
+            text: "",
+            want: nil,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            blocks := ParseExampleDialogue(tt.text, ctx)
+            if len(blocks) != len(tt.want) {
+                t.Fatalf("parsed %d conversations %q, want %d", len(blocks), blocks, len(tt.want))
+            }
+            for i, block := range blocks {
+                if got := describeTurns(block); !equalStrings(got, tt.want[i]) {
+                    t.Errorf("conversation %d = %q, want %q", i, got, tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestSpeakerRole(t *testing.T) {
+    tests := []struct {
+        speaker string
+        ctx     MacroContext
+        want    string
+    }{
+        {"{{user}}", MacroContext{}, "user"},
+        {"<USER>", MacroContext{}, "user"},
+        {"{{char}}", MacroContext{}, "assistant"},
+        {"bob", MacroContext{Char: "Alice", User: "Bob"}, "user"},
+        {"Alice", MacroContext{Char: "Alice", User: "Bob"}, "assistant"},
+        {"Sam", MacroContext{Char: "Sam", User: "Sam"}, "assistant"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.speaker, func(t *testing.T) {
+            if got := speakerRole(tt.speaker, tt.ctx); got != tt.want {
+                t.Errorf("speakerRole(%q) = %q, want %q", tt.speaker, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFitExamples(t *testing.T) {
+    // Every conversation costs 4 tokens of marker and 10 of turns, and the
+    // closing marker 5
+    turn := strings.Repeat("x", 40)
+    blocks := [][]Message{
+        {{Role: "user", Content: turn}},
+        {{Role: "assistant", Content: turn}},
+        {{Role: "user", Content: turn}},
+    }
+    history := []Message{{Role: "system", Content: strings.Repeat("y", 400)}} // 100 tokens
+
+    tests := []struct {
+        name     string
+        messages []Message
+        budget   int
+        want     int
+    }{
+        {"everything fits", nil, 1000, 3},
+        {"exactly two", nil, 5 + 2*14, 2},
+        {"one token short of two", nil, 5 + 2*14 - 1, 1},
+        {"nothing fits", nil, 5 + 14 - 1, 0},
+        {"the chat takes its share", history, 100 + 5 + 14, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            examples := fitExamples(blocks, tt.messages, tt.budget)
+            if tt.want == 0 {
+                if len(examples) != 0 {
+                    t.Fatalf("fitted %q, want nothing", describeTurns(examples))
+                }
+                return
+            }
+            markers := 0
+            for _, msg := range examples {
+                if msg.Content == exampleChatMarker {
+                    markers++
+                }
+                if msg.Section != SectionExamples {
+                    t.Errorf("message %q is in section %q", msg.Content, msg.Section)
+                }
+            }
+            if markers != tt.want {
+                t.Errorf("fitted %d conversations, want %d", markers, tt.want)
+            }
+            if last := examples[len(examples)-1]; last.Content != newChatMarker {
+                t.Errorf("examples end with %q, want %q", last.Content, newChatMarker)
+            }
+            // The first conversations are kept
+            if examples[1].Role != "user" {
+                t.Errorf("first fitted turn is %q, want the first conversation", examples[1].Role)
+            }
+        })
+    }
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// jsonDir stores one JSON document per key in a directory. It backs the
+// file-based stores.
+type jsonDir struct {
+    dir string
+    mu  sync.Mutex
+}
+
+func newJSONDir(dir string) (*jsonDir, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, fmt.Errorf("error creating directory %s: %v", dir, err)
+    }
+    return &jsonDir{dir: dir}, nil
+}
+
+// load decodes the document for key into v. It returns an error wrapping
+// os.ErrNotExist when there is no document.
+func (d *jsonDir) load(key string, v interface{}) error {
+    path, err := d.path(key)
+    if err != nil {
+        return err
+    }
+
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return readJSONFile(path, v)
+}
+
+func (d *jsonDir) save(key string, v interface{}) error {
+    path, err := d.path(key)
+    if err != nil {
+        return err
+    }
+
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return writeJSONFile(path, v)
+}
+
+func (d *jsonDir) delete(key string) error {
+    path, err := d.path(key)
+    if err != nil {
+        return err
+    }
+
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+    return nil
+}
+
+func (d *jsonDir) list() ([]string, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    entries, err := os.ReadDir(d.dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var keys []string
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+            continue
+        }
+        keys = append(keys, strings.TrimSuffix(name, ".json"))
+    }
+    return keys, nil
+}
+
+func (d *jsonDir) path(key string) (string, error) {
+    if !isSafeFileKey(key) {
+        return "", fmt.Errorf("invalid key %q", key)
+    }
+    return filepath.Join(d.dir, key+".json"), nil
+}
+
+// isSafeFileKey reports whether key can be used as a file name without
+// escaping the store directory.
+func isSafeFileKey(key string) bool {
+    if key == "" || key == "." || key == ".." {
+        return false
+    }
+    return !strings.ContainsAny(key, `/\`)
+}
+
+// readJSONFile decodes the JSON file at path into v.
+func readJSONFile(path string, v interface{}) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// writeJSONFile writes v to path through a temporary file so a crash never
+// leaves a half-written file behind.
+func writeJSONFile(path string, v interface{}) error {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
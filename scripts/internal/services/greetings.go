@@ -0,0 +1,129 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// maxGreetings is how many greetings one character can have, counting the
+// first message.
+const maxGreetings = 25
+
+var (
+    ErrGreetingNotFound = errors.New("greeting not found")
+    ErrGreetingEmpty    = errors.New("greetings cannot be empty")
+    ErrGreetingsFull    = fmt.Errorf("characters are limited to %d greetings", maxGreetings)
+    ErrChatStarted      = errors.New("the chat has already started, use /new-chat to pick another greeting")
+)
+
+// Greetings returns the character's first message followed by its
+// alternate greetings, skipping an empty first message.
+func (c Character) Greetings() []string {
+    var greetings []string
+    if c.FirstMessage != "" {
+        greetings = append(greetings, c.FirstMessage)
+    }
+    return append(greetings, c.AlternateGreetings...)
+}
+
+// Greetings returns the greetings of the user's active character.
+func (pm *PromptManager) Greetings(userID string) []string {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    return pm.getOrCreatePrompts(userID).Greetings()
+}
+
+// AddGreeting adds an alternate greeting to the user's active character
+// and returns its 1-based number among Greetings.
+func (pm *PromptManager) AddGreeting(userID, greeting string) (int, error) {
+    if strings.TrimSpace(greeting) == "" {
+        return 0, ErrGreetingEmpty
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if len(prompts.Greetings()) >= maxGreetings {
+        return 0, ErrGreetingsFull
+    }
+    prompts.AlternateGreetings = append(prompts.AlternateGreetings, greeting)
+    pm.persist(userID)
+    return len(prompts.Greetings()), nil
+}
+
+// RemoveGreeting deletes greeting n (1-based) of the user's active
+// character. Removing the first message promotes the next greeting.
+func (pm *PromptManager) RemoveGreeting(userID string, n int) (string, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    greetings := prompts.Greetings()
+    if n < 1 || n > len(greetings) {
+        return "", ErrGreetingNotFound
+    }
+
+    removed := greetings[n-1]
+    greetings = append(greetings[:n-1], greetings[n:]...)
+    prompts.FirstMessage = ""
+    prompts.AlternateGreetings = nil
+    if len(greetings) > 0 {
+        prompts.FirstMessage = greetings[0]
+        prompts.AlternateGreetings = greetings[1:]
+    }
+    pm.persist(userID)
+    return removed, nil
+}
+
+// greetingMessage returns greeting index (0-based) of the user's active
+// character with macros expanded. ok is false when there is no such
+// greeting.
+func (pm *PromptManager) greetingMessage(userID string, index int) (Message, bool) {
+    pm.mu.Lock()
+    prompts := pm.getOrCreatePrompts(userID).clone()
+    ctx := pm.macroContext(userID, prompts)
+    pm.mu.Unlock()
+
+    greetings := prompts.Greetings()
+    if index < 0 || index >= len(greetings) {
+        return Message{}, false
+    }
+    return Message{
+        ID:        GenerateID(),
+        Role:      "assistant",
+        Content:   ExpandMacros(greetings[index], ctx),
+        Timestamp: time.Now(),
+    }, true
+}
+
+// SwapGreeting replaces the greeting that opens the user's chat with
+// greeting index (0-based) and returns its text. It only works until the
+// user has replied.
+func (cm *ChatManager) SwapGreeting(userID string, index int) (string, error) {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    session := cm.getOrCreateSession(userID)
+    last := len(session.Messages) - 1
+    if last < 0 || session.Messages[last].Role != "assistant" {
+        return "", ErrChatStarted
+    }
+    for _, msg := range session.Messages {
+        if msg.Role == "user" {
+            return "", ErrChatStarted
+        }
+    }
+
+    greeting, ok := cm.promptManager.greetingMessage(userID, index)
+    if !ok {
+        return "", ErrGreetingNotFound
+    }
+    session.Messages[last] = greeting
+    session.LastActivity = time.Now()
+    cm.commit(userID, JournalEntry{Op: JournalClear, Messages: session.Messages})
+    return greeting.Content, nil
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Turn orders of a group chat.
+const (
+    // TurnRoundRobin lets the members reply one after another.
+    TurnRoundRobin = "round-robin"
+    // TurnMention lets the member named in the user's message reply, and
+    // falls back to round-robin when nobody is named.
+    TurnMention = "mention"
+    // TurnManual waits for the user to pick who replies with /next.
+    TurnManual = "manual"
+)
+
+const maxGroupMembers = 10
+
+var (
+    ErrGroupSize  = fmt.Errorf("group chats need 2 to %d characters", maxGroupMembers)
+    ErrTurnOrder  = errors.New("turn order must be round-robin, mention or manual")
+    ErrNoGroup    = errors.New("no group chat is running, start one with /group start")
+    ErrNotInGroup = errors.New("that character is not in the group")
+    ErrInGroup    = errors.New("that character is already in the group")
+    ErrManualTurn = errors.New("the group takes turns manually, pick who replies with /next")
+)
+
+// GroupChat is a chat several of the user's characters take part in.
+type GroupChat struct {
+    // Members are the IDs of the characters taking part, in turn order.
+    Members   []string
+    TurnOrder string
+    // Turn is the member that replies next in round-robin order.
+    Turn      int
+}
+
+// Active reports whether a group chat is running.
+func (g GroupChat) Active() bool {
+    return len(g.Members) > 1
+}
+
+func validTurnOrder(order string) bool {
+    return order == TurnRoundRobin || order == TurnMention || order == TurnManual
+}
+
+// StartGroup makes the named characters of the user's library a group
+// chat, replacing any running one. Call ChatManager.CreateNewChat after it
+// so the chat opens with every member's definitions.
+func (pm *PromptManager) StartGroup(userID string, names []string, turnOrder string) ([]Character, error) {
+    if turnOrder == "" {
+        turnOrder = TurnRoundRobin
+    }
+    if !validTurnOrder(turnOrder) {
+        return nil, ErrTurnOrder
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    var members []string
+    var characters []Character
+    for _, name := range names {
+        character, err := prompts.characterByName(name)
+        if err != nil {
+            return nil, err
+        }
+        if containsString(members, character.ID) {
+            continue
+        }
+        members = append(members, character.ID)
+        member, _ := prompts.characterByID(character.ID)
+        characters = append(characters, member)
+    }
+    if len(members) < 2 || len(members) > maxGroupMembers {
+        return nil, ErrGroupSize
+    }
+
+    prompts.Group = GroupChat{Members: members, TurnOrder: turnOrder}
+    pm.persist(userID)
+    return characters, nil
+}
+
+// AddGroupMember adds a character to the user's running group chat.
+func (pm *PromptManager) AddGroupMember(userID, name string) (Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if !prompts.Group.Active() {
+        return Character{}, ErrNoGroup
+    }
+    character, err := prompts.characterByName(name)
+    if err != nil {
+        return Character{}, err
+    }
+    if containsString(prompts.Group.Members, character.ID) {
+        return Character{}, ErrInGroup
+    }
+    if len(prompts.Group.Members) >= maxGroupMembers {
+        return Character{}, ErrGroupSize
+    }
+    prompts.Group.Members = append(prompts.Group.Members, character.ID)
+    pm.persist(userID)
+    return prompts.characterByID(character.ID)
+}
+
+// RemoveGroupMember takes a character out of the user's running group
+// chat. Groups cannot shrink below two members; end them instead.
+func (pm *PromptManager) RemoveGroupMember(userID, name string) (Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if !prompts.Group.Active() {
+        return Character{}, ErrNoGroup
+    }
+    member := prompts.groupMember(name)
+    if member < 0 {
+        return Character{}, ErrNotInGroup
+    }
+    if len(prompts.Group.Members) <= 2 {
+        return Character{}, ErrGroupSize
+    }
+    character, _ := prompts.characterByID(prompts.Group.Members[member])
+    prompts.leaveGroup(character.ID)
+    pm.persist(userID)
+    return character, nil
+}
+
+// SetTurnOrder changes how the user's running group chat takes turns.
+func (pm *PromptManager) SetTurnOrder(userID, turnOrder string) error {
+    if !validTurnOrder(turnOrder) {
+        return ErrTurnOrder
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if !prompts.Group.Active() {
+        return ErrNoGroup
+    }
+    prompts.Group.TurnOrder = turnOrder
+    pm.persist(userID)
+    return nil
+}
+
+// EndGroup ends the user's group chat. It reports whether one was running.
+func (pm *PromptManager) EndGroup(userID string) bool {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if !prompts.Group.Active() {
+        return false
+    }
+    prompts.Group = GroupChat{}
+    pm.persist(userID)
+    return true
+}
+
+// Group returns the user's group chat and its members in turn order.
+// Members whose character was deleted are left out.
+func (pm *PromptManager) Group(userID string) (GroupChat, []Character) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    group := prompts.Group
+    group.Members = append([]string(nil), group.Members...)
+    return group, prompts.groupMembers()
+}
+
+// NextSpeaker picks the group member that replies next: the named one, or
+// the next one by the group's turn order given the user's latest message.
+func (pm *PromptManager) NextSpeaker(userID, name, lastMessage string) (Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    member, err := prompts.speaker(name, lastMessage)
+    if err != nil {
+        return Character{}, err
+    }
+    character, err := prompts.characterByID(prompts.Group.Members[member])
+    if err != nil {
+        return Character{}, err
+    }
+    prompts.Group.Turn = (member + 1) % len(prompts.Group.Members)
+    pm.persist(userID)
+    return character, nil
+}
+
+// UpcomingSpeaker is NextSpeaker without taking the turn.
+func (pm *PromptManager) UpcomingSpeaker(userID, name, lastMessage string) (Character, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    member, err := prompts.speaker(name, lastMessage)
+    if err != nil {
+        return Character{}, err
+    }
+    return prompts.characterByID(prompts.Group.Members[member])
+}
+
+// PrepareGroupContext is PrepareContext for a reply by speaker in a group
+// chat. Macros, example dialogue and post-history instructions follow the
+// speaker, earlier replies are prefixed with the name of the member who
+// wrote them, and the model is told whose turn it is.
+func (pm *PromptManager) PrepareGroupContext(userID string, history []Message, speaker Character) []Message {
+    attributed := make([]Message, len(history))
+    for i, msg := range history {
+        attributed[i] = msg
+        if msg.Role == "assistant" && msg.Speaker != "" {
+            attributed[i].Content = msg.Speaker + ": " + msg.Content
+        }
+    }
+
+    messages := pm.prepareContext(userID, attributed, &speaker)
+    nudge := Message{
+        Role:    "system",
+        Content: fmt.Sprintf("[Write the next reply only as %s.]", speaker.Name),
+        Section: SectionGroupTurn,
+    }
+    return insertMessage(messages, postHistoryIndex(messages), nudge)
+}
+
+// speaker returns the index in Group.Members of the member that replies
+// next: the named one, or the next one by the group's turn order given the
+// user's latest message.
+func (p *UserPrompts) speaker(name, lastMessage string) (int, error) {
+    group := p.Group
+    if !group.Active() {
+        return -1, ErrNoGroup
+    }
+
+    member := -1
+    switch {
+    case name != "":
+        if member = p.groupMember(name); member < 0 {
+            return -1, ErrNotInGroup
+        }
+    case group.TurnOrder == TurnManual:
+        return -1, ErrManualTurn
+    case group.TurnOrder == TurnMention:
+        member = p.mentionedMember(lastMessage)
+    }
+    if member < 0 {
+        member = group.Turn % len(group.Members)
+    }
+    return member, nil
+}
+
+// groupStack renders one definitions block per group member, in place of
+// the active character's name, description and personality blocks. Only
+// the blocks enabled in the stack are included.
+func (p *UserPrompts) groupStack(ctx MacroContext) []Message {
+    var messages []Message
+    for _, member := range p.groupMembers() {
+        ctx.Char = member.Name
+        var lines []string
+        for _, block := range []string{BlockName, BlockDescription, BlockPersonality} {
+            content := member.blockContent(block)
+            if content == "" || !p.blockEnabled(block) {
+                continue
+            }
+            if block != BlockName {
+                content = ExpandMacros(content, ctx)
+            }
+            lines = append(lines, blockLabels[block]+content)
+        }
+        if len(lines) > 0 {
+            messages = append(messages, Message{Role: "system", Content: strings.Join(lines, "\n")})
+        }
+    }
+    return messages
+}
+
+// leaveGroup takes a character out of the group, ending the group when
+// fewer than two members are left.
+func (p *UserPrompts) leaveGroup(id string) {
+    group := &p.Group
+    for i, member := range group.Members {
+        if member != id {
+            continue
+        }
+        group.Members = append(group.Members[:i], group.Members[i+1:]...)
+        if !group.Active() {
+            p.Group = GroupChat{}
+            return
+        }
+        if group.Turn > i {
+            group.Turn--
+        }
+        group.Turn %= len(group.Members)
+        return
+    }
+}
+
+// groupMembers returns the characters of the group in turn order.
+func (p *UserPrompts) groupMembers() []Character {
+    var members []Character
+    for _, id := range p.Group.Members {
+        if character, err := p.characterByID(id); err == nil {
+            members = append(members, character)
+        }
+    }
+    return members
+}
+
+// groupMember returns the index in Group.Members of the named member, or
+// -1.
+func (p *UserPrompts) groupMember(name string) int {
+    for i, id := range p.Group.Members {
+        character, err := p.characterByID(id)
+        if err == nil && strings.EqualFold(character.Name, strings.TrimSpace(name)) {
+            return i
+        }
+    }
+    return -1
+}
+
+// mentionedMember returns the index of the member named first in text, or
+// -1 when nobody is named.
+func (p *UserPrompts) mentionedMember(text string) int {
+    member, first := -1, len(text)
+    for i, id := range p.Group.Members {
+        character, err := p.characterByID(id)
+        if err != nil || character.Name == "" {
+            continue
+        }
+        pattern := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(character.Name) + `\b`)
+        if loc := pattern.FindStringIndex(text); loc != nil && loc[0] < first {
+            member, first = i, loc[0]
+        }
+    }
+    return member
+}
+
+// characterByName finds a character of the user's library, active or
+// not, by name. The active character is given an ID if it has none, so
+// the group can refer to it.
+func (p *UserPrompts) characterByName(name string) (*Character, error) {
+    name = strings.TrimSpace(name)
+    if index := p.findCharacter(name); index >= 0 {
+        return &p.Characters[index], nil
+    }
+    if strings.EqualFold(p.Name, name) || (p.Name == "" && strings.EqualFold(name, defaultCharacterName)) {
+        if p.ID == "" {
+            p.ID = GenerateID()
+        }
+        return &p.Character, nil
+    }
+    return nil, ErrCharacterNotFound
+}
+
+// characterByID finds a character of the user's library, active or not.
+func (p *UserPrompts) characterByID(id string) (Character, error) {
+    if p.ID == id {
+        character := p.Character
+        if character.Name == "" {
+            character.Name = defaultCharacterName
+        }
+        return character, nil
+    }
+    for _, character := range p.Characters {
+        if character.ID == id {
+            return character, nil
+        }
+    }
+    return Character{}, ErrCharacterNotFound
+}
+
+func containsString(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+    "strings"
+    "testing"
+)
+
+// newGroup returns a prompt manager whose user "u" runs a group chat of
+// Alice, Bob and Carol in that order.
+func newGroup(t *testing.T, turnOrder string) *PromptManager {
+    t.Helper()
+    pm := NewPromptManager(nil)
+    for _, name := range []string{"Alice", "Bob", "Carol"} {
+        if _, _, err := pm.CreateCharacter("u", name); err != nil {
+            t.Fatalf("CreateCharacter(%q): %v", name, err)
+        }
+    }
+    if _, err := pm.StartGroup("u", []string{"Alice", "Bob", "Carol"}, turnOrder); err != nil {
+        t.Fatalf("StartGroup: %v", err)
+    }
+    return pm
+}
+
+func TestNextSpeaker(t *testing.T) {
+    type turn struct {
+        name    string
+        message string
+        want    string
+        wantErr error
+    }
+    tests := []struct {
+        name      string
+        turnOrder string
+        turns     []turn
+    }{
+        {
+            name:      "round-robin",
+            turnOrder: TurnRoundRobin,
+            turns: []turn{
+                {message: "hi", want: "Alice"},
+                {message: "Carol, you there?", want: "Bob"},
+                {message: "hm", want: "Carol"},
+                {message: "again", want: "Alice"},
+            },
+        },
+        {
+            name:      "named member, then the one after them",
+            turnOrder: TurnRoundRobin,
+            turns: []turn{
+                {name: "carol", message: "hi", want: "Carol"},
+                {message: "hi", want: "Alice"},
+                {name: "Dave", message: "hi", wantErr: ErrNotInGroup},
+            },
+        },
+        {
+            name:      "mention",
+            turnOrder: TurnMention,
+            turns: []turn{
+                {message: "What do you think, bob?", want: "Bob"},
+                {message: "Carol and Alice, your turn", want: "Carol"},
+                {message: "nobody named", want: "Alice"},
+                {message: "Bobby is not Bob", want: "Bob"},
+            },
+        },
+        {
+            name:      "manual",
+            turnOrder: TurnManual,
+            turns: []turn{
+                {message: "hi Alice", wantErr: ErrManualTurn},
+                {name: "Bob", message: "hi", want: "Bob"},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pm := newGroup(t, tt.turnOrder)
+            for i, turn := range tt.turns {
+                upcoming, upcomingErr := pm.UpcomingSpeaker("u", turn.name, turn.message)
+                speaker, err := pm.NextSpeaker("u", turn.name, turn.message)
+                if err != turn.wantErr || upcomingErr != turn.wantErr {
+                    t.Fatalf("turn %d: error = %v (upcoming %v), want %v", i, err, upcomingErr, turn.wantErr)
+                }
+                if err != nil {
+                    continue
+                }
+                if speaker.Name != turn.want {
+                    t.Errorf("turn %d: speaker = %q, want %q", i, speaker.Name, turn.want)
+                }
+                if upcoming.Name != speaker.Name {
+                    t.Errorf("turn %d: UpcomingSpeaker = %q, NextSpeaker = %q", i, upcoming.Name, speaker.Name)
+                }
+            }
+        })
+    }
+}
+
+func TestMentionedMember(t *testing.T) {
+    pm := newGroup(t, TurnMention)
+    pm.mu.Lock()
+    prompts := pm.getOrCreatePrompts("u")
+    pm.mu.Unlock()
+
+    tests := []struct {
+        text string
+        want int
+    }{
+        {"Alice?", 0},
+        {"hey BOB", 1},
+        {"carol, then alice", 2},
+        {"alice, then carol", 0},
+        {"Bobcat", -1},
+        {"Caroline", -1},
+        {"", -1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.text, func(t *testing.T) {
+            if got := prompts.mentionedMember(tt.text); got != tt.want {
+                t.Errorf("mentionedMember(%q) = %d, want %d", tt.text, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLeaveGroup(t *testing.T) {
+    tests := []struct {
+        name     string
+        leaving  string
+        turn     int
+        wantTurn string
+    }{
+        {"member before the turn", "Alice", 1, "Bob"},
+        {"member holding the turn", "Carol", 2, "Alice"},
+        {"member after the turn", "Carol", 0, "Alice"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pm := newGroup(t, TurnRoundRobin)
+            pm.mu.Lock()
+            pm.getOrCreatePrompts("u").Group.Turn = tt.turn
+            pm.mu.Unlock()
+
+            if _, err := pm.RemoveGroupMember("u", tt.leaving); err != nil {
+                t.Fatalf("RemoveGroupMember: %v", err)
+            }
+            if group, _ := pm.Group("u"); !group.Active() {
+                t.Fatal("group ended with two members left")
+            }
+            speaker, err := pm.UpcomingSpeaker("u", "", "")
+            if err != nil {
+                t.Fatalf("UpcomingSpeaker: %v", err)
+            }
+            if speaker.Name != tt.wantTurn {
+                t.Errorf("next speaker = %q, want %q", speaker.Name, tt.wantTurn)
+            }
+        })
+    }
+
+    t.Run("group ends when a member of two is deleted", func(t *testing.T) {
+        pm := newGroup(t, TurnRoundRobin)
+        if _, err := pm.RemoveGroupMember("u", "Alice"); err != nil {
+            t.Fatalf("RemoveGroupMember: %v", err)
+        }
+        if _, err := pm.RemoveGroupMember("u", "Bob"); err != ErrGroupSize {
+            t.Fatalf("removing one of two members: error = %v, want %v", err, ErrGroupSize)
+        }
+        if _, err := pm.DeleteCharacter("u", "Bob"); err != nil {
+            t.Fatalf("DeleteCharacter: %v", err)
+        }
+        if group, _ := pm.Group("u"); group.Active() {
+            t.Errorf("group still running with %v", group.Members)
+        }
+    })
+}
+
+func TestGroupStackHonorsDisabledBlocks(t *testing.T) {
+    tests := []struct {
+        name     string
+        disabled string
+        want     []string
+        notWant  []string
+    }{
+        {"everything enabled", "", []string{"Character Name: Alice", "Character Description: A witch"}, nil},
+        {"description disabled", BlockDescription, []string{"Character Name: Alice"}, []string{"Character Description: A witch"}},
+        {"name disabled", BlockName, nil, []string{"Character Name: Alice", "Character Description: A witch"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pm := newGroup(t, TurnRoundRobin)
+            pm.UpdateDefinitions("u", map[string]string{"description": "A witch"})
+            if tt.disabled != "" {
+                enabled := false
+                if _, err := pm.EditPrompt("u", tt.disabled, nil, nil, &enabled); err != nil {
+                    t.Fatalf("EditPrompt: %v", err)
+                }
+            }
+
+            var sb strings.Builder
+            for _, msg := range pm.BuildPromptList("u") {
+                sb.WriteString(msg.Content + "\n")
+            }
+            sent := sb.String()
+            for _, want := range tt.want {
+                if !strings.Contains(sent, want) {
+                    t.Errorf("stack %q is missing %q", sent, want)
+                }
+            }
+            for _, notWant := range tt.notWant {
+                if strings.Contains(sent, notWant) {
+                    t.Errorf("stack %q contains %q", sent, notWant)
+                }
+            }
+        })
+    }
+}
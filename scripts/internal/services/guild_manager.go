@@ -0,0 +1,357 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/templates"
+)
+
+// maxScenarioName keeps preset names usable as autocomplete choices.
+const maxScenarioName = 64
+
+var (
+    ErrScenarioNotFound = errors.New("scenario preset not found")
+    ErrScenarioBuiltin  = errors.New("built-in scenario presets cannot be changed")
+    ErrScenarioName     = fmt.Errorf("preset names must be 1 to %d characters long", maxScenarioName)
+    ErrScenarioEmpty    = errors.New("the scenario text cannot be empty")
+)
+
+// Kinds of guild post-history instructions. The default is used for
+// characters that have none of their own; the override replaces them.
+const (
+    PostHistoryDefault  = "default"
+    PostHistoryOverride = "override"
+)
+
+var ErrPostHistoryKind = errors.New("post-history instructions must be the default or the override")
+
+const maxSharedCharacters = 100
+
+var (
+    ErrSharedNotFound = errors.New("shared character not found")
+    ErrSharedFull     = fmt.Errorf("servers can share up to %d characters", maxSharedCharacters)
+)
+
+// SharedCharacter is a character published in a guild for every member to
+// use.
+type SharedCharacter struct {
+    Character   Character `json:"character"`
+    Version     int       `json:"version"`
+    AuthorID    string    `json:"author_id"`
+    AuthorName  string    `json:"author_name"`
+    PublishedAt time.Time `json:"published_at"`
+}
+
+// Origin identifies the shared character in the copies users make of it.
+func (c SharedCharacter) Origin(guildID string) string {
+    return guildID + "/" + strings.ToLower(c.Character.Name)
+}
+
+// ScenarioPreset is a named scenario users can apply with /scenario.
+type ScenarioPreset struct {
+    Name      string    `json:"name"`
+    Scenario  string    `json:"scenario"`
+    AddedBy   string    `json:"added_by,omitempty"`
+    UpdatedAt time.Time `json:"updated_at"`
+    Builtin   bool      `json:"-"`
+}
+
+// guildState is what is kept for one guild.
+type guildState struct {
+    Scenarios map[string]*ScenarioPreset `json:"scenarios,omitempty"`
+    PostHistoryDefault  string `json:"post_history_default,omitempty"`
+    PostHistoryOverride string `json:"post_history_override,omitempty"`
+    Characters map[string]*SharedCharacter `json:"characters,omitempty"`
+}
+
+// GuildManager keeps settings that guild admins share with their members.
+type GuildManager struct {
+    path   string
+    guilds map[string]*guildState
+    mu     sync.Mutex
+}
+
+// NewGuildManager loads the guild settings kept at path.
+func NewGuildManager(path string) (*GuildManager, error) {
+    manager := &GuildManager{path: path}
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, fmt.Errorf("error creating guild directory: %v", err)
+    }
+    if err := readJSONFile(path, &manager.guilds); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("error reading guild settings: %v", err)
+    }
+    if manager.guilds == nil {
+        manager.guilds = make(map[string]*guildState)
+    }
+    return manager, nil
+}
+
+// builtinScenarios returns the presets that ship with the bot.
+func builtinScenarios() []ScenarioPreset {
+    var presets []ScenarioPreset
+    for name, scenario := range templates.GetDefaultPrompts().Scenarios {
+        presets = append(presets, ScenarioPreset{Name: name, Scenario: scenario, Builtin: true})
+    }
+    return presets
+}
+
+// Scenarios returns the built-in presets and those added in guildID, sorted
+// by name.
+func (m *GuildManager) Scenarios(guildID string) []ScenarioPreset {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    presets := builtinScenarios()
+    if guild, exists := m.guilds[guildID]; exists {
+        for _, preset := range guild.Scenarios {
+            presets = append(presets, *preset)
+        }
+    }
+    sort.Slice(presets, func(i, j int) bool {
+        return strings.ToLower(presets[i].Name) < strings.ToLower(presets[j].Name)
+    })
+    return presets
+}
+
+// Scenario finds a preset by name, ignoring case.
+func (m *GuildManager) Scenario(guildID, name string) (ScenarioPreset, error) {
+    name = strings.TrimSpace(name)
+    for _, preset := range m.Scenarios(guildID) {
+        if strings.EqualFold(preset.Name, name) {
+            return preset, nil
+        }
+    }
+    return ScenarioPreset{}, ErrScenarioNotFound
+}
+
+// SetScenario adds or replaces a preset in guildID.
+func (m *GuildManager) SetScenario(guildID, name, scenario, addedBy string) (ScenarioPreset, error) {
+    name = strings.TrimSpace(name)
+    if name == "" || utf8.RuneCountInString(name) > maxScenarioName {
+        return ScenarioPreset{}, ErrScenarioName
+    }
+    if strings.TrimSpace(scenario) == "" {
+        return ScenarioPreset{}, ErrScenarioEmpty
+    }
+    for _, preset := range builtinScenarios() {
+        if strings.EqualFold(preset.Name, name) {
+            return ScenarioPreset{}, ErrScenarioBuiltin
+        }
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    guild := m.guild(guildID)
+    key := strings.ToLower(name)
+    previous := guild.Scenarios[key]
+    preset := &ScenarioPreset{
+        Name:      name,
+        Scenario:  scenario,
+        AddedBy:   addedBy,
+        UpdatedAt: time.Now(),
+    }
+    guild.Scenarios[key] = preset
+    if err := m.save(); err != nil {
+        if previous != nil {
+            guild.Scenarios[key] = previous
+        } else {
+            delete(guild.Scenarios, key)
+        }
+        return ScenarioPreset{}, err
+    }
+    return *preset, nil
+}
+
+// RemoveScenario deletes a preset added in guildID.
+func (m *GuildManager) RemoveScenario(guildID, name string) (ScenarioPreset, error) {
+    name = strings.TrimSpace(name)
+    for _, preset := range builtinScenarios() {
+        if strings.EqualFold(preset.Name, name) {
+            return ScenarioPreset{}, ErrScenarioBuiltin
+        }
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    guild := m.guild(guildID)
+    key := strings.ToLower(name)
+    preset, exists := guild.Scenarios[key]
+    if !exists {
+        return ScenarioPreset{}, ErrScenarioNotFound
+    }
+    delete(guild.Scenarios, key)
+    if err := m.save(); err != nil {
+        guild.Scenarios[key] = preset
+        return ScenarioPreset{}, err
+    }
+    return *preset, nil
+}
+
+// PostHistoryInstructions returns the default and override post-history
+// instructions of guildID. Either may be empty.
+func (m *GuildManager) PostHistoryInstructions(guildID string) (string, string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    guild, exists := m.guilds[guildID]
+    if !exists {
+        return "", ""
+    }
+    return guild.PostHistoryDefault, guild.PostHistoryOverride
+}
+
+// SetPostHistoryInstructions sets the default or the override post-history
+// instructions of guildID. Empty instructions clear them.
+func (m *GuildManager) SetPostHistoryInstructions(guildID, kind, instructions string) error {
+    if kind != PostHistoryDefault && kind != PostHistoryOverride {
+        return ErrPostHistoryKind
+    }
+    if strings.TrimSpace(instructions) == "" {
+        instructions = ""
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    guild := m.guild(guildID)
+    target := &guild.PostHistoryDefault
+    if kind == PostHistoryOverride {
+        target = &guild.PostHistoryOverride
+    }
+    previous := *target
+    *target = instructions
+    if err := m.save(); err != nil {
+        *target = previous
+        return err
+    }
+    return nil
+}
+
+// SharedCharacters returns the characters published in guildID, sorted by
+// name.
+func (m *GuildManager) SharedCharacters(guildID string) []SharedCharacter {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var shared []SharedCharacter
+    if guild, exists := m.guilds[guildID]; exists {
+        for _, character := range guild.Characters {
+            shared = append(shared, *character)
+        }
+    }
+    sort.Slice(shared, func(i, j int) bool {
+        return strings.ToLower(shared[i].Character.Name) < strings.ToLower(shared[j].Character.Name)
+    })
+    return shared
+}
+
+// SharedCharacter finds a character published in guildID by name,
+// ignoring case.
+func (m *GuildManager) SharedCharacter(guildID, name string) (SharedCharacter, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if guild, exists := m.guilds[guildID]; exists {
+        if character, exists := guild.Characters[strings.ToLower(strings.TrimSpace(name))]; exists {
+            return *character, nil
+        }
+    }
+    return SharedCharacter{}, ErrSharedNotFound
+}
+
+// PublishCharacter shares character in guildID under its name, crediting
+// the author. Publishing a name again replaces it with the next version.
+func (m *GuildManager) PublishCharacter(guildID string, character Character, authorID, authorName string) (SharedCharacter, error) {
+    if err := validCharacterName(character.Name); err != nil {
+        return SharedCharacter{}, err
+    }
+    // IDs and origins belong to the publisher's own library
+    character.ID = ""
+    character.SharedFrom = ""
+    character.SharedVersion = 0
+    character.AlternateGreetings = append([]string(nil), character.AlternateGreetings...)
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    guild := m.guild(guildID)
+    key := strings.ToLower(character.Name)
+    previous, exists := guild.Characters[key]
+    if !exists && len(guild.Characters) >= maxSharedCharacters {
+        return SharedCharacter{}, ErrSharedFull
+    }
+    shared := &SharedCharacter{
+        Character:   character,
+        Version:     1,
+        AuthorID:    authorID,
+        AuthorName:  authorName,
+        PublishedAt: time.Now(),
+    }
+    if exists {
+        shared.Version = previous.Version + 1
+    }
+    guild.Characters[key] = shared
+    if err := m.save(); err != nil {
+        if exists {
+            guild.Characters[key] = previous
+        } else {
+            delete(guild.Characters, key)
+        }
+        return SharedCharacter{}, err
+    }
+    return *shared, nil
+}
+
+// UnpublishCharacter removes a shared character from guildID. Copies users
+// already made are kept.
+func (m *GuildManager) UnpublishCharacter(guildID, name string) (SharedCharacter, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    guild := m.guild(guildID)
+    key := strings.ToLower(strings.TrimSpace(name))
+    shared, exists := guild.Characters[key]
+    if !exists {
+        return SharedCharacter{}, ErrSharedNotFound
+    }
+    delete(guild.Characters, key)
+    if err := m.save(); err != nil {
+        guild.Characters[key] = shared
+        return SharedCharacter{}, err
+    }
+    return *shared, nil
+}
+
+// guild returns the state of guildID, creating it. Callers must hold m.mu.
+func (m *GuildManager) guild(guildID string) *guildState {
+    guild, exists := m.guilds[guildID]
+    if !exists {
+        guild = &guildState{}
+        m.guilds[guildID] = guild
+    }
+    if guild.Scenarios == nil {
+        guild.Scenarios = make(map[string]*ScenarioPreset)
+    }
+    if guild.Characters == nil {
+        guild.Characters = make(map[string]*SharedCharacter)
+    }
+    return guild
+}
+
+// save writes the guild settings to disk. Callers must hold m.mu.
+func (m *GuildManager) save() error {
+    if err := writeJSONFile(m.path, m.guilds); err != nil {
+        return fmt.Errorf("error writing guild settings: %v", err)
+    }
+    return nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// InstructTemplate renders chat messages into a single prompt for
+// text-completion backends.
+type InstructTemplate struct {
+    Name string `json:"name"`
+    // Prefix starts the prompt, usually the model's BOS token.
+    Prefix          string `json:"prefix"`
+    SystemPrefix    string `json:"system_prefix"`
+    SystemSuffix    string `json:"system_suffix"`
+    UserPrefix      string `json:"user_prefix"`
+    UserSuffix      string `json:"user_suffix"`
+    AssistantPrefix string `json:"assistant_prefix"`
+    AssistantSuffix string `json:"assistant_suffix"`
+    // Stop lists stop sequences on top of the ones derived from the
+    // template.
+    Stop []string `json:"stop,omitempty"`
+}
+
+// builtinTemplates are the instruct templates available without
+// configuration, by lowercase name.
+var builtinTemplates = map[string]InstructTemplate{
+    "chatml": {
+        Name:            "ChatML",
+        SystemPrefix:    "<|im_start|>system\n",
+        SystemSuffix:    "<|im_end|>\n",
+        UserPrefix:      "<|im_start|>user\n",
+        UserSuffix:      "<|im_end|>\n",
+        AssistantPrefix: "<|im_start|>assistant\n",
+        AssistantSuffix: "<|im_end|>\n",
+    },
+    "alpaca": {
+        Name:            "Alpaca",
+        SystemSuffix:    "\n\n",
+        UserPrefix:      "### Instruction:\n",
+        UserSuffix:      "\n\n",
+        AssistantPrefix: "### Response:\n",
+        AssistantSuffix: "\n\n",
+    },
+    "llama-3": {
+        Name:            "Llama-3",
+        Prefix:          "<|begin_of_text|>",
+        SystemPrefix:    "<|start_header_id|>system<|end_header_id|>\n\n",
+        SystemSuffix:    "<|eot_id|>",
+        UserPrefix:      "<|start_header_id|>user<|end_header_id|>\n\n",
+        UserSuffix:      "<|eot_id|>",
+        AssistantPrefix: "<|start_header_id|>assistant<|end_header_id|>\n\n",
+        AssistantSuffix: "<|eot_id|>",
+    },
+    // Mistral has no system role, system prompts are sent as instructions
+    "mistral": {
+        Name:            "Mistral",
+        Prefix:          "<s>",
+        SystemPrefix:    "[INST] ",
+        SystemSuffix:    " [/INST]",
+        UserPrefix:      "[INST] ",
+        UserSuffix:      " [/INST]",
+        AssistantSuffix: "</s>",
+    },
+    "vicuna": {
+        Name:            "Vicuna",
+        SystemSuffix:    "\n\n",
+        UserPrefix:      "USER: ",
+        UserSuffix:      "\n",
+        AssistantPrefix: "ASSISTANT: ",
+        AssistantSuffix: "</s>\n",
+    },
+}
+
+// LoadInstructTemplates returns the built-in instruct templates together
+// with the custom ones in the JSON file at path, a list of
+// InstructTemplate, by lowercase name. Custom templates replace built-in
+// ones of the same name. An empty path loads the built-in ones only.
+func LoadInstructTemplates(path string) (map[string]InstructTemplate, error) {
+    templates := make(map[string]InstructTemplate, len(builtinTemplates))
+    for name, template := range builtinTemplates {
+        templates[name] = template
+    }
+    if path == "" {
+        return templates, nil
+    }
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("error reading instruct templates: %v", err)
+    }
+    var custom []InstructTemplate
+    if err := json.Unmarshal(data, &custom); err != nil {
+        return nil, fmt.Errorf("error decoding instruct templates: %v", err)
+    }
+    for _, template := range custom {
+        if strings.TrimSpace(template.Name) == "" {
+            return nil, errors.New("error decoding instruct templates: every template needs a name")
+        }
+        templates[strings.ToLower(strings.TrimSpace(template.Name))] = template
+    }
+    return templates, nil
+}
+
+// Render turns messages into a prompt that ends where the model's reply
+// starts. When the last message is the assistant's, as when continuing a
+// reply, it is left open for the model to carry on.
+func (t InstructTemplate) Render(messages []Message) string {
+    var sb strings.Builder
+    sb.WriteString(t.Prefix)
+    for i, msg := range messages {
+        prefix, suffix := t.SystemPrefix, t.SystemSuffix
+        switch msg.Role {
+        case "user":
+            prefix, suffix = t.UserPrefix, t.UserSuffix
+        case "assistant":
+            prefix, suffix = t.AssistantPrefix, t.AssistantSuffix
+        }
+        sb.WriteString(prefix)
+        sb.WriteString(msg.Content)
+        if i == len(messages)-1 && msg.Role == "assistant" {
+            return sb.String()
+        }
+        sb.WriteString(suffix)
+    }
+    sb.WriteString(t.AssistantPrefix)
+    return sb.String()
+}
+
+// StopSequences returns where the model's reply ends: at the end of its
+// turn or at the start of the user's next one, plus the template's own
+// stop sequences.
+func (t InstructTemplate) StopSequences() []string {
+    var stops []string
+    for _, stop := range append([]string{t.AssistantSuffix, t.UserPrefix}, t.Stop...) {
+        stop = strings.TrimSpace(stop)
+        if stop != "" && !containsString(stops, stop) {
+            stops = append(stops, stop)
+        }
+    }
+    return stops
+}
+
+// completionsURL guesses the text completion endpoint next to a chat
+// completions one.
+func completionsURL(chatURL string) string {
+    if strings.HasSuffix(chatURL, "/chat/completions") {
+        return strings.TrimSuffix(chatURL, "/chat/completions") + "/completions"
+    }
+    return chatURL
+}
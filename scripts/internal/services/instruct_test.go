@@ -0,0 +1,161 @@
+package services
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestInstructTemplateRender(t *testing.T) {
+    chatml := builtinTemplates["chatml"]
+    mistral := builtinTemplates["mistral"]
+    tests := []struct {
+        name     string
+        template InstructTemplate
+        messages []Message
+        want     string
+    }{
+        {
+            name:     "chatml ends with the assistant's turn",
+            template: chatml,
+            messages: []Message{
+                {Role: "system", Content: "Be nice"},
+                {Role: "user", Content: "Hi"},
+            },
+            want: "<|im_start|>system\nBe nice<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\n",
+        },
+        {
+            name:     "a trailing assistant message is left open",
+            template: chatml,
+            messages: []Message{
+                {Role: "user", Content: "Hi"},
+                {Role: "assistant", Content: "Hello, I"},
+            },
+            want: "<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\nHello, I",
+        },
+        {
+            name:     "earlier assistant messages are closed",
+            template: chatml,
+            messages: []Message{
+                {Role: "assistant", Content: "Hello"},
+                {Role: "user", Content: "Hi"},
+            },
+            want: "<|im_start|>assistant\nHello<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\n",
+        },
+        {
+            name:     "prefix and templates without an assistant prefix",
+            template: mistral,
+            messages: []Message{
+                {Role: "system", Content: "Be nice"},
+                {Role: "user", Content: "Hi"},
+                {Role: "assistant", Content: "Hello"},
+                {Role: "user", Content: "Bye"},
+            },
+            want: "<s>[INST] Be nice [/INST][INST] Hi [/INST]Hello</s>[INST] Bye [/INST]",
+        },
+        {
+            name:     "no messages",
+            template: builtinTemplates["llama-3"],
+            want:     "<|begin_of_text|><|start_header_id|>assistant<|end_header_id|>\n\n",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.template.Render(tt.messages); got != tt.want {
+                t.Errorf("Render = %q\nwant %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestInstructTemplateStopSequences(t *testing.T) {
+    tests := []struct {
+        name     string
+        template InstructTemplate
+        want     []string
+    }{
+        {"chatml", builtinTemplates["chatml"], []string{"<|im_end|>", "<|im_start|>user"}},
+        {"alpaca", builtinTemplates["alpaca"], []string{"### Instruction:"}},
+        {"mistral", builtinTemplates["mistral"], []string{"</s>", "[INST]"}},
+        {"vicuna", builtinTemplates["vicuna"], []string{"</s>", "USER:"}},
+        {
+            name: "custom stops without duplicates",
+            template: InstructTemplate{
+                UserPrefix:      "User: ",
+                AssistantSuffix: "\n",
+                Stop:            []string{"User:", " END ", ""},
+            },
+            want: []string{"User:", "END"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.template.StopSequences(); !equalStrings(got, tt.want) {
+                t.Errorf("StopSequences = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLoadInstructTemplates(t *testing.T) {
+    tests := []struct {
+        name     string
+        file     string
+        wantErr  bool
+        wantName string
+        lookup   string
+    }{
+        {"built-in only", "", false, "ChatML", "chatml"},
+        {"custom template", `[{"name": "Pygmalion", "user_prefix": "You: "}]`, false, "Pygmalion", "pygmalion"},
+        {"custom replaces built-in", `[{"name": "ChatML", "user_prefix": "U: "}]`, false, "ChatML", "chatml"},
+        {"unnamed template", `[{"user_prefix": "You: "}]`, true, "", ""},
+        {"not JSON", `{`, true, "", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := ""
+            if tt.file != "" {
+                path = filepath.Join(t.TempDir(), "templates.json")
+                if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+                    t.Fatalf("WriteFile: %v", err)
+                }
+            }
+            templates, err := LoadInstructTemplates(path)
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatal("LoadInstructTemplates succeeded, want an error")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("LoadInstructTemplates: %v", err)
+            }
+            template, ok := templates[tt.lookup]
+            if !ok || template.Name != tt.wantName {
+                t.Errorf("templates[%q] = %+v, want %q", tt.lookup, template, tt.wantName)
+            }
+            if len(templates) < len(builtinTemplates) {
+                t.Errorf("loaded %d templates, want the %d built-in ones at least", len(templates), len(builtinTemplates))
+            }
+        })
+    }
+
+    if _, err := LoadInstructTemplates(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+        t.Error("loading a missing file succeeded")
+    }
+}
+
+func TestCompletionsURL(t *testing.T) {
+    tests := []struct {
+        url  string
+        want string
+    }{
+        {"https://proxy.example/v1/chat/completions", "https://proxy.example/v1/completions"},
+        {"https://proxy.example/generate", "https://proxy.example/generate"},
+    }
+    for _, tt := range tests {
+        if got := completionsURL(tt.url); got != tt.want {
+            t.Errorf("completionsURL(%q) = %q, want %q", tt.url, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+    "log"
+    "sync"
+    "time"
+)
+
+// SessionJanitor periodically archives idle chat sessions to storage and
+// evicts them from memory, so memory use follows active users rather than
+// every user the bot has ever seen.
+type SessionJanitor struct {
+    chatManager *ChatManager
+    timeout     time.Duration
+    interval    time.Duration
+    stop        chan struct{}
+    done        chan struct{}
+    mu          sync.Mutex
+}
+
+func NewSessionJanitor(cm *ChatManager, timeout, interval time.Duration) *SessionJanitor {
+    return &SessionJanitor{
+        chatManager: cm,
+        timeout:     timeout,
+        interval:    interval,
+    }
+}
+
+// Start runs the janitor in the background. It does nothing if the janitor
+// is already running or the timeout or interval is not positive.
+func (j *SessionJanitor) Start() {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if j.stop != nil || j.timeout <= 0 || j.interval <= 0 {
+        return
+    }
+    j.stop = make(chan struct{})
+    j.done = make(chan struct{})
+    go j.run(j.stop, j.done)
+
+    log.Printf("Session janitor started (timeout %v, every %v)", j.timeout, j.interval)
+}
+
+// Stop halts the janitor and waits for a sweep in progress to finish.
+func (j *SessionJanitor) Stop() {
+    j.mu.Lock()
+    stop, done := j.stop, j.done
+    j.stop, j.done = nil, nil
+    j.mu.Unlock()
+
+    if stop == nil {
+        return
+    }
+    close(stop)
+    <-done
+}
+
+// Sweep archives and evicts idle sessions once.
+func (j *SessionJanitor) Sweep() int {
+    evicted := j.chatManager.CleanupInactiveSessions(j.timeout)
+    if evicted > 0 {
+        log.Printf("Archived %d idle sessions", evicted)
+    }
+    return evicted
+}
+
+func (j *SessionJanitor) run(stop <-chan struct{}, done chan<- struct{}) {
+    defer close(done)
+
+    ticker := time.NewTicker(j.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            j.Sweep()
+        case <-stop:
+            return
+        }
+    }
+}
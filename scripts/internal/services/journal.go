@@ -0,0 +1,177 @@
+package services
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// Journal operations. Every change to a chat session is recorded as one of
+// these before it is considered done.
+const (
+    JournalAdd    = "add"
+    JournalUpdate = "update"
+    JournalDelete = "delete"
+    JournalUndo   = "undo"
+    JournalClear  = "clear"
+    JournalForget = "forget"
+)
+
+// JournalEntry is one line of the journal.
+type JournalEntry struct {
+    Seq       uint64    `json:"seq"`
+    Op        string    `json:"op"`
+    UserID    string    `json:"user_id"`
+    Time      time.Time `json:"time"`
+    MessageID string    `json:"message_id,omitempty"`
+    Message   *Message  `json:"message,omitempty"`
+    Messages  []Message `json:"messages,omitempty"`
+}
+
+// Journal is an append-only log of session changes. Entries are synced to
+// disk as they are written, so after a crash the sessions can be rebuilt by
+// replaying the journal on top of the last snapshot in the SessionStore.
+type Journal struct {
+    path   string
+    file   *os.File
+    seq    uint64
+    sealer Sealer
+    mu     sync.Mutex
+}
+
+// OpenJournal opens the journal at path, creating it if needed. When sealer
+// is not nil, message contents are encrypted in the journal.
+func OpenJournal(path string, sealer Sealer) (*Journal, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, fmt.Errorf("error creating journal directory: %v", err)
+    }
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+    if err != nil {
+        return nil, fmt.Errorf("error opening journal: %v", err)
+    }
+    return &Journal{path: path, file: file, sealer: sealer}, nil
+}
+
+// Append writes entry to the journal and syncs it to disk.
+func (j *Journal) Append(entry JournalEntry) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    j.seq++
+    entry.Seq = j.seq
+    if entry.Time.IsZero() {
+        entry.Time = time.Now()
+    }
+    if j.sealer != nil {
+        if err := transformEntry(&entry, j.sealer.Seal); err != nil {
+            return fmt.Errorf("error encrypting journal entry: %v", err)
+        }
+    }
+
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return fmt.Errorf("error encoding journal entry: %v", err)
+    }
+    data = append(data, '\n')
+
+    if _, err := j.file.Write(data); err != nil {
+        return fmt.Errorf("error writing journal: %v", err)
+    }
+    if err := j.file.Sync(); err != nil {
+        return fmt.Errorf("error syncing journal: %v", err)
+    }
+    return nil
+}
+
+// Replay calls apply for every entry in the journal, in order. A torn
+// final line left by a crash mid-write is dropped from the file.
+func (j *Journal) Replay(apply func(JournalEntry)) (int, error) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+        return 0, fmt.Errorf("error reading journal: %v", err)
+    }
+
+    reader := bufio.NewReader(j.file)
+    var good int64
+    count := 0
+    for {
+        line, err := reader.ReadBytes('\n')
+        if err == io.EOF {
+            if len(bytes.TrimSpace(line)) > 0 {
+                log.Printf("Dropping incomplete journal entry at offset %d", good)
+            }
+            break
+        }
+        if err != nil {
+            return count, fmt.Errorf("error reading journal: %v", err)
+        }
+
+        var entry JournalEntry
+        if err := json.Unmarshal(line, &entry); err != nil {
+            log.Printf("Dropping corrupt journal tail at offset %d: %v", good, err)
+            break
+        }
+        if j.sealer != nil {
+            if err := transformEntry(&entry, j.sealer.Open); err != nil {
+                return count, fmt.Errorf("error decrypting journal entry %d: %v", entry.Seq, err)
+            }
+        }
+        apply(entry)
+        good += int64(len(line))
+        count++
+        if entry.Seq > j.seq {
+            j.seq = entry.Seq
+        }
+    }
+
+    if err := j.file.Truncate(good); err != nil {
+        return count, fmt.Errorf("error trimming journal: %v", err)
+    }
+    return count, nil
+}
+
+// Truncate empties the journal. It is called once every session it covers
+// has been snapshotted to the store.
+func (j *Journal) Truncate() error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+
+    if err := j.file.Truncate(0); err != nil {
+        return fmt.Errorf("error truncating journal: %v", err)
+    }
+    return j.file.Sync()
+}
+
+// transformEntry applies fn to the message contents carried by entry.
+func transformEntry(entry *JournalEntry, fn func(string) (string, error)) error {
+    if entry.Message != nil {
+        messages, err := transformMessages([]Message{*entry.Message}, fn)
+        if err != nil {
+            return err
+        }
+        entry.Message = &messages[0]
+    }
+    if entry.Messages != nil {
+        messages, err := transformMessages(entry.Messages, fn)
+        if err != nil {
+            return err
+        }
+        entry.Messages = messages
+    }
+    return nil
+}
+
+func (j *Journal) Close() error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.file.Close()
+}
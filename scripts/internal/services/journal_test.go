@@ -0,0 +1,144 @@
+package services
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestJournalReplayAndCompaction(t *testing.T) {
+    msg := func(id, role, content string) *Message {
+        return &Message{ID: id, Role: role, Content: content}
+    }
+    tests := []struct {
+        name    string
+        entries []JournalEntry
+        // tail is written after the entries, as a crash mid-write would.
+        tail string
+        want []string
+    }{
+        {
+            name: "adds",
+            entries: []JournalEntry{
+                {Op: JournalAdd, UserID: "u", Message: msg("1", "user", "hi")},
+                {Op: JournalAdd, UserID: "u", Message: msg("2", "assistant", "hello")},
+            },
+            want: []string{"snapshot", "hi", "hello"},
+        },
+        {
+            name: "edit, delete and undo",
+            entries: []JournalEntry{
+                {Op: JournalAdd, UserID: "u", Message: msg("1", "user", "hi")},
+                {Op: JournalAdd, UserID: "u", Message: msg("2", "assistant", "hello")},
+                {Op: JournalAdd, UserID: "u", Message: msg("3", "user", "bye")},
+                {Op: JournalUpdate, UserID: "u", MessageID: "2", Message: msg("2", "assistant", "hey")},
+                {Op: JournalDelete, UserID: "u", MessageID: "1"},
+                {Op: JournalAdd, UserID: "u", Message: msg("4", "assistant", "wait")},
+                {Op: JournalUndo, UserID: "u"},
+            },
+            want: []string{"snapshot", "hey", "bye"},
+        },
+        {
+            name: "clear starts over",
+            entries: []JournalEntry{
+                {Op: JournalAdd, UserID: "u", Message: msg("1", "user", "hi")},
+                {Op: JournalClear, UserID: "u", Messages: []Message{*msg("s", "system", "stack")}},
+                {Op: JournalAdd, UserID: "u", Message: msg("2", "user", "again")},
+            },
+            want: []string{"stack", "again"},
+        },
+        {
+            name: "torn final line is dropped",
+            entries: []JournalEntry{
+                {Op: JournalAdd, UserID: "u", Message: msg("1", "user", "hi")},
+            },
+            tail: `{"seq":2,"op":"add","user_id":"u","mess`,
+            want: []string{"snapshot", "hi"},
+        },
+        {
+            name: "forget drops the session",
+            entries: []JournalEntry{
+                {Op: JournalAdd, UserID: "u", Message: msg("1", "user", "hi")},
+                {Op: JournalForget, UserID: "u"},
+            },
+            want: nil,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "journal.log")
+            journal, err := OpenJournal(path, nil)
+            if err != nil {
+                t.Fatalf("OpenJournal: %v", err)
+            }
+            for _, entry := range tt.entries {
+                if err := journal.Append(entry); err != nil {
+                    t.Fatalf("Append: %v", err)
+                }
+            }
+            if tt.tail != "" {
+                if _, err := journal.file.WriteString(tt.tail); err != nil {
+                    t.Fatalf("writing tail: %v", err)
+                }
+            }
+            journal.Close()
+
+            // A new manager replays the journal on top of the last snapshot
+            // and snapshots the result. Forgetting a user deletes their
+            // snapshot before the forget entry is written.
+            store := NewMemorySessionStore()
+            if tt.want != nil {
+                if err := store.Save("u", &ChatSession{Messages: []Message{*msg("0", "system", "snapshot")}}); err != nil {
+                    t.Fatalf("Save: %v", err)
+                }
+            }
+            reopened, err := OpenJournal(path, nil)
+            if err != nil {
+                t.Fatalf("reopening journal: %v", err)
+            }
+            defer reopened.Close()
+            cm := NewChatManager(nil, NewPromptManager(nil), store)
+            if err := cm.UseJournal(reopened); err != nil {
+                t.Fatalf("UseJournal: %v", err)
+            }
+
+            session, err := store.Load("u")
+            if tt.want == nil {
+                if err == nil {
+                    t.Errorf("forgotten session was stored: %+v", session)
+                }
+            } else {
+                if err != nil {
+                    t.Fatalf("Load: %v", err)
+                }
+                var got []string
+                for _, m := range session.Messages {
+                    got = append(got, m.Content)
+                }
+                if !equalStrings(got, tt.want) {
+                    t.Errorf("replayed messages = %q, want %q", got, tt.want)
+                }
+            }
+
+            info, err := os.Stat(path)
+            if err != nil {
+                t.Fatalf("Stat: %v", err)
+            }
+            if info.Size() != 0 {
+                t.Errorf("journal holds %d bytes after compaction, want 0", info.Size())
+            }
+        })
+    }
+}
+
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,412 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/cards"
+)
+
+// Where a lorebook entry is inserted.
+const (
+    // LorePositionBefore puts the entry before the character definitions.
+    LorePositionBefore = "before"
+    // LorePositionAfter puts the entry after the character definitions.
+    LorePositionAfter = "after"
+    // LorePositionDepth puts the entry Depth messages from the end of the
+    // chat, like the author's note.
+    LorePositionDepth = "depth"
+)
+
+const (
+    DefaultLoreScanDepth   = 4
+    DefaultLoreTokenBudget = 512
+    MaxLoreScanDepth       = 100
+    MaxLoreTokenBudget     = 16384
+    maxLoreEntries         = 500
+    maxLoreName            = 64
+)
+
+var (
+    ErrLoreEntryNotFound = errors.New("lorebook entry not found")
+    ErrLoreEntryExists   = errors.New("a lorebook entry with that name already exists")
+    ErrLorebookFull      = fmt.Errorf("lorebooks are limited to %d entries", maxLoreEntries)
+    ErrLoreSettings      = fmt.Errorf("scan depth must be between 1 and %d and the token budget between 1 and %d", MaxLoreScanDepth, MaxLoreTokenBudget)
+)
+
+// LoreEntry is a piece of world info that is added to the context when one
+// of its keys shows up in the recent messages. Keys written as /pattern/
+// or /pattern/i are regular expressions.
+type LoreEntry struct {
+    ID            string
+    Name          string
+    Keys          []string
+    SecondaryKeys []string
+    Content       string
+    Position      string
+    Depth         int
+    // Order sorts entries within a position, lowest first. When the token
+    // budget runs out, entries with a higher order are kept first.
+    Order         int
+    CaseSensitive bool
+    // Selective entries also need one of their secondary keys to match.
+    Selective bool
+    // Constant entries are always inserted.
+    Constant bool
+    Enabled  bool
+}
+
+// Lorebook is a user's world info and how it is scanned.
+type Lorebook struct {
+    // ScanDepth is how many of the latest messages are searched for keys.
+    ScanDepth int
+    // TokenBudget caps the estimated tokens of the inserted entries.
+    TokenBudget int
+    Entries     []LoreEntry
+}
+
+func (l Lorebook) clone() Lorebook {
+    entries := make([]LoreEntry, len(l.Entries))
+    for i, entry := range l.Entries {
+        entry.Keys = append([]string(nil), entry.Keys...)
+        entry.SecondaryKeys = append([]string(nil), entry.SecondaryKeys...)
+        entries[i] = entry
+    }
+    l.Entries = entries
+    return l
+}
+
+// GetLorebook returns a copy of the user's lorebook.
+func (pm *PromptManager) GetLorebook(userID string) Lorebook {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    book := pm.getOrCreatePrompts(userID).Lorebook.clone()
+    if book.ScanDepth <= 0 {
+        book.ScanDepth = DefaultLoreScanDepth
+    }
+    if book.TokenBudget <= 0 {
+        book.TokenBudget = DefaultLoreTokenBudget
+    }
+    return book
+}
+
+// AddLoreEntry adds an entry to the user's lorebook. An unnamed entry is
+// named after its first key.
+func (pm *PromptManager) AddLoreEntry(userID string, entry LoreEntry) (LoreEntry, error) {
+    if entry.Name == "" && len(entry.Keys) > 0 {
+        entry.Name = entry.Keys[0]
+    }
+    if err := validateLoreEntry(&entry); err != nil {
+        return LoreEntry{}, err
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    book := &pm.getOrCreatePrompts(userID).Lorebook
+    if len(book.Entries) >= maxLoreEntries {
+        return LoreEntry{}, ErrLorebookFull
+    }
+    if book.find(entry.Name) >= 0 {
+        return LoreEntry{}, ErrLoreEntryExists
+    }
+    entry.ID = GenerateID()
+    book.Entries = append(book.Entries, entry)
+    pm.persist(userID)
+    return entry, nil
+}
+
+// UpdateLoreEntry replaces the named entry. The entry may be renamed.
+func (pm *PromptManager) UpdateLoreEntry(userID, name string, entry LoreEntry) (LoreEntry, error) {
+    if err := validateLoreEntry(&entry); err != nil {
+        return LoreEntry{}, err
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    book := &pm.getOrCreatePrompts(userID).Lorebook
+    index := book.find(strings.TrimSpace(name))
+    if index < 0 {
+        return LoreEntry{}, ErrLoreEntryNotFound
+    }
+    if other := book.find(entry.Name); other >= 0 && other != index {
+        return LoreEntry{}, ErrLoreEntryExists
+    }
+    entry.ID = book.Entries[index].ID
+    book.Entries[index] = entry
+    pm.persist(userID)
+    return entry, nil
+}
+
+// RemoveLoreEntry deletes the named entry and returns it.
+func (pm *PromptManager) RemoveLoreEntry(userID, name string) (LoreEntry, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    book := &pm.getOrCreatePrompts(userID).Lorebook
+    index := book.find(strings.TrimSpace(name))
+    if index < 0 {
+        return LoreEntry{}, ErrLoreEntryNotFound
+    }
+    removed := book.Entries[index]
+    book.Entries = append(book.Entries[:index], book.Entries[index+1:]...)
+    pm.persist(userID)
+    return removed, nil
+}
+
+// SetLoreSettings sets how many messages are scanned for keys and how many
+// tokens of entries may be inserted.
+func (pm *PromptManager) SetLoreSettings(userID string, scanDepth, tokenBudget int) error {
+    if scanDepth < 1 || scanDepth > MaxLoreScanDepth || tokenBudget < 1 || tokenBudget > MaxLoreTokenBudget {
+        return ErrLoreSettings
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    book := &pm.getOrCreatePrompts(userID).Lorebook
+    book.ScanDepth = scanDepth
+    book.TokenBudget = tokenBudget
+    pm.persist(userID)
+    return nil
+}
+
+// ImportWorldInfo adds entries read from a world info file to the user's
+// lorebook, replacing it when replace is set. Entries without content are
+// skipped and clashing names get a number. It returns how many entries were
+// imported.
+func (pm *PromptManager) ImportWorldInfo(userID string, entries []cards.WorldEntry, replace bool) (int, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    book := prompts.Lorebook.clone()
+    if replace {
+        book.Entries = nil
+    }
+
+    imported := 0
+    for _, world := range entries {
+        entry := loreEntryFromWorld(world)
+        if entry.Name == "" {
+            entry.Name = fmt.Sprintf("Entry %d", len(book.Entries)+1)
+        }
+        if utf8.RuneCountInString(entry.Name) > maxLoreName {
+            entry.Name = string([]rune(entry.Name)[:maxLoreName])
+        }
+        base := entry.Name
+        for n := 2; book.find(entry.Name) >= 0; n++ {
+            entry.Name = fmt.Sprintf("%s (%d)", base, n)
+        }
+        if err := validateLoreEntry(&entry); err != nil {
+            continue
+        }
+        if len(book.Entries) >= maxLoreEntries {
+            return 0, ErrLorebookFull
+        }
+        entry.ID = GenerateID()
+        book.Entries = append(book.Entries, entry)
+        imported++
+    }
+
+    prompts.Lorebook = book
+    pm.persist(userID)
+    return imported, nil
+}
+
+func loreEntryFromWorld(world cards.WorldEntry) LoreEntry {
+    entry := LoreEntry{
+        Name:          strings.TrimSpace(world.Name),
+        Keys:          world.Keys,
+        SecondaryKeys: world.SecondaryKeys,
+        Content:       world.Content,
+        Position:      LorePositionBefore,
+        Order:         world.Order,
+        CaseSensitive: world.CaseSensitive,
+        Selective:     world.Selective,
+        Constant:      world.Constant,
+        Enabled:       world.Enabled,
+    }
+    switch world.Position {
+    case cards.WorldAfterChar:
+        entry.Position = LorePositionAfter
+    case cards.WorldAuthorsNoteTop, cards.WorldAuthorsNoteEnd:
+        entry.Position = LorePositionDepth
+        entry.Depth = DefaultAuthorsNoteDepth
+    case cards.WorldAtDepth:
+        entry.Position = LorePositionDepth
+        entry.Depth = world.Depth
+    }
+    return entry
+}
+
+// validateLoreEntry cleans up entry and checks that it can be matched.
+func validateLoreEntry(entry *LoreEntry) error {
+    entry.Name = strings.TrimSpace(entry.Name)
+    entry.Keys = cleanKeys(entry.Keys)
+    entry.SecondaryKeys = cleanKeys(entry.SecondaryKeys)
+    if entry.Position == "" {
+        entry.Position = LorePositionBefore
+    }
+
+    switch {
+    case entry.Name == "" || utf8.RuneCountInString(entry.Name) > maxLoreName:
+        return fmt.Errorf("entry names must be 1 to %d characters long", maxLoreName)
+    case strings.TrimSpace(entry.Content) == "":
+        return errors.New("entry content cannot be empty")
+    case len(entry.Keys) == 0 && !entry.Constant:
+        return errors.New("entries need at least one key unless they are constant")
+    case entry.Position != LorePositionBefore && entry.Position != LorePositionAfter && entry.Position != LorePositionDepth:
+        return fmt.Errorf("unknown position %q", entry.Position)
+    case entry.Depth < 0 || entry.Depth > MaxAuthorsNoteDepth:
+        return fmt.Errorf("depth must be between 0 and %d", MaxAuthorsNoteDepth)
+    }
+    for _, key := range append(append([]string(nil), entry.Keys...), entry.SecondaryKeys...) {
+        if _, err := compileKey(key, entry.CaseSensitive); err != nil {
+            return fmt.Errorf("invalid key %q: %v", key, err)
+        }
+    }
+    return nil
+}
+
+func cleanKeys(keys []string) []string {
+    cleaned := make([]string, 0, len(keys))
+    for _, key := range keys {
+        if key = strings.TrimSpace(key); key != "" {
+            cleaned = append(cleaned, key)
+        }
+    }
+    return cleaned
+}
+
+// SplitKeys splits a comma separated list of keys.
+func SplitKeys(list string) []string {
+    return cleanKeys(strings.Split(list, ","))
+}
+
+// compileKey turns a key into a pattern. Keys written as /pattern/flags are
+// regular expressions; only the i flag is honoured. Plain keys made of
+// word characters match whole words, so "cat" does not fire on "concat".
+func compileKey(key string, caseSensitive bool) (*regexp.Regexp, error) {
+    if len(key) > 2 && key[0] == '/' {
+        if end := strings.LastIndex(key, "/"); end > 0 {
+            pattern, flags := key[1:end], key[end+1:]
+            if strings.Contains(flags, "i") {
+                pattern = "(?i)" + pattern
+            }
+            return regexp.Compile(pattern)
+        }
+    }
+
+    pattern := regexp.QuoteMeta(key)
+    first, _ := utf8.DecodeRuneInString(key)
+    last, _ := utf8.DecodeLastRuneInString(key)
+    if isWordRune(first) && isWordRune(last) {
+        pattern = `\b` + pattern + `\b`
+    }
+    if !caseSensitive {
+        pattern = "(?i)" + pattern
+    }
+    return regexp.Compile(pattern)
+}
+
+// isWordRune reports whether \b treats r as a word character.
+func isWordRune(r rune) bool {
+    return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+func matchesAny(keys []string, caseSensitive bool, text string) bool {
+    for _, key := range keys {
+        pattern, err := compileKey(key, caseSensitive)
+        if err == nil && pattern.MatchString(text) {
+            return true
+        }
+    }
+    return false
+}
+
+// loreInjection is an activated entry ready to be inserted.
+type loreInjection struct {
+    Entry   LoreEntry
+    Content string
+}
+
+// activateLore returns the entries of book triggered by the latest
+// messages of history, within the token budget, sorted by order.
+func activateLore(book Lorebook, history []Message, ctx MacroContext) []loreInjection {
+    scanDepth := book.ScanDepth
+    if scanDepth <= 0 {
+        scanDepth = DefaultLoreScanDepth
+    }
+    budget := book.TokenBudget
+    if budget <= 0 {
+        budget = DefaultLoreTokenBudget
+    }
+
+    var recent []string
+    for i := len(history) - 1; i >= 0 && len(recent) < scanDepth; i-- {
+        if history[i].Role != "system" {
+            recent = append(recent, history[i].Content)
+        }
+    }
+    text := strings.Join(recent, "\n")
+
+    var active []loreInjection
+    for _, entry := range book.Entries {
+        if !entry.Enabled {
+            continue
+        }
+        if !entry.Constant {
+            if !matchesAny(entry.Keys, entry.CaseSensitive, text) {
+                continue
+            }
+            if entry.Selective && len(entry.SecondaryKeys) > 0 && !matchesAny(entry.SecondaryKeys, entry.CaseSensitive, text) {
+                continue
+            }
+        }
+        active = append(active, loreInjection{Entry: entry, Content: ExpandMacros(entry.Content, ctx)})
+    }
+
+    // Spend the budget on the highest orders first.
+    sort.SliceStable(active, func(i, j int) bool {
+        return active[i].Entry.Order > active[j].Entry.Order
+    })
+    var kept []loreInjection
+    used := 0
+    for _, injection := range active {
+        tokens := EstimateTokens(injection.Content)
+        if used+tokens > budget {
+            continue
+        }
+        used += tokens
+        kept = append(kept, injection)
+    }
+
+    sort.SliceStable(kept, func(i, j int) bool {
+        return kept[i].Entry.Order < kept[j].Entry.Order
+    })
+    return kept
+}
+
+// EstimateTokens guesses the token count of text at about four characters
+// per token, which is close enough for budgeting.
+func EstimateTokens(text string) int {
+    return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// find returns the index of the named entry, ignoring case, or -1.
+func (l *Lorebook) find(name string) int {
+    for i, entry := range l.Entries {
+        if strings.EqualFold(entry.Name, name) {
+            return i
+        }
+    }
+    return -1
+}
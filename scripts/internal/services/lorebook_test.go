@@ -0,0 +1,130 @@
+package services
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+    tests := []struct {
+        name          string
+        keys          []string
+        caseSensitive bool
+        text          string
+        want          bool
+    }{
+        {"plain key", []string{"dragon"}, false, "A dragon appears", true},
+        {"ignores case", []string{"Dragon"}, false, "a DRAGON appears", true},
+        {"case sensitive", []string{"Dragon"}, true, "a dragon appears", false},
+        {"whole words only", []string{"cat"}, false, "concat the files", false},
+        {"word at the end", []string{"cat"}, false, "I pet the cat", true},
+        {"key with punctuation", []string{"Dr."}, false, "ask dr. Smith", true},
+        {"phrase", []string{"old tower"}, false, "the Old Tower looms", true},
+        {"any of several keys", []string{"sword", "shield"}, false, "raise your shield", true},
+        {"no key matches", []string{"sword", "shield"}, false, "run away", false},
+        {"regular expression", []string{"/drag(on|oness)/"}, false, "the dragoness sleeps", true},
+        {"regex is case sensitive without i", []string{"/Dragon/"}, false, "a dragon", false},
+        {"regex with i flag", []string{"/Dragon/i"}, true, "a dragon", true},
+        {"invalid regex never matches", []string{"/([/"}, false, "([", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := matchesAny(tt.keys, tt.caseSensitive, tt.text); got != tt.want {
+                t.Errorf("matchesAny(%q, %q) = %v, want %v", tt.keys, tt.text, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestActivateLore(t *testing.T) {
+    entry := func(name, key, content string, order int) LoreEntry {
+        return LoreEntry{Name: name, Keys: []string{key}, Content: content, Order: order, Enabled: true}
+    }
+    history := []Message{
+        {Role: "user", Content: "Tell me about the castle"},
+        {Role: "system", Content: "dragon"},
+        {Role: "assistant", Content: "It stands by the lake"},
+        {Role: "user", Content: "And the forest?"},
+    }
+    long := strings.Repeat("x", 40) // 10 tokens
+
+    tests := []struct {
+        name string
+        book Lorebook
+        want []string
+    }{
+        {
+            name: "keys in recent messages",
+            book: Lorebook{Entries: []LoreEntry{
+                entry("castle", "castle", "Castle lore", 0),
+                entry("lake", "lake", "Lake lore", 0),
+                entry("desert", "desert", "Desert lore", 0),
+            }},
+            want: []string{"Castle lore", "Lake lore"},
+        },
+        {
+            name: "system messages are not scanned",
+            book: Lorebook{Entries: []LoreEntry{entry("dragon", "dragon", "Dragon lore", 0)}},
+            want: nil,
+        },
+        {
+            name: "scan depth limits the messages searched",
+            book: Lorebook{ScanDepth: 2, Entries: []LoreEntry{
+                entry("castle", "castle", "Castle lore", 0),
+                entry("forest", "forest", "Forest lore", 0),
+            }},
+            want: []string{"Forest lore"},
+        },
+        {
+            name: "disabled, constant and selective entries",
+            book: Lorebook{Entries: []LoreEntry{
+                {Name: "off", Keys: []string{"castle"}, Content: "Off", Enabled: false},
+                {Name: "always", Content: "Always", Constant: true, Enabled: true},
+                {Name: "both", Keys: []string{"castle"}, SecondaryKeys: []string{"lake"}, Selective: true, Content: "Both", Enabled: true},
+                {Name: "missing", Keys: []string{"castle"}, SecondaryKeys: []string{"moat"}, Selective: true, Content: "Missing", Enabled: true},
+            }},
+            want: []string{"Always", "Both"},
+        },
+        {
+            name: "sorted by order",
+            book: Lorebook{Entries: []LoreEntry{
+                entry("late", "castle", "Late", 5),
+                entry("early", "lake", "Early", 1),
+            }},
+            want: []string{"Early", "Late"},
+        },
+        {
+            name: "budget keeps the highest orders",
+            book: Lorebook{TokenBudget: 25, Entries: []LoreEntry{
+                entry("low", "castle", long+"low", 1),
+                entry("high", "lake", long+"high", 3),
+                entry("mid", "forest", long+"mid", 2),
+            }},
+            want: []string{long + "mid", long + "high"},
+        },
+        {
+            name: "entries over the budget are skipped, smaller ones still fit",
+            book: Lorebook{TokenBudget: 12, Entries: []LoreEntry{
+                entry("big", "castle", long+long, 3),
+                entry("small", "lake", long, 1),
+            }},
+            want: []string{long},
+        },
+        {
+            name: "macros in content",
+            book: Lorebook{Entries: []LoreEntry{entry("castle", "castle", "{{char}} lives here", 0)}},
+            want: []string{"Alice lives here"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var got []string
+            for _, injection := range activateLore(tt.book, history, MacroContext{Char: "Alice"}) {
+                got = append(got, injection.Content)
+            }
+            if !equalStrings(got, tt.want) {
+                t.Errorf("activated %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+    "fmt"
+    "math/rand"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Limits on {{roll}} so a card cannot ask for a million dice.
+const (
+    maxDice     = 100
+    maxDieSides = 1000
+)
+
+// Stand-ins for {{char}} and {{user}} when no name is known.
+const (
+    unnamedCharacter = "Character"
+    defaultUserName  = "User"
+)
+
+var (
+    macroPattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+    dicePattern  = regexp.MustCompile(`^(?:(\d*)d)?(\d+)([+-]\d+)?$`)
+)
+
+// MacroContext holds the values macros expand to.
+type MacroContext struct {
+    Char string
+    User string
+    Now  time.Time
+    // Idle is how long the user was away before their latest message.
+    Idle time.Duration
+    // Original is the character's own post-history instructions, for guild
+    // overrides that build on them.
+    Original string
+}
+
+// ExpandMacros replaces SillyTavern-style macros in text: {{char}},
+// {{user}}, {{time}}, {{date}}, {{idle_duration}}, {{original}},
+// {{random:a,b,c}} and {{roll:2d6}}. Macro names are not case sensitive and unknown macros are
+// left as they are.
+func ExpandMacros(text string, ctx MacroContext) string {
+    if !strings.Contains(text, "{{") {
+        return text
+    }
+
+    return macroPattern.ReplaceAllStringFunc(text, func(match string) string {
+        body := match[2 : len(match)-2]
+        name, arg, hasArg := strings.Cut(body, ":")
+        name = strings.ToLower(strings.TrimSpace(name))
+
+        switch {
+        case name == "char" && !hasArg:
+            return ctx.Char
+        case name == "user" && !hasArg:
+            return ctx.User
+        case name == "time" && !hasArg:
+            return ctx.Now.Format("3:04 PM")
+        case name == "date" && !hasArg:
+            return ctx.Now.Format("January 2, 2006")
+        case name == "idle_duration" && !hasArg:
+            return formatIdle(ctx.Idle)
+        case name == "original" && !hasArg:
+            return ctx.Original
+        case name == "random" && hasArg:
+            return randomChoice(arg)
+        case name == "roll" && hasArg:
+            if result, ok := rollDice(arg); ok {
+                return strconv.Itoa(result)
+            }
+        }
+        return match
+    })
+}
+
+// userPresence is what the prompt manager knows about a user from Discord.
+// It is not persisted; it is refreshed by every message and command.
+type userPresence struct {
+    DisplayName  string
+    // GuildID and ChannelID are where the latest message or command was
+    // sent; the guild is empty for DMs.
+    GuildID      string
+    ChannelID    string
+    // LastSeen and PreviousSeen are the times of the latest chat messages
+    // only, so using a command does not reset {{idle_duration}}.
+    LastSeen     time.Time
+    PreviousSeen time.Time
+}
+
+// TrackPresence records that userID just sent a chat message in channelID
+// of guildID under displayName. The display name fills {{user}} when no
+// persona applies, the gap since their previous message fills
+// {{idle_duration}}, and the guild and channel pick the guild settings and
+// the bound persona their next reply is prepared with.
+func (pm *PromptManager) TrackPresence(userID, guildID, channelID, displayName string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    presence := pm.trackLocation(userID, guildID, channelID, displayName)
+    presence.PreviousSeen = presence.LastSeen
+    presence.LastSeen = time.Now()
+}
+
+// TrackCommand records where userID just used a command, like
+// TrackPresence, without counting it as a message for {{idle_duration}}.
+func (pm *PromptManager) TrackCommand(userID, guildID, channelID, displayName string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    pm.trackLocation(userID, guildID, channelID, displayName)
+}
+
+// trackLocation updates the user's display name, guild and channel.
+// Callers must hold pm.mu.
+func (pm *PromptManager) trackLocation(userID, guildID, channelID, displayName string) *userPresence {
+    presence, exists := pm.presence[userID]
+    if !exists {
+        presence = &userPresence{}
+        pm.presence[userID] = presence
+    }
+    if displayName != "" {
+        presence.DisplayName = displayName
+    }
+    presence.GuildID = guildID
+    presence.ChannelID = channelID
+    return presence
+}
+
+// macroContext returns the macro values for userID. Callers must hold
+// pm.mu.
+func (pm *PromptManager) macroContext(userID string, prompts *UserPrompts) MacroContext {
+    ctx := MacroContext{
+        Char: prompts.Name,
+        User: defaultUserName,
+        Now:  time.Now(),
+    }
+    if ctx.Char == "" {
+        ctx.Char = unnamedCharacter
+    }
+    if presence, exists := pm.presence[userID]; exists {
+        if presence.DisplayName != "" {
+            ctx.User = presence.DisplayName
+        }
+        if !presence.PreviousSeen.IsZero() {
+            ctx.Idle = presence.LastSeen.Sub(presence.PreviousSeen)
+        }
+    }
+    if persona, ok := prompts.persona(pm.channelOf(userID)); ok {
+        ctx.User = persona.Name
+    }
+    return ctx
+}
+
+// channelOf returns the channel of the user's latest message. Callers must
+// hold pm.mu.
+func (pm *PromptManager) channelOf(userID string) string {
+    if presence, exists := pm.presence[userID]; exists {
+        return presence.ChannelID
+    }
+    return ""
+}
+
+// randomChoice picks one item of a comma separated list. The "::"
+// separator is accepted too, for items that contain commas.
+func randomChoice(list string) string {
+    var items []string
+    if strings.HasPrefix(list, ":") {
+        items = strings.Split(strings.TrimPrefix(list, ":"), "::")
+    } else {
+        items = strings.Split(list, ",")
+    }
+    return strings.TrimSpace(items[rand.Intn(len(items))])
+}
+
+// rollDice rolls dice written as "2d6", "d20", "20" or "3d8+2".
+func rollDice(spec string) (int, bool) {
+    parts := dicePattern.FindStringSubmatch(strings.ToLower(strings.ReplaceAll(spec, " ", "")))
+    if parts == nil {
+        return 0, false
+    }
+
+    count := 1
+    if parts[1] != "" {
+        count, _ = strconv.Atoi(parts[1])
+    }
+    sides, _ := strconv.Atoi(parts[2])
+    if count < 1 || count > maxDice || sides < 1 || sides > maxDieSides {
+        return 0, false
+    }
+
+    total := 0
+    for n := 0; n < count; n++ {
+        total += rand.Intn(sides) + 1
+    }
+    if parts[3] != "" {
+        modifier, _ := strconv.Atoi(parts[3])
+        total += modifier
+    }
+    return total, true
+}
+
+// formatIdle describes an idle duration the way a person would.
+func formatIdle(idle time.Duration) string {
+    switch {
+    case idle < time.Minute:
+        return "just now"
+    case idle < time.Hour:
+        return plural(int(idle/time.Minute), "minute")
+    case idle < 24*time.Hour:
+        return plural(int(idle/time.Hour), "hour")
+    default:
+        return plural(int(idle/(24*time.Hour)), "day")
+    }
+}
+
+func plural(n int, unit string) string {
+    if n == 1 {
+        return fmt.Sprintf("1 %s", unit)
+    }
+    return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestExpandMacros(t *testing.T) {
+    ctx := MacroContext{
+        Char:     "Alice",
+        User:     "Bob",
+        Now:      time.Date(2024, time.March, 5, 14, 7, 0, 0, time.UTC),
+        Idle:     3 * time.Hour,
+        Original: "Be kind",
+    }
+    tests := []struct {
+        name string
+        text string
+        want string
+    }{
+        {"no macros", "plain text", "plain text"},
+        {"names", "{{char}} greets {{user}}", "Alice greets Bob"},
+        {"case and spaces", "{{ CHAR }} and {{User}}", "Alice and Bob"},
+        {"time and date", "{{time}} on {{date}}", "2:07 PM on March 5, 2024"},
+        {"idle duration", "away for {{idle_duration}}", "away for 3 hours"},
+        {"original", "{{original}}, always", "Be kind, always"},
+        {"unknown macro is kept", "{{weather}}", "{{weather}}"},
+        {"argument on a plain macro is kept", "{{char:x}}", "{{char:x}}"},
+        {"single choice", "{{random:only}}", "only"},
+        {"fixed roll", "{{roll:1d1+4}}", "5"},
+        {"too many dice are kept", "{{roll:101d6}}", "{{roll:101d6}}"},
+        {"unclosed braces", "{{char", "{{char"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := ExpandMacros(tt.text, ctx); got != tt.want {
+                t.Errorf("ExpandMacros(%q) = %q, want %q", tt.text, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestRandomChoice(t *testing.T) {
+    tests := []struct {
+        name string
+        list string
+        want []string
+    }{
+        {"commas", "a, b ,c", []string{"a", "b", "c"}},
+        {"double colons", ":red, green::blue", []string{"red, green", "blue"}},
+        {"single item", "solo", []string{"solo"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for n := 0; n < 50; n++ {
+                got := randomChoice(tt.list)
+                if !containsString(tt.want, got) {
+                    t.Fatalf("randomChoice(%q) = %q, want one of %q", tt.list, got, tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestRollDice(t *testing.T) {
+    tests := []struct {
+        spec     string
+        ok       bool
+        min, max int
+    }{
+        {"2d6", true, 2, 12},
+        {"d20", true, 1, 20},
+        {"20", true, 1, 20},
+        {"3d8+2", true, 5, 26},
+        {"1d4-1", true, 0, 3},
+        {" 2 D 6 ", true, 2, 12},
+        {"100d1000", true, 100, 100000},
+        {"101d6", false, 0, 0},
+        {"1d1001", false, 0, 0},
+        {"0d6", false, 0, 0},
+        {"1d0", false, 0, 0},
+        {"2d", false, 0, 0},
+        {"six", false, 0, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.spec, func(t *testing.T) {
+            for n := 0; n < 50; n++ {
+                got, ok := rollDice(tt.spec)
+                if ok != tt.ok {
+                    t.Fatalf("rollDice(%q) ok = %v, want %v", tt.spec, ok, tt.ok)
+                }
+                if ok && (got < tt.min || got > tt.max) {
+                    t.Fatalf("rollDice(%q) = %d, want %d to %d", tt.spec, got, tt.min, tt.max)
+                }
+            }
+        })
+    }
+}
+
+func TestFormatIdle(t *testing.T) {
+    tests := []struct {
+        idle time.Duration
+        want string
+    }{
+        {0, "just now"},
+        {59 * time.Second, "just now"},
+        {time.Minute, "1 minute"},
+        {45 * time.Minute, "45 minutes"},
+        {time.Hour, "1 hour"},
+        {23 * time.Hour, "23 hours"},
+        {24 * time.Hour, "1 day"},
+        {72 * time.Hour, "3 days"},
+    }
+    for _, tt := range tests {
+        t.Run(strconv.Itoa(int(tt.idle.Seconds())), func(t *testing.T) {
+            if got := formatIdle(tt.idle); got != tt.want {
+                t.Errorf("formatIdle(%v) = %q, want %q", tt.idle, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIdleDurationIgnoresCommands(t *testing.T) {
+    pm := NewPromptManager(nil)
+    pm.TrackPresence("u", "g", "c", "Bob")
+    pm.presence["u"].LastSeen = time.Now().Add(-2 * time.Hour)
+
+    // Commands update where the user is, not when they last chatted
+    pm.TrackCommand("u", "g", "c2", "Bobby")
+    pm.TrackPresence("u", "g", "c2", "Bobby")
+
+    pm.mu.Lock()
+    ctx := pm.macroContext("u", pm.getOrCreatePrompts("u"))
+    channel := pm.channelOf("u")
+    pm.mu.Unlock()
+    if ctx.Idle < 2*time.Hour-time.Minute {
+        t.Errorf("Idle = %v, want about 2h", ctx.Idle)
+    }
+    if ctx.User != "Bobby" || channel != "c2" {
+        t.Errorf("User = %q in %q, want Bobby in c2", ctx.User, channel)
+    }
+}
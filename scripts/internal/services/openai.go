@@ -25,18 +25,23 @@ type CompletionRequest struct {
     Temperature float64
 }
 
-func NewOpenAIService(apiKey string) *OpenAIService {
+// NewOpenAIService sends completions through proxyClient, which carries
+// the backend settings such as the instruct template.
+func NewOpenAIService(apiKey string, proxyClient *ProxyClient) *OpenAIService {
     return &OpenAIService{
+        proxyClient: proxyClient,
+        cache:       make(map[string][]Message),
         apiKey: apiKey,
         client: &http.Client{},
     }
 }
 
 
+// GenerateCompletion sends req.Messages as they are; callers pass the whole
+// prepared context. The exchange is cached so it can be regenerated.
 func (s *OpenAIService) GenerateCompletion(ctx context.Context, req CompletionRequest) (string, error) {
+    messages := append([]Message(nil), req.Messages...)
     s.mu.Lock()
-    messages := s.getMessages(req.UserID)
-    messages = append(messages, req.Messages...)
     s.cache[req.UserID] = messages
     s.mu.Unlock()
 
@@ -0,0 +1,264 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "unicode/utf8"
+)
+
+const maxPersonas = 25
+
+var (
+    ErrPersonaNotFound = errors.New("persona not found")
+    ErrPersonaExists   = errors.New("a persona with that name already exists")
+    ErrPersonaName     = fmt.Errorf("persona names must be 1 to %d characters long", maxCharacterName)
+    ErrPersonasFull    = fmt.Errorf("you can keep up to %d personas", maxPersonas)
+    ErrNoBindTarget    = errors.New("pick a character or a channel")
+)
+
+// Persona is who the user plays as. Its name fills {{user}} and its
+// description is sent as the user persona.
+type Persona struct {
+    ID          string
+    Name        string
+    Description string
+    // Characters and Channels hold the IDs of the characters and channels
+    // the persona is bound to. A bound persona is used automatically there,
+    // whichever persona is active.
+    Characters []string
+    Channels   []string
+}
+
+// ListPersonas returns the user's personas sorted by name, and the ID of
+// the active one.
+func (pm *PromptManager) ListPersonas(userID string) ([]Persona, string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    personas := make([]Persona, len(prompts.Personas))
+    for i, persona := range prompts.Personas {
+        personas[i] = persona.clone()
+    }
+    sort.Slice(personas, func(i, j int) bool {
+        return strings.ToLower(personas[i].Name) < strings.ToLower(personas[j].Name)
+    })
+    return personas, prompts.PersonaID
+}
+
+// CreatePersona adds a persona to the user's library and makes it active.
+func (pm *PromptManager) CreatePersona(userID, name, description string) (Persona, error) {
+    name = strings.TrimSpace(name)
+    if name == "" || utf8.RuneCountInString(name) > maxCharacterName {
+        return Persona{}, ErrPersonaName
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if prompts.findPersona(name) >= 0 {
+        return Persona{}, ErrPersonaExists
+    }
+    if len(prompts.Personas) >= maxPersonas {
+        return Persona{}, ErrPersonasFull
+    }
+    persona := Persona{ID: GenerateID(), Name: name, Description: description}
+    prompts.Personas = append(prompts.Personas, persona)
+    prompts.PersonaID = persona.ID
+    pm.persist(userID)
+    return persona, nil
+}
+
+// SwitchPersona makes the named persona active. An empty name goes back to
+// no persona.
+func (pm *PromptManager) SwitchPersona(userID, name string) (Persona, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if strings.TrimSpace(name) == "" {
+        prompts.PersonaID = ""
+        pm.persist(userID)
+        return Persona{}, nil
+    }
+    index := prompts.findPersona(strings.TrimSpace(name))
+    if index < 0 {
+        return Persona{}, ErrPersonaNotFound
+    }
+    prompts.PersonaID = prompts.Personas[index].ID
+    pm.persist(userID)
+    return prompts.Personas[index].clone(), nil
+}
+
+// DeletePersona removes a persona and its bindings from the user's
+// library and returns it.
+func (pm *PromptManager) DeletePersona(userID, name string) (Persona, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    index := prompts.findPersona(strings.TrimSpace(name))
+    if index < 0 {
+        return Persona{}, ErrPersonaNotFound
+    }
+    deleted := prompts.Personas[index]
+    prompts.Personas = append(prompts.Personas[:index], prompts.Personas[index+1:]...)
+    if prompts.PersonaID == deleted.ID {
+        prompts.PersonaID = ""
+    }
+    pm.persist(userID)
+    return deleted, nil
+}
+
+// BindPersona makes the named persona the one used with a character of
+// the user's library, in a channel, or both. A character or channel is
+// bound to one persona at a time, so earlier bindings are moved.
+func (pm *PromptManager) BindPersona(userID, name, character, channelID string) (Persona, error) {
+    if character == "" && channelID == "" {
+        return Persona{}, ErrNoBindTarget
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    index := prompts.findPersona(strings.TrimSpace(name))
+    if index < 0 {
+        return Persona{}, ErrPersonaNotFound
+    }
+    characterID := ""
+    if character != "" {
+        bound, err := prompts.characterByName(character)
+        if err != nil {
+            return Persona{}, err
+        }
+        characterID = bound.ID
+    }
+
+    for i := range prompts.Personas {
+        prompts.Personas[i].unbind(characterID, channelID)
+    }
+    persona := &prompts.Personas[index]
+    if characterID != "" {
+        persona.Characters = append(persona.Characters, characterID)
+    }
+    if channelID != "" {
+        persona.Channels = append(persona.Channels, channelID)
+    }
+    pm.persist(userID)
+    return persona.clone(), nil
+}
+
+// UnbindPersona removes the named persona's binding to a character, a
+// channel, or both.
+func (pm *PromptManager) UnbindPersona(userID, name, character, channelID string) (Persona, error) {
+    if character == "" && channelID == "" {
+        return Persona{}, ErrNoBindTarget
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    index := prompts.findPersona(strings.TrimSpace(name))
+    if index < 0 {
+        return Persona{}, ErrPersonaNotFound
+    }
+    characterID := ""
+    if character != "" {
+        bound, err := prompts.characterByName(character)
+        if err != nil {
+            return Persona{}, err
+        }
+        characterID = bound.ID
+    }
+    prompts.Personas[index].unbind(characterID, channelID)
+    pm.persist(userID)
+    return prompts.Personas[index].clone(), nil
+}
+
+// CharacterName returns the name of a character of the user's library by
+// ID, for showing persona bindings.
+func (pm *PromptManager) CharacterName(userID, id string) (string, bool) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    character, err := pm.getOrCreatePrompts(userID).characterByID(id)
+    return character.Name, err == nil
+}
+
+// persona returns the persona used with the active character in
+// channelID: the one bound to the channel, else the one bound to the
+// character, else the active one.
+func (p *UserPrompts) persona(channelID string) (Persona, bool) {
+    if channelID != "" {
+        for _, persona := range p.Personas {
+            if containsString(persona.Channels, channelID) {
+                return persona, true
+            }
+        }
+    }
+    if p.ID != "" {
+        for _, persona := range p.Personas {
+            if containsString(persona.Characters, p.ID) {
+                return persona, true
+            }
+        }
+    }
+    for _, persona := range p.Personas {
+        if persona.ID == p.PersonaID {
+            return persona, true
+        }
+    }
+    return Persona{}, false
+}
+
+// applyPersona sends the description of the persona used in channelID as
+// the user persona. Only call it on a clone.
+func (p *UserPrompts) applyPersona(channelID string) {
+    if persona, ok := p.persona(channelID); ok {
+        p.UserPersona = persona.Description
+    }
+}
+
+// findPersona returns the index of the named persona, ignoring case, or -1.
+func (p *UserPrompts) findPersona(name string) int {
+    for i, persona := range p.Personas {
+        if strings.EqualFold(persona.Name, name) {
+            return i
+        }
+    }
+    return -1
+}
+
+// unbindCharacter drops the bindings of a deleted character.
+func (p *UserPrompts) unbindCharacter(id string) {
+    for i := range p.Personas {
+        p.Personas[i].unbind(id, "")
+    }
+}
+
+func (p *Persona) unbind(characterID, channelID string) {
+    p.Characters = removeString(p.Characters, characterID)
+    p.Channels = removeString(p.Channels, channelID)
+}
+
+func (p Persona) clone() Persona {
+    p.Characters = append([]string(nil), p.Characters...)
+    p.Channels = append([]string(nil), p.Channels...)
+    return p
+}
+
+// removeString returns values without value.
+func removeString(values []string, value string) []string {
+    kept := values[:0]
+    for _, v := range values {
+        if v != value {
+            kept = append(kept, v)
+        }
+    }
+    return kept
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+// MaxRetentionDays caps the retention period a guild can set.
+const MaxRetentionDays = 3650
+
+var ErrInvalidRetention = fmt.Errorf("retention must be between 1 and %d days", MaxRetentionDays)
+
+// RetentionPolicy limits how long chat history is kept for members of a
+// guild.
+type RetentionPolicy struct {
+    GuildID   string    `json:"guild_id"`
+    Days      int       `json:"days"`
+    SetBy     string    `json:"set_by"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (p *RetentionPolicy) MaxAge() time.Duration {
+    return time.Duration(p.Days) * 24 * time.Hour
+}
+
+// ForgetReport lists what ForgetUser removed.
+type ForgetReport struct {
+    Prompts          bool
+    Config           bool
+    Session          bool
+    SavedChats       int
+    Backups          int
+    RewrittenBackups int
+    Guilds           int
+}
+
+// RetentionReport sums up one retention run.
+type RetentionReport struct {
+    Users      int
+    Messages   int
+    SavedChats int
+}
+
+// privacyState is what the privacy manager keeps on disk: the retention
+// policy of each guild and the users seen in each guild, so policies can be
+// applied to data that is stored per user.
+type privacyState struct {
+    Policies map[string]*RetentionPolicy `json:"policies"`
+    Members  map[string]map[string]bool  `json:"members"`
+}
+
+// PrivacyManager deletes user data on request and enforces guild retention
+// policies.
+type PrivacyManager struct {
+    path          string
+    state         privacyState
+    promptManager *PromptManager
+    chatManager   *ChatManager
+    proxyClient   *ProxyClient
+    backupManager *BackupManager
+    stop          chan struct{}
+    done          chan struct{}
+    mu            sync.Mutex
+}
+
+// NewPrivacyManager loads the privacy state kept at path.
+func NewPrivacyManager(path string, pm *PromptManager, cm *ChatManager, pc *ProxyClient, bm *BackupManager) (*PrivacyManager, error) {
+    manager := &PrivacyManager{
+        path:          path,
+        promptManager: pm,
+        chatManager:   cm,
+        proxyClient:   pc,
+        backupManager: bm,
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, fmt.Errorf("error creating privacy directory: %v", err)
+    }
+    if err := readJSONFile(path, &manager.state); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("error reading privacy state: %v", err)
+    }
+    if manager.state.Policies == nil {
+        manager.state.Policies = make(map[string]*RetentionPolicy)
+    }
+    if manager.state.Members == nil {
+        manager.state.Members = make(map[string]map[string]bool)
+    }
+    return manager, nil
+}
+
+// ForgetUser deletes everything stored about userID: prompts, settings, the
+// active session, saved chats and backups. Instance backups that include
+// the user are rewritten without them.
+func (m *PrivacyManager) ForgetUser(userID string) (*ForgetReport, error) {
+    report := &ForgetReport{}
+    var err error
+
+    if report.Prompts, err = m.promptManager.DeleteUser(userID); err != nil {
+        return report, fmt.Errorf("error deleting prompts: %v", err)
+    }
+    if report.Config, err = m.proxyClient.DeleteUser(userID); err != nil {
+        return report, fmt.Errorf("error deleting settings: %v", err)
+    }
+    if report.Session, report.SavedChats, err = m.chatManager.DeleteUserData(userID); err != nil {
+        return report, fmt.Errorf("error deleting chats: %v", err)
+    }
+    if m.backupManager != nil {
+        if report.Backups, report.RewrittenBackups, err = m.backupManager.DeleteUserBackups(userID); err != nil {
+            return report, fmt.Errorf("error deleting backups: %v", err)
+        }
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for _, members := range m.state.Members {
+        if members[userID] {
+            delete(members, userID)
+            report.Guilds++
+        }
+    }
+    if report.Guilds > 0 {
+        if err := m.save(); err != nil {
+            return report, err
+        }
+    }
+    return report, nil
+}
+
+// TrackUser records that userID is active in guildID, so the guild's
+// retention policy applies to them.
+func (m *PrivacyManager) TrackUser(guildID, userID string) {
+    if guildID == "" || userID == "" {
+        return
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    members, exists := m.state.Members[guildID]
+    if !exists {
+        members = make(map[string]bool)
+        m.state.Members[guildID] = members
+    }
+    if members[userID] {
+        return
+    }
+    members[userID] = true
+    if err := m.save(); err != nil {
+        log.Printf("Error saving privacy state: %v", err)
+    }
+}
+
+// SetRetention sets how many days of chat history guildID keeps.
+func (m *PrivacyManager) SetRetention(guildID, setBy string, days int) (*RetentionPolicy, error) {
+    if days < 1 || days > MaxRetentionDays {
+        return nil, ErrInvalidRetention
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    policy := &RetentionPolicy{
+        GuildID:   guildID,
+        Days:      days,
+        SetBy:     setBy,
+        UpdatedAt: time.Now(),
+    }
+    previous := m.state.Policies[guildID]
+    m.state.Policies[guildID] = policy
+    if err := m.save(); err != nil {
+        if previous != nil {
+            m.state.Policies[guildID] = previous
+        } else {
+            delete(m.state.Policies, guildID)
+        }
+        return nil, err
+    }
+    copied := *policy
+    return &copied, nil
+}
+
+// ClearRetention removes the retention policy of guildID. It reports
+// whether there was one.
+func (m *PrivacyManager) ClearRetention(guildID string) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    previous, exists := m.state.Policies[guildID]
+    if !exists {
+        return false, nil
+    }
+    delete(m.state.Policies, guildID)
+    if err := m.save(); err != nil {
+        m.state.Policies[guildID] = previous
+        return false, err
+    }
+    return true, nil
+}
+
+// GetRetention returns the retention policy of guildID, or nil if it has
+// none.
+func (m *PrivacyManager) GetRetention(guildID string) *RetentionPolicy {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    policy, exists := m.state.Policies[guildID]
+    if !exists {
+        return nil
+    }
+    copied := *policy
+    return &copied
+}
+
+// EnforceRetention prunes chat history that is older than the policy of
+// any guild its owner belongs to. When a user is in several guilds the
+// shortest retention period wins.
+func (m *PrivacyManager) EnforceRetention() (*RetentionReport, error) {
+    cutoffs := m.retentionCutoffs(time.Now())
+
+    userIDs := make([]string, 0, len(cutoffs))
+    for userID := range cutoffs {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+
+    report := &RetentionReport{}
+    var errs []error
+    for _, userID := range userIDs {
+        messages, chats, err := m.chatManager.PruneHistory(userID, cutoffs[userID])
+        if err != nil {
+            errs = append(errs, fmt.Errorf("pruning history of %s: %v", userID, err))
+        }
+        if messages > 0 || chats > 0 {
+            report.Users++
+            report.Messages += messages
+            report.SavedChats += chats
+        }
+    }
+    return report, errors.Join(errs...)
+}
+
+// retentionCutoffs returns, per user, the time before which their history
+// must be deleted.
+func (m *PrivacyManager) retentionCutoffs(now time.Time) map[string]time.Time {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    cutoffs := make(map[string]time.Time)
+    for guildID, policy := range m.state.Policies {
+        cutoff := now.Add(-policy.MaxAge())
+        for userID := range m.state.Members[guildID] {
+            if current, exists := cutoffs[userID]; !exists || cutoff.After(current) {
+                cutoffs[userID] = cutoff
+            }
+        }
+    }
+    return cutoffs
+}
+
+// StartRetention enforces retention policies every interval until
+// StopRetention is called.
+func (m *PrivacyManager) StartRetention(interval time.Duration) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.stop != nil || interval <= 0 {
+        return
+    }
+    m.stop = make(chan struct{})
+    m.done = make(chan struct{})
+    go m.runRetention(interval, m.stop, m.done)
+
+    log.Printf("Retention job started (every %v)", interval)
+}
+
+// StopRetention halts the retention job and waits for a run in progress to
+// finish.
+func (m *PrivacyManager) StopRetention() {
+    m.mu.Lock()
+    stop, done := m.stop, m.done
+    m.stop, m.done = nil, nil
+    m.mu.Unlock()
+
+    if stop == nil {
+        return
+    }
+    close(stop)
+    <-done
+}
+
+func (m *PrivacyManager) runRetention(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+    defer close(done)
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            report, err := m.EnforceRetention()
+            if err != nil {
+                log.Printf("Error enforcing retention: %v", err)
+            }
+            if report.Users > 0 {
+                log.Printf("Retention removed %d messages and %d saved chats from %d users",
+                    report.Messages, report.SavedChats, report.Users)
+            }
+        case <-stop:
+            return
+        }
+    }
+}
+
+// save writes the privacy state to disk. Callers must hold m.mu.
+func (m *PrivacyManager) save() error {
+    if err := writeJSONFile(m.path, &m.state); err != nil {
+        return fmt.Errorf("error writing privacy state: %v", err)
+    }
+    return nil
+}
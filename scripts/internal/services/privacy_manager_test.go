@@ -0,0 +1,286 @@
+package services
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// privacyFixture is a privacy manager over in-memory stores with a journal
+// and a backup directory.
+type privacyFixture struct {
+    privacy     *PrivacyManager
+    prompts     *PromptManager
+    chats       *ChatManager
+    proxy       *ProxyClient
+    backups     *BackupManager
+    promptStore PromptStore
+    configStore ConfigStore
+    sessions    SessionStore
+    journalPath string
+}
+
+func newPrivacyFixture(t *testing.T) *privacyFixture {
+    t.Helper()
+    dir := t.TempDir()
+    f := &privacyFixture{
+        promptStore: NewMemoryPromptStore(),
+        configStore: NewMemoryConfigStore(),
+        sessions:    NewMemorySessionStore(),
+        journalPath: filepath.Join(dir, "journal.log"),
+    }
+    f.prompts = NewPromptManager(f.promptStore)
+    f.chats = NewChatManager(nil, f.prompts, f.sessions)
+    f.proxy = NewProxyClient("", "", f.configStore)
+
+    journal, err := OpenJournal(f.journalPath, nil)
+    if err != nil {
+        t.Fatalf("OpenJournal: %v", err)
+    }
+    t.Cleanup(func() { journal.Close() })
+    if err := f.chats.UseJournal(journal); err != nil {
+        t.Fatalf("UseJournal: %v", err)
+    }
+
+    if f.backups, err = NewBackupManager(filepath.Join(dir, "backups"), f.prompts, f.chats, f.proxy, nil); err != nil {
+        t.Fatalf("NewBackupManager: %v", err)
+    }
+    f.backups.UseSigner(testKeyring(t, "k1", 1))
+    if f.privacy, err = NewPrivacyManager(filepath.Join(dir, "privacy.json"), f.prompts, f.chats, f.proxy, f.backups); err != nil {
+        t.Fatalf("NewPrivacyManager: %v", err)
+    }
+    return f
+}
+
+func TestForgetUser(t *testing.T) {
+    f := newPrivacyFixture(t)
+    for _, userID := range []string{"alice", "bob"} {
+        f.prompts.UpdateDefinitions(userID, map[string]string{"description": "secret of " + userID})
+        f.proxy.SetTemperature(userID, 0.5)
+        f.chats.AddMessage(userID, "user", "message from "+userID)
+        if _, err := f.chats.SaveChat(userID, "chat of "+userID); err != nil {
+            t.Fatalf("SaveChat: %v", err)
+        }
+        f.privacy.TrackUser("guild", userID)
+    }
+    // A parked session of another character
+    previous, next, err := f.prompts.CreateCharacter("alice", "Anna")
+    if err != nil {
+        t.Fatalf("CreateCharacter: %v", err)
+    }
+    if err := f.chats.SwitchCharacter("alice", previous, next); err != nil {
+        t.Fatalf("SwitchCharacter: %v", err)
+    }
+    if _, err := f.backups.CreateUserBackup("alice"); err != nil {
+        t.Fatalf("CreateUserBackup: %v", err)
+    }
+    instance, err := f.backups.CreateInstanceBackup("root")
+    if err != nil {
+        t.Fatalf("CreateInstanceBackup: %v", err)
+    }
+
+    report, err := f.privacy.ForgetUser("alice")
+    if err != nil {
+        t.Fatalf("ForgetUser: %v", err)
+    }
+    want := ForgetReport{Prompts: true, Config: true, Session: true, SavedChats: 2, Backups: 1, RewrittenBackups: 1, Guilds: 1}
+    if *report != want {
+        t.Errorf("report = %+v, want %+v", *report, want)
+    }
+
+    t.Run("stores", func(t *testing.T) {
+        if _, err := f.promptStore.Load("alice"); err == nil {
+            t.Error("prompts are still stored")
+        }
+        if _, err := f.configStore.Load("alice"); err == nil {
+            t.Error("settings are still stored")
+        }
+        if _, err := f.sessions.Load("alice"); err == nil {
+            t.Error("the session is still stored")
+        }
+        if chats, err := f.sessions.ListChats("alice"); err != nil || len(chats) != 0 {
+            t.Errorf("saved and parked chats left: %d, %v", len(chats), err)
+        }
+    })
+
+    t.Run("journal", func(t *testing.T) {
+        data, err := os.ReadFile(f.journalPath)
+        if err != nil && !os.IsNotExist(err) {
+            t.Fatalf("reading journal: %v", err)
+        }
+        if strings.Contains(string(data), "message from alice") {
+            t.Error("the journal still holds Alice's messages")
+        }
+    })
+
+    t.Run("backups", func(t *testing.T) {
+        entries, err := os.ReadDir(f.backups.dir)
+        if err != nil {
+            t.Fatalf("ReadDir: %v", err)
+        }
+        if len(entries) != 1 || entries[0].Name() != instance.Manifest.ID+".zip" {
+            t.Fatalf("backups left: %v, want only the instance backup", entries)
+        }
+        archive, err := f.backups.ReadBackup(instance.Manifest.ID)
+        if err != nil {
+            t.Fatalf("ReadBackup: %v", err)
+        }
+        manifest, users, err := readBackupArchive(archive)
+        if err != nil {
+            t.Fatalf("the rewritten instance backup is invalid: %v", err)
+        }
+        if _, ok := users["alice"]; ok || !equalStrings(manifest.UserIDs, []string{"bob"}) {
+            t.Errorf("instance backup users = %v", manifest.UserIDs)
+        }
+        if err := f.backups.verifyManifest(manifest); err != nil {
+            t.Errorf("the rewritten instance backup is not signed: %v", err)
+        }
+    })
+
+    t.Run("retention", func(t *testing.T) {
+        if _, err := f.privacy.SetRetention("guild", "root", 1); err != nil {
+            t.Fatalf("SetRetention: %v", err)
+        }
+        if _, ok := f.privacy.retentionCutoffs(time.Now())["alice"]; ok {
+            t.Error("Alice is still a member of the guild")
+        }
+    })
+
+    t.Run("other users", func(t *testing.T) {
+        if got := f.prompts.GetUserPrompts("bob").Description; got != "secret of bob" {
+            t.Errorf("Bob's description = %q", got)
+        }
+        if got := userTurns(f.chats.GetChatHistory("bob")); !equalStrings(got, []string{"message from bob"}) {
+            t.Errorf("Bob's history = %q", got)
+        }
+        if chats, err := f.chats.ListChats("bob"); err != nil || len(chats) != 1 {
+            t.Errorf("Bob's saved chats = %d, %v", len(chats), err)
+        }
+    })
+}
+
+func TestEnforceRetention(t *testing.T) {
+    now := time.Now()
+    days := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+    history := []Message{
+        {ID: "s", Role: "system", Content: "stack", Timestamp: days(90)},
+        {ID: "1", Role: "user", Content: "60 days", Timestamp: days(60)},
+        {ID: "2", Role: "assistant", Content: "20 days", Timestamp: days(20)},
+        {ID: "3", Role: "user", Content: "5 days", Timestamp: days(5)},
+        {ID: "4", Role: "user", Content: "no timestamp"},
+    }
+
+    tests := []struct {
+        name   string
+        guilds []string
+        idle   bool
+        want   []string
+        chats  int
+    }{
+        {"no policy", nil, false, []string{"stack", "60 days", "20 days", "5 days", "no timestamp"}, 2},
+        {"30 days", []string{"month"}, false, []string{"stack", "20 days", "5 days", "no timestamp"}, 1},
+        {"shortest policy wins", []string{"month", "week"}, false, []string{"stack", "5 days", "no timestamp"}, 0},
+        {"idle session in the store", []string{"week"}, true, []string{"stack", "5 days", "no timestamp"}, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := newPrivacyFixture(t)
+            if _, err := f.privacy.SetRetention("month", "root", 30); err != nil {
+                t.Fatalf("SetRetention: %v", err)
+            }
+            if _, err := f.privacy.SetRetention("week", "root", 7); err != nil {
+                t.Fatalf("SetRetention: %v", err)
+            }
+            for _, guildID := range tt.guilds {
+                f.privacy.TrackUser(guildID, "u")
+            }
+
+            session := &ChatSession{Messages: append([]Message(nil), history...), LastActivity: now}
+            if tt.idle {
+                if err := f.sessions.Save("u", session); err != nil {
+                    t.Fatalf("Save: %v", err)
+                }
+            } else {
+                f.chats.mu.Lock()
+                f.chats.sessions["u"] = session
+                f.chats.mu.Unlock()
+            }
+            for _, created := range []time.Time{days(10), days(60)} {
+                chat := &SavedChat{ID: GenerateID(), OwnerID: "u", Title: "chat", CreatedAt: created}
+                if err := f.sessions.SaveChat(chat); err != nil {
+                    t.Fatalf("SaveChat: %v", err)
+                }
+            }
+
+            report, err := f.privacy.EnforceRetention()
+            if err != nil {
+                t.Fatalf("EnforceRetention: %v", err)
+            }
+            pruned := len(history) - len(tt.want)
+            if report.Messages != pruned || report.SavedChats != 2-tt.chats {
+                t.Errorf("report = %+v, want %d messages and %d saved chats", *report, pruned, 2-tt.chats)
+            }
+
+            var got []string
+            if tt.idle {
+                stored, err := f.sessions.Load("u")
+                if err != nil {
+                    t.Fatalf("Load: %v", err)
+                }
+                got = describeContents(stored.Messages)
+                f.chats.mu.RLock()
+                _, loaded := f.chats.sessions["u"]
+                f.chats.mu.RUnlock()
+                if loaded {
+                    t.Error("pruning pulled the idle session into memory")
+                }
+            } else {
+                got = describeContents(f.chats.GetChatHistory("u"))
+            }
+            if !equalStrings(got, tt.want) {
+                t.Errorf("history = %q, want %q", got, tt.want)
+            }
+            if chats, err := f.chats.ListChats("u"); err != nil || len(chats) != tt.chats {
+                t.Errorf("saved chats = %d, %v, want %d", len(chats), err, tt.chats)
+            }
+        })
+    }
+}
+
+func TestStartRetention(t *testing.T) {
+    f := newPrivacyFixture(t)
+    if _, err := f.privacy.SetRetention("guild", "root", 1); err != nil {
+        t.Fatalf("SetRetention: %v", err)
+    }
+    f.privacy.TrackUser("guild", "u")
+    old := &SavedChat{ID: "old", OwnerID: "u", Title: "old", CreatedAt: time.Now().Add(-48 * time.Hour)}
+    if err := f.sessions.SaveChat(old); err != nil {
+        t.Fatalf("SaveChat: %v", err)
+    }
+
+    f.privacy.StartRetention(5 * time.Millisecond)
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        if _, err := f.sessions.LoadChat("old"); err == ErrChatNotFound {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("the retention job did not prune the old chat")
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    f.privacy.StopRetention()
+    // Stopping twice is harmless
+    f.privacy.StopRetention()
+}
+
+// describeContents lists the contents of messages.
+func describeContents(messages []Message) []string {
+    contents := make([]string, len(messages))
+    for i, msg := range messages {
+        contents[i] = msg.Content
+    }
+    return contents
+}
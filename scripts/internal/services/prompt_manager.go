@@ -2,40 +2,114 @@ package services
 
 import (
     "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
     "sync"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/cards"
+)
+
+// Author's note placement. The note is injected AuthorsNoteDepth messages
+// from the end of the history, so it stays close to the latest reply.
+const (
+    DefaultAuthorsNoteDepth = 4
+    MaxAuthorsNoteDepth     = 100
 )
 
+var ErrInvalidPlacement = fmt.Errorf("depth must be between 0 and %d and role one of system, user or assistant", MaxAuthorsNoteDepth)
+
 type PromptManager struct {
     prompts    map[string]*UserPrompts
+    presence   map[string]*userPresence
+    store      PromptStore
+    // contextTokens is the estimated size prepared contexts are kept to
+    // by dropping example dialogue.
+    contextTokens int
+    // guilds holds the guild post-history instructions, if set.
+    guilds     *GuildManager
     mu         sync.RWMutex
 }
 
+// Character holds the definitions of one character.
+type Character struct {
+    ID              string
+    Name            string
+    Description     string
+    Personality     string
+    Scenario        string
+    FirstMessage    string
+    // AlternateGreetings can be picked instead of FirstMessage when a chat
+    // starts.
+    AlternateGreetings []string
+    ExampleDialogue string
+    // PostHistoryInstructions are sent after the latest message of the
+    // chat.
+    PostHistoryInstructions string
+    // SharedFrom is the origin of a copy of a guild's shared character,
+    // and SharedVersion the version copied.
+    SharedFrom      string
+    SharedVersion   int
+}
+
+// UserPrompts embeds the user's active character. The rest of their
+// character library is kept in Characters.
 type UserPrompts struct {
-    Description    string
-    Personality   string
-    Scenario      string
-    FirstMessage  string
+    Character
     AuthorsNote   string
+    AuthorsNoteDepth int
+    AuthorsNoteRole  string
+    // UserPersona is sent when no persona applies.
     UserPersona   string
     UserToken     string
-    SystemPrompts []string
+    // Prompts is the ordered prompt stack sent before the chat.
+    Prompts       []PromptEntry
+    Characters    []Character
+    Lorebook      Lorebook
+    Group         GroupChat
+    Personas      []Persona
+    // PersonaID is the active persona, empty for none.
+    PersonaID     string
 }
 
-func NewPromptManager() *PromptManager {
+// NewPromptManager creates a prompt manager backed by store. A nil store
+// keeps prompts in memory only.
+func NewPromptManager(store PromptStore) *PromptManager {
+    if store == nil {
+        store = NewMemoryPromptStore()
+    }
     return &PromptManager{
-        prompts: make(map[string]*UserPrompts),
+        prompts:  make(map[string]*UserPrompts),
+        presence: make(map[string]*userPresence),
+        store:    store,
+        contextTokens: DefaultContextTokens,
     }
 }
 
+func (p *UserPrompts) clone() *UserPrompts {
+    copied := *p
+    copied.Prompts = append([]PromptEntry(nil), p.Prompts...)
+    copied.AlternateGreetings = append([]string(nil), p.AlternateGreetings...)
+    copied.Characters = append([]Character(nil), p.Characters...)
+    for i := range copied.Characters {
+        copied.Characters[i].AlternateGreetings = append([]string(nil), p.Characters[i].AlternateGreetings...)
+    }
+    copied.Lorebook = p.Lorebook.clone()
+    copied.Group.Members = append([]string(nil), p.Group.Members...)
+    copied.Personas = make([]Persona, len(p.Personas))
+    for i, persona := range p.Personas {
+        copied.Personas[i] = persona.clone()
+    }
+    return &copied
+}
+
 func (pm *PromptManager) GetUserPrompts(userID string) *UserPrompts {
-    pm.mu.RLock()
-    defer pm.mu.RUnlock()
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
 
-    prompts, exists := pm.prompts[userID]
-    if !exists {
-        return pm.createDefaultPrompts()
-    }
-    return prompts
+    return pm.getOrCreatePrompts(userID)
 }
 
 func (pm *PromptManager) UpdateDefinitions(userID string, definitions map[string]string) {
@@ -53,14 +127,28 @@ func (pm *PromptManager) UpdateDefinitions(userID string, definitions map[string
     if scen, ok := definitions["scenario"]; ok {
         prompts.Scenario = scen
     }
+    if examples, ok := definitions["example_dialogue"]; ok {
+        prompts.ExampleDialogue = examples
+    }
+    pm.persist(userID)
 }
 
+// SetUserPersona sets the description of the active persona, or the user
+// persona sent when no persona is active.
 func (pm *PromptManager) SetUserPersona(userID, persona string) {
     pm.mu.Lock()
     defer pm.mu.Unlock()
 
     prompts := pm.getOrCreatePrompts(userID)
+    for i := range prompts.Personas {
+        if prompts.Personas[i].ID == prompts.PersonaID {
+            prompts.Personas[i].Description = persona
+            pm.persist(userID)
+            return
+        }
+    }
     prompts.UserPersona = persona
+    pm.persist(userID)
 }
 
 func (pm *PromptManager) SetUserToken(userID, token string) {
@@ -69,6 +157,7 @@ func (pm *PromptManager) SetUserToken(userID, token string) {
 
     prompts := pm.getOrCreatePrompts(userID)
     prompts.UserToken = token
+    pm.persist(userID)
 }
 
 func (pm *PromptManager) SetFirstMessage(userID, message string) {
@@ -77,6 +166,27 @@ func (pm *PromptManager) SetFirstMessage(userID, message string) {
 
     prompts := pm.getOrCreatePrompts(userID)
     prompts.FirstMessage = message
+    pm.persist(userID)
+}
+
+// SetPostHistoryInstructions sets the post-history instructions of the
+// user's active character. Empty instructions clear them.
+func (pm *PromptManager) SetPostHistoryInstructions(userID, instructions string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    prompts.PostHistoryInstructions = strings.TrimSpace(instructions)
+    pm.persist(userID)
+}
+
+// UseGuilds makes prepared contexts follow the post-history instructions
+// of the guild the user last wrote in.
+func (pm *PromptManager) UseGuilds(guilds *GuildManager) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    pm.guilds = guilds
 }
 
 func (pm *PromptManager) SetAuthorsNote(userID, note string) {
@@ -85,67 +195,314 @@ func (pm *PromptManager) SetAuthorsNote(userID, note string) {
 
     prompts := pm.getOrCreatePrompts(userID)
     prompts.AuthorsNote = note
+    pm.persist(userID)
 }
 
-func (pm *PromptManager) AddSystemPrompt(userID, prompt string) {
+// SetAuthorsNotePlacement sets how deep in the history the author's note
+// is injected and which role it is sent as.
+func (pm *PromptManager) SetAuthorsNotePlacement(userID string, depth int, role string) error {
+    if depth < 0 || depth > MaxAuthorsNoteDepth || !isChatRole(role) {
+        return ErrInvalidPlacement
+    }
+
     pm.mu.Lock()
     defer pm.mu.Unlock()
 
     prompts := pm.getOrCreatePrompts(userID)
-    prompts.SystemPrompts = append(prompts.SystemPrompts, prompt)
+    prompts.AuthorsNoteDepth = depth
+    prompts.AuthorsNoteRole = role
+    pm.persist(userID)
+    return nil
+}
+
+// AddSystemPrompt appends a system message to the user's prompt stack.
+func (pm *PromptManager) AddSystemPrompt(userID, prompt string) {
+    if _, err := pm.AddPrompt(userID, "system", prompt, 0); err != nil {
+        log.Printf("Error adding system prompt for %s: %v", userID, err)
+    }
 }
 
 func (pm *PromptManager) BuildPromptList(userID string) []Message {
-    pm.mu.RLock()
-    prompts := pm.getOrCreatePrompts(userID)
-    pm.mu.RUnlock()
+    return pm.BuildPromptListWithGreeting(userID, 0)
+}
 
-    var messages []Message
+// BuildPromptListWithGreeting builds the opening of a chat that starts
+// with greeting (0-based, see Character.Greetings). Group chats open
+// without a greeting.
+func (pm *PromptManager) BuildPromptListWithGreeting(userID string, greeting int) []Message {
+    pm.mu.Lock()
+    prompts := pm.getOrCreatePrompts(userID).clone()
+    prompts.applyPersona(pm.channelOf(userID))
+    ctx := pm.macroContext(userID, prompts)
+    pm.mu.Unlock()
 
-    // Add system prompts in order
-    if prompts.Description != "" {
-        messages = append(messages, Message{
-            Role:    "system",
-            Content: "Character Description: " + prompts.Description,
-        })
+    // Add the prompt stack in order
+    messages := prompts.stackMessages(ctx)
+
+    if prompts.Group.Active() {
+        return messages
+    }
+    if message, ok := pm.greetingMessage(userID, greeting); ok {
+        messages = append(messages, message)
     }
 
-    if prompts.Personality != "" {
-        messages = append(messages, Message{
-            Role:    "system",
-            Content: "Personality: " + prompts.Personality,
-        })
+    return messages
+}
+
+// PrepareContext returns the messages to send for the user's next reply: a
+// copy of history with the triggered lorebook entries, the author's note,
+// the post-history instructions and the example dialogue injected, macros
+// expanded. It runs on every
+// request, so injections keep their distance from the end as the chat
+// grows and example dialogue gives way as it fills the context budget.
+func (pm *PromptManager) PrepareContext(userID string, history []Message) []Message {
+    return pm.prepareContext(userID, history, nil)
+}
+
+// prepareContext prepares the context as speaker when one is given, and
+// as the active character otherwise.
+func (pm *PromptManager) prepareContext(userID string, history []Message, speaker *Character) []Message {
+    pm.mu.Lock()
+    prompts := pm.getOrCreatePrompts(userID).clone()
+    if speaker != nil {
+        prompts.Character = *speaker
     }
+    ctx := pm.macroContext(userID, prompts)
+    budget := pm.contextTokens
+    guildID := ""
+    if presence, exists := pm.presence[userID]; exists {
+        guildID = presence.GuildID
+    }
+    guilds := pm.guilds
+    pm.mu.Unlock()
 
-    if prompts.Scenario != "" {
-        messages = append(messages, Message{
-            Role:    "system",
-            Content: "Scenario: " + prompts.Scenario,
-        })
+    messages := append([]Message(nil), history...)
+
+    var before, after []string
+    var injections []depthInjection
+    for _, lore := range activateLore(prompts.Lorebook, history, ctx) {
+        switch lore.Entry.Position {
+        case LorePositionBefore:
+            before = append(before, lore.Content)
+        case LorePositionAfter:
+            after = append(after, lore.Content)
+        default:
+            injections = append(injections, depthInjection{
+                Depth:   lore.Entry.Depth,
+                Message: Message{Role: "system", Content: lore.Content, Section: SectionLorebook},
+            })
+        }
     }
 
-    if prompts.UserPersona != "" {
-        messages = append(messages, Message{
-            Role:    "system",
-            Content: "User Persona: " + prompts.UserPersona,
-        })
+    // Entries placed around the definitions go in first, so the depth
+    // injections below still count from the end of the chat.
+    if len(after) > 0 {
+        messages = insertMessage(messages, leadingSystem(messages),
+            Message{Role: "system", Content: strings.Join(after, "\n"), Section: SectionLorebook})
+    }
+    if len(before) > 0 {
+        messages = insertMessage(messages, 0, Message{Role: "system", Content: strings.Join(before, "\n"), Section: SectionLorebook})
     }
 
-    if prompts.FirstMessage != "" {
-        messages = append(messages, Message{
-            Role:    "assistant",
-            Content: prompts.FirstMessage,
+    if prompts.AuthorsNote != "" {
+        role := prompts.AuthorsNoteRole
+        if role == "" {
+            role = "system"
+        }
+        injections = append(injections, depthInjection{
+            Depth:   prompts.AuthorsNoteDepth,
+            Message: Message{Role: role, Content: ExpandMacros(prompts.AuthorsNote, ctx), Section: SectionAuthorsNote},
         })
     }
 
+    // Deepest first, so each insertion leaves the later depths intact.
+    sort.SliceStable(injections, func(i, j int) bool {
+        return injections[i].Depth > injections[j].Depth
+    })
+    for _, injection := range injections {
+        messages = injectAtDepth(messages, injection.Message, injection.Depth)
+    }
+
+    fallback, override := "", ""
+    if guilds != nil && guildID != "" {
+        fallback, override = guilds.PostHistoryInstructions(guildID)
+    }
+    if instructions := postHistoryInstructions(prompts.PostHistoryInstructions, fallback, override, ctx); instructions != "" {
+        messages = insertMessage(messages, postHistoryIndex(messages),
+            Message{Role: "system", Content: instructions, Section: SectionPostHistory})
+    }
+
+    // Example dialogue goes between the definitions and the chat, and only
+    // as much of it as still fits.
+    if prompts.ExampleDialogue != "" && prompts.blockEnabled(BlockExampleDialogue) {
+        blocks := ParseExampleDialogue(prompts.ExampleDialogue, ctx)
+        if examples := fitExamples(blocks, messages, budget); len(examples) > 0 {
+            lead := leadingSystem(messages)
+            result := make([]Message, 0, len(messages)+len(examples))
+            result = append(result, messages[:lead]...)
+            result = append(result, examples...)
+            messages = append(result, messages[lead:]...)
+        }
+    }
     return messages
 }
 
-func (pm *PromptManager) ExportPrompts(userID string) ([]byte, error) {
-    pm.mu.RLock()
+// postHistoryInstructions picks the instructions to send after the chat:
+// the guild override, else the character's own, else the guild default.
+// The override can include the character's own with {{original}}.
+func postHistoryInstructions(own, fallback, override string, ctx MacroContext) string {
+    switch {
+    case override != "":
+        ctx.Original = ExpandMacros(own, ctx)
+        return ExpandMacros(override, ctx)
+    case own != "":
+        return ExpandMacros(own, ctx)
+    }
+    return ExpandMacros(fallback, ctx)
+}
+
+// postHistoryIndex is where post-history instructions go: at the end, or
+// before the assistant messages that end the chat when continuing a reply.
+func postHistoryIndex(messages []Message) int {
+    lead := leadingSystem(messages)
+    index := len(messages)
+    for index > lead && messages[index-1].Role == "assistant" {
+        index--
+    }
+    return index
+}
+
+// depthInjection is a message to insert Depth messages from the end.
+type depthInjection struct {
+    Depth   int
+    Message Message
+}
+
+// injectAtDepth inserts msg depth messages from the end of messages, but
+// never above the leading system prompts.
+func injectAtDepth(messages []Message, msg Message, depth int) []Message {
+    lead := leadingSystem(messages)
+    index := len(messages) - depth
+    if index < lead {
+        index = lead
+    }
+    return insertMessage(messages, index, msg)
+}
+
+// leadingSystem counts the system messages at the start of messages.
+func leadingSystem(messages []Message) int {
+    lead := 0
+    for lead < len(messages) && messages[lead].Role == "system" {
+        lead++
+    }
+    return lead
+}
+
+func insertMessage(messages []Message, index int, msg Message) []Message {
+    result := make([]Message, 0, len(messages)+1)
+    result = append(result, messages[:index]...)
+    result = append(result, msg)
+    return append(result, messages[index:]...)
+}
+
+func isChatRole(role string) bool {
+    return role == "system" || role == "user" || role == "assistant"
+}
+
+// ImportCharacter adds the character from card to the user's library and
+// makes it active, as CreateCharacter does. Persona, token, author's note
+// and prompt stack are kept. It returns the character that was active
+// before, the imported one and the card fields that could not be mapped.
+func (pm *PromptManager) ImportCharacter(userID string, card *cards.Card) (Character, Character, []string) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
     prompts := pm.getOrCreatePrompts(userID)
-    pm.mu.RUnlock()
+    previous := prompts.stashActive()
+
+    base := strings.TrimSpace(card.Data.Name)
+    if base == "" {
+        base = unnamedCharacter
+    }
+    if runes := []rune(base); len(runes) > maxCharacterName-3 {
+        // Leave room for the number that keeps the name unique
+        base = strings.TrimSpace(string(runes[:maxCharacterName-3]))
+    }
+    name := base
+    for n := 2; prompts.findCharacter(name) >= 0; n++ {
+        // Keep names unique within the user's character library
+        name = fmt.Sprintf("%s %d", base, n)
+    }
+    prompts.Character = Character{
+        ID:                      GenerateID(),
+        Name:                    name,
+        Description:             card.Data.Description,
+        Personality:             card.Data.Personality,
+        Scenario:                card.Data.Scenario,
+        FirstMessage:            card.Data.FirstMes,
+        AlternateGreetings:      append([]string(nil), card.Data.AlternateGreetings...),
+        ExampleDialogue:         card.Data.MesExample,
+        PostHistoryInstructions: card.Data.PostHistoryInstructions,
+    }
+    pm.persist(userID)
+
+    return previous, prompts.Character, unmappedCardFields(card)
+}
+
+// ExportCharacter returns the user's character definitions as a V2 card.
+func (pm *PromptManager) ExportCharacter(userID string) *cards.Card {
+    pm.mu.Lock()
+    prompts := pm.getOrCreatePrompts(userID).clone()
+    pm.mu.Unlock()
+
+    name := prompts.Name
+    if name == "" {
+        name = unnamedCharacter
+    }
+    return cards.NewCard(cards.Data{
+        Name:        name,
+        Description: prompts.Description,
+        Personality: prompts.Personality,
+        Scenario:    prompts.Scenario,
+        FirstMes:    prompts.FirstMessage,
+        AlternateGreetings: prompts.AlternateGreetings,
+        MesExample:  prompts.ExampleDialogue,
+        PostHistoryInstructions: prompts.PostHistoryInstructions,
+    })
+}
+
+// unmappedCardFields lists the non-empty card fields ImportCharacter has no
+// place for.
+func unmappedCardFields(card *cards.Card) []string {
+    data := card.Data
+    var unmapped []string
+    for _, field := range []struct {
+        name string
+        set  bool
+    }{
+        {"creator_notes", data.CreatorNotes != ""},
+        {"system_prompt", data.SystemPrompt != ""},
+        {"character_book", len(data.CharacterBook) > 0 && string(data.CharacterBook) != "null"},
+        {"tags", len(data.Tags) > 0},
+        {"creator", data.Creator != ""},
+        {"character_version", data.CharacterVersion != ""},
+        {"extensions", len(data.Extensions) > 0},
+    } {
+        if field.set {
+            unmapped = append(unmapped, field.name)
+        }
+    }
+    return append(unmapped, card.Unknown...)
+}
+
+// ExportPrompts returns the user's prompts as JSON. The user token is left
+// out so exports can be shared safely.
+func (pm *PromptManager) ExportPrompts(userID string) ([]byte, error) {
+    pm.mu.Lock()
+    prompts := pm.getOrCreatePrompts(userID).clone()
+    pm.mu.Unlock()
 
+    prompts.UserToken = ""
     return json.MarshalIndent(prompts, "", "  ")
 }
 
@@ -156,23 +513,113 @@ func (pm *PromptManager) ImportPrompts(userID string, data []byte) error {
     }
 
     pm.mu.Lock()
+    if prompts.UserToken == "" {
+        // Exports never carry the token, so keep the one already set
+        prompts.UserToken = pm.getOrCreatePrompts(userID).UserToken
+    }
+    prompts.normalizeStack()
     pm.prompts[userID] = &prompts
+    pm.persist(userID)
     pm.mu.Unlock()
 
     return nil
 }
 
+// SetUserPrompts replaces every prompt definition for userID.
+func (pm *PromptManager) SetUserPrompts(userID string, prompts *UserPrompts) error {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    if err := pm.store.Save(userID, prompts); err != nil {
+        return err
+    }
+    pm.prompts[userID] = prompts.clone()
+    pm.prompts[userID].normalizeStack()
+    return nil
+}
+
+// DeleteUser removes the prompts stored for userID and reports whether
+// there were any.
+func (pm *PromptManager) DeleteUser(userID string) (bool, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    _, existed := pm.prompts[userID]
+    if _, err := pm.store.Load(userID); !errors.Is(err, ErrPromptsNotFound) {
+        existed = true
+    }
+    if err := pm.store.Delete(userID); err != nil {
+        return existed, err
+    }
+    delete(pm.prompts, userID)
+    delete(pm.presence, userID)
+    return existed, nil
+}
+
+// UserIDs returns every user with prompts in memory or in the store.
+func (pm *PromptManager) UserIDs() ([]string, error) {
+    pm.mu.RLock()
+    defer pm.mu.RUnlock()
+
+    stored, err := pm.store.List()
+    if err != nil {
+        return nil, err
+    }
+    return mergeUserIDs(stored, pm.prompts), nil
+}
+
+// snapshot returns a copy of the prompts for userID that is safe to use
+// without holding the lock.
+func (pm *PromptManager) snapshot(userID string) *UserPrompts {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    return pm.getOrCreatePrompts(userID).clone()
+}
+
+// getOrCreatePrompts returns the prompts for userID, loading them from the
+// store the first time they are needed. Callers must hold pm.mu.
 func (pm *PromptManager) getOrCreatePrompts(userID string) *UserPrompts {
     prompts, exists := pm.prompts[userID]
-    if !exists {
+    if exists {
+        return prompts
+    }
+
+    prompts, err := pm.store.Load(userID)
+    if err != nil {
+        if !errors.Is(err, ErrPromptsNotFound) {
+            log.Printf("Error loading prompts for %s: %v", userID, err)
+        }
         prompts = pm.createDefaultPrompts()
-        pm.prompts[userID] = prompts
     }
+    prompts.normalizeStack()
+    pm.prompts[userID] = prompts
     return prompts
 }
 
+// persist flushes the prompts for userID to the store. Callers must hold
+// pm.mu.
+func (pm *PromptManager) persist(userID string) {
+    prompts, exists := pm.prompts[userID]
+    if !exists {
+        return
+    }
+    if err := pm.store.Save(userID, prompts); err != nil {
+        log.Printf("Error saving prompts for %s: %v", userID, err)
+    }
+}
+
+// createDefaultPrompts returns the prompts of a new user. Their character
+// is empty until they define one, so the stack sends the defaults, see
+// usesDefaults.
 func (pm *PromptManager) createDefaultPrompts() *UserPrompts {
     return &UserPrompts{
-        SystemPrompts: make([]string, 0),
+        AuthorsNoteDepth: DefaultAuthorsNoteDepth,
+        AuthorsNoteRole:  "system",
+        Prompts:          defaultStack(),
+        Lorebook: Lorebook{
+            ScanDepth:   DefaultLoreScanDepth,
+            TokenBudget: DefaultLoreTokenBudget,
+        },
     }
 }
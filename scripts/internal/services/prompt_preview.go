@@ -0,0 +1,67 @@
+package services
+
+// Sections of a prepared context, as reported by PreviewRequest.
+const (
+    SectionStack       = "prompt stack"
+    SectionExamples    = "example dialogue"
+    SectionLorebook    = "lorebook"
+    SectionHistory     = "chat history"
+    SectionAuthorsNote = "author's note"
+    SectionPostHistory = "post-history instructions"
+    SectionGroupTurn   = "group turn"
+)
+
+// PromptPreview is the request GenerateResponse would send next, with the
+// estimated tokens of each part of it.
+type PromptPreview struct {
+    Speaker     string                 `json:"speaker,omitempty"`
+    Payload     map[string]interface{} `json:"payload"`
+    Sections    []PromptSection        `json:"sections"`
+    TotalTokens int                    `json:"total_tokens"`
+    Messages    []Message              `json:"-"`
+}
+
+// PromptSection sums the messages of one section of a preview.
+type PromptSection struct {
+    Name     string `json:"name"`
+    Messages int    `json:"messages"`
+    Tokens   int    `json:"tokens"`
+}
+
+// PreviewRequest prepares the next reply's messages the same way
+// GenerateResponseAs does, without calling the backend or taking a group
+// chat's turn. Fill in the Payload with ProxyClient.Payload.
+func (cm *ChatManager) PreviewRequest(userID, name string) (*PromptPreview, error) {
+    cm.mu.Lock()
+    session := cm.getOrCreateSession(userID)
+    lead := leadingSystem(session.Messages)
+    history := make([]Message, len(session.Messages))
+    for i, msg := range session.Messages {
+        msg.Section = SectionHistory
+        if i < lead {
+            msg.Section = SectionStack
+        }
+        history[i] = msg
+    }
+    messages, speaker, err := cm.prepareRequest(userID, name, history, false)
+    cm.mu.Unlock()
+    if err != nil {
+        return nil, err
+    }
+
+    preview := &PromptPreview{Speaker: speaker, Messages: messages}
+    index := make(map[string]int)
+    for _, msg := range messages {
+        n, exists := index[msg.Section]
+        if !exists {
+            n = len(preview.Sections)
+            index[msg.Section] = n
+            preview.Sections = append(preview.Sections, PromptSection{Name: msg.Section})
+        }
+        tokens := EstimateTokens(msg.Content)
+        preview.Sections[n].Messages++
+        preview.Sections[n].Tokens += tokens
+        preview.TotalTokens += tokens
+    }
+    return preview, nil
+}
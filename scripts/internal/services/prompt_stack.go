@@ -0,0 +1,314 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/templates"
+)
+
+// Built-in blocks of the prompt stack. Their content comes from the active
+// character and the user's persona rather than from the entry itself. The
+// example dialogue block only switches the example turns on and off; they
+// are always sent right after the definitions. The system prompt block is
+// the default system prompt, sent only while the character has no
+// definitions (see usesDefaults).
+const (
+    BlockSystemPrompt    = "system_prompt"
+    BlockName            = "name"
+    BlockDescription     = "description"
+    BlockPersonality     = "personality"
+    BlockScenario        = "scenario"
+    BlockExampleDialogue = "example_dialogue"
+    BlockPersona         = "persona"
+)
+
+// defaultBlocks is the order the built-in blocks had before the stack was
+// editable.
+var defaultBlocks = []string{
+    BlockSystemPrompt, BlockName, BlockDescription, BlockPersonality, BlockScenario, BlockExampleDialogue, BlockPersona,
+}
+
+// blockLabels are the prefixes the blocks are sent with.
+var blockLabels = map[string]string{
+    BlockSystemPrompt:    "",
+    BlockName:            "Character Name: ",
+    BlockDescription:     "Character Description: ",
+    BlockPersonality:     "Personality: ",
+    BlockScenario:        "Scenario: ",
+    BlockExampleDialogue: "Example Dialogue:\n",
+    BlockPersona:         "User Persona: ",
+}
+
+const maxPromptEntries = 50
+
+var (
+    ErrPromptNotFound  = errors.New("prompt entry not found")
+    ErrPromptBuiltin   = errors.New("built-in blocks cannot be removed or rewritten, disable them instead")
+    ErrPromptStackFull = fmt.Errorf("prompt stacks are limited to %d entries", maxPromptEntries)
+    ErrPromptEmpty     = errors.New("prompt content cannot be empty")
+    ErrPromptRole      = errors.New("role must be system, user or assistant")
+)
+
+// PromptEntry is one entry of a user's prompt stack: either a built-in
+// block or a message of their own.
+type PromptEntry struct {
+    ID      string
+    Role    string
+    Content string
+    // Block names the built-in block this entry stands for, if any.
+    Block   string
+    Enabled bool
+}
+
+// Label describes the entry in listings.
+func (e PromptEntry) Label() string {
+    if e.Block == BlockSystemPrompt {
+        return "Default system prompt"
+    }
+    if e.Block != "" {
+        return strings.TrimRight(blockLabels[e.Block], ": \n")
+    }
+    return e.Content
+}
+
+// defaultStack returns the built-in blocks in their default order.
+func defaultStack() []PromptEntry {
+    stack := make([]PromptEntry, 0, len(defaultBlocks))
+    for _, block := range defaultBlocks {
+        stack = append(stack, PromptEntry{ID: block, Role: "system", Block: block, Enabled: true})
+    }
+    return stack
+}
+
+// normalizeStack makes sure every built-in block is in the stack exactly
+// once. Missing blocks are put in front in their default order, where they
+// used to be.
+func (p *UserPrompts) normalizeStack() {
+    present := make(map[string]bool)
+    var stack []PromptEntry
+    for _, entry := range p.Prompts {
+        if entry.Block != "" {
+            if _, known := blockLabels[entry.Block]; !known || present[entry.Block] {
+                continue
+            }
+            present[entry.Block] = true
+            entry.ID = entry.Block
+        }
+        stack = append(stack, entry)
+    }
+
+    var missing []PromptEntry
+    for _, entry := range defaultStack() {
+        if !present[entry.Block] {
+            missing = append(missing, entry)
+        }
+    }
+    p.Prompts = append(missing, stack...)
+}
+
+// PromptStack returns the user's prompt stack in order.
+func (pm *PromptManager) PromptStack(userID string) []PromptEntry {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    return append([]PromptEntry(nil), pm.getOrCreatePrompts(userID).Prompts...)
+}
+
+// AddPrompt inserts a message of role at position (1-based) in the user's
+// prompt stack, or at the end when position is 0.
+func (pm *PromptManager) AddPrompt(userID, role, content string, position int) (PromptEntry, error) {
+    if !isChatRole(role) {
+        return PromptEntry{}, ErrPromptRole
+    }
+    if strings.TrimSpace(content) == "" {
+        return PromptEntry{}, ErrPromptEmpty
+    }
+
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    if len(prompts.Prompts) >= maxPromptEntries {
+        return PromptEntry{}, ErrPromptStackFull
+    }
+    entry := PromptEntry{ID: GenerateID(), Role: role, Content: content, Enabled: true}
+    index := clampPosition(position, len(prompts.Prompts)+1)
+    prompts.Prompts = append(prompts.Prompts[:index], append([]PromptEntry{entry}, prompts.Prompts[index:]...)...)
+    pm.persist(userID)
+    return entry, nil
+}
+
+// MovePrompt moves an entry to position (1-based) in the user's stack.
+func (pm *PromptManager) MovePrompt(userID, id string, position int) (PromptEntry, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    from := prompts.findPrompt(id)
+    if from < 0 {
+        return PromptEntry{}, ErrPromptNotFound
+    }
+    entry := prompts.Prompts[from]
+    rest := append(prompts.Prompts[:from:from], prompts.Prompts[from+1:]...)
+    index := clampPosition(position, len(rest)+1)
+    prompts.Prompts = append(rest[:index:index], append([]PromptEntry{entry}, rest[index:]...)...)
+    pm.persist(userID)
+    return entry, nil
+}
+
+// EditPrompt changes an entry of the user's stack. Nil arguments are left
+// alone. Built-in blocks can only be enabled or disabled.
+func (pm *PromptManager) EditPrompt(userID, id string, role, content *string, enabled *bool) (PromptEntry, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    index := prompts.findPrompt(id)
+    if index < 0 {
+        return PromptEntry{}, ErrPromptNotFound
+    }
+    entry := prompts.Prompts[index]
+    if entry.Block != "" && (role != nil || content != nil) {
+        return PromptEntry{}, ErrPromptBuiltin
+    }
+    if role != nil {
+        if !isChatRole(*role) {
+            return PromptEntry{}, ErrPromptRole
+        }
+        entry.Role = *role
+    }
+    if content != nil {
+        if strings.TrimSpace(*content) == "" {
+            return PromptEntry{}, ErrPromptEmpty
+        }
+        entry.Content = *content
+    }
+    if enabled != nil {
+        entry.Enabled = *enabled
+    }
+    prompts.Prompts[index] = entry
+    pm.persist(userID)
+    return entry, nil
+}
+
+// RemovePrompt deletes one of the user's own entries from their stack.
+func (pm *PromptManager) RemovePrompt(userID, id string) (PromptEntry, error) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    prompts := pm.getOrCreatePrompts(userID)
+    index := prompts.findPrompt(id)
+    if index < 0 {
+        return PromptEntry{}, ErrPromptNotFound
+    }
+    entry := prompts.Prompts[index]
+    if entry.Block != "" {
+        return PromptEntry{}, ErrPromptBuiltin
+    }
+    prompts.Prompts = append(prompts.Prompts[:index], prompts.Prompts[index+1:]...)
+    pm.persist(userID)
+    return entry, nil
+}
+
+// stackMessages turns the enabled entries of the stack into messages.
+// Blocks whose source field is empty are skipped. In a group chat the name
+// block stands for the definitions of every member. A character without
+// definitions is sent as the default assistant, see usesDefaults.
+func (p *UserPrompts) stackMessages(ctx MacroContext) []Message {
+    var messages []Message
+    for _, entry := range p.Prompts {
+        if !entry.Enabled {
+            continue
+        }
+        if p.Group.Active() {
+            switch entry.Block {
+            case BlockName:
+                messages = append(messages, p.groupStack(ctx)...)
+                continue
+            case BlockDescription, BlockPersonality:
+                continue
+            }
+        }
+        if entry.Block == "" {
+            messages = append(messages, Message{Role: entry.Role, Content: ExpandMacros(entry.Content, ctx)})
+            continue
+        }
+        if entry.Block == BlockExampleDialogue {
+            // Sent as example turns by PrepareContext
+            continue
+        }
+
+        content := p.blockContent(entry.Block)
+        if content == "" {
+            continue
+        }
+        if entry.Block != BlockName {
+            content = ExpandMacros(content, ctx)
+        }
+        messages = append(messages, Message{Role: "system", Content: blockLabels[entry.Block] + content})
+    }
+    return messages
+}
+
+func (p *UserPrompts) blockContent(block string) string {
+    switch {
+    case block == BlockPersona:
+        return p.UserPersona
+    case block == BlockSystemPrompt && p.usesDefaults():
+        return templates.GetDefaultSystemPrompt()
+    case block == BlockPersonality && p.usesDefaults():
+        return templates.GetDefaultPersonality()
+    }
+    return p.Character.blockContent(block)
+}
+
+// usesDefaults reports whether the active character has no definitions of
+// its own, in which case the system prompt and personality blocks send the
+// defaults. They are applied when the stack is built and never saved, so
+// filling in the character replaces them. The scenario does not count, so
+// a /scenario preset keeps the defaults.
+func (p *UserPrompts) usesDefaults() bool {
+    c := p.Character
+    return !p.Group.Active() && c.Name == "" && c.Description == "" && c.Personality == "" &&
+        c.ExampleDialogue == "" && c.FirstMessage == "" && len(c.AlternateGreetings) == 0
+}
+
+// blockContent returns the character field a built-in block is made of.
+func (c Character) blockContent(block string) string {
+    switch block {
+    case BlockName:
+        return c.Name
+    case BlockDescription:
+        return c.Description
+    case BlockPersonality:
+        return c.Personality
+    case BlockScenario:
+        return c.Scenario
+    case BlockExampleDialogue:
+        return c.ExampleDialogue
+    }
+    return ""
+}
+
+// findPrompt returns the index of the entry with the given ID or block
+// name, or -1.
+func (p *UserPrompts) findPrompt(id string) int {
+    id = strings.TrimSpace(id)
+    for i, entry := range p.Prompts {
+        if entry.ID == id || (entry.Block != "" && strings.EqualFold(entry.Block, id)) {
+            return i
+        }
+    }
+    return -1
+}
+
+// clampPosition turns a 1-based position into an index into a list of
+// size slots, where 0 or anything too large means the end.
+func clampPosition(position, size int) int {
+    if position < 1 || position > size {
+        return size - 1
+    }
+    return position - 1
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+    "testing"
+
+    "github.com/beermanpartytime/discord-chatbot/scripts/internal/templates"
+)
+
+// stackIDs lists the IDs of a stack in order.
+func stackIDs(stack []PromptEntry) []string {
+    ids := make([]string, len(stack))
+    for i, entry := range stack {
+        ids[i] = entry.ID
+    }
+    return ids
+}
+
+func TestNormalizeStack(t *testing.T) {
+    own := PromptEntry{ID: "own", Role: "system", Content: "Be brief", Enabled: true}
+    block := func(name, id string) PromptEntry {
+        return PromptEntry{ID: id, Role: "system", Block: name, Enabled: true}
+    }
+    tests := []struct {
+        name  string
+        stack []PromptEntry
+        want  []string
+    }{
+        {
+            name:  "empty stack gets every block",
+            stack: nil,
+            want:  defaultBlocks,
+        },
+        {
+            name:  "own entries stay after the missing blocks",
+            stack: []PromptEntry{own},
+            want:  append(append([]string(nil), defaultBlocks...), "own"),
+        },
+        {
+            name: "reordered blocks keep their place",
+            stack: append([]PromptEntry{block(BlockPersona, BlockPersona), own},
+                defaultStack()[:len(defaultBlocks)-1]...),
+            want: append([]string{BlockPersona, "own"}, defaultBlocks[:len(defaultBlocks)-1]...),
+        },
+        {
+            name: "duplicate and unknown blocks are dropped",
+            stack: append(defaultStack(), block(BlockName, BlockName), block("mood", "mood")),
+            want:  defaultBlocks,
+        },
+        {
+            name:  "block IDs are reset to the block name",
+            stack: append([]PromptEntry{block(BlockScenario, "abc123")}, own),
+            want: []string{
+                BlockSystemPrompt, BlockName, BlockDescription, BlockPersonality, BlockExampleDialogue, BlockPersona,
+                BlockScenario, "own",
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            prompts := &UserPrompts{Prompts: tt.stack}
+            prompts.normalizeStack()
+            if got := stackIDs(prompts.Prompts); !equalStrings(got, tt.want) {
+                t.Errorf("stack = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestMovePrompt(t *testing.T) {
+    tests := []struct {
+        name     string
+        id       string
+        position int
+        want     []string
+        wantErr  error
+    }{
+        {"to the top", "own", 1, []string{"own", "system_prompt", "name", "description", "personality", "scenario", "example_dialogue", "persona"}, nil},
+        {"to the end", "name", 0, []string{"system_prompt", "description", "personality", "scenario", "example_dialogue", "persona", "own", "name"}, nil},
+        {"past the end", "name", 99, []string{"system_prompt", "description", "personality", "scenario", "example_dialogue", "persona", "own", "name"}, nil},
+        {"down one", "system_prompt", 2, []string{"name", "system_prompt", "description", "personality", "scenario", "example_dialogue", "persona", "own"}, nil},
+        {"block by name ignoring case", "PERSONA", 1, []string{"persona", "system_prompt", "name", "description", "personality", "scenario", "example_dialogue", "own"}, nil},
+        {"same place", "scenario", 5, []string{"system_prompt", "name", "description", "personality", "scenario", "example_dialogue", "persona", "own"}, nil},
+        {"unknown entry", "nope", 1, nil, ErrPromptNotFound},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pm := NewPromptManager(nil)
+            pm.mu.Lock()
+            prompts := pm.getOrCreatePrompts("u")
+            prompts.Prompts = append(prompts.Prompts, PromptEntry{ID: "own", Role: "system", Content: "Be brief", Enabled: true})
+            pm.mu.Unlock()
+
+            _, err := pm.MovePrompt("u", tt.id, tt.position)
+            if err != tt.wantErr {
+                t.Fatalf("MovePrompt error = %v, want %v", err, tt.wantErr)
+            }
+            if err != nil {
+                return
+            }
+            if got := stackIDs(pm.PromptStack("u")); !equalStrings(got, tt.want) {
+                t.Errorf("stack = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestImportPromptsNormalizesStack(t *testing.T) {
+    pm := NewPromptManager(nil)
+    data := []byte(`{"Name": "Alice", "Prompts": [
+        {"ID": "own", "Role": "user", "Content": "Hi", "Enabled": true},
+        {"ID": "x", "Block": "name", "Role": "system", "Enabled": true},
+        {"ID": "y", "Block": "name", "Role": "system", "Enabled": true}
+    ]}`)
+    if err := pm.ImportPrompts("u", data); err != nil {
+        t.Fatalf("ImportPrompts: %v", err)
+    }
+    want := []string{BlockSystemPrompt, BlockDescription, BlockPersonality, BlockScenario, BlockExampleDialogue, BlockPersona, "own", BlockName}
+    if got := stackIDs(pm.PromptStack("u")); !equalStrings(got, want) {
+        t.Errorf("stack = %q, want %q", got, want)
+    }
+}
+
+func TestDefaultsAppliedAtBuildTime(t *testing.T) {
+    definitions := func(fields map[string]string) func(pm *PromptManager) {
+        return func(pm *PromptManager) { pm.UpdateDefinitions("u", fields) }
+    }
+    disable := func(block string) func(pm *PromptManager) {
+        return func(pm *PromptManager) {
+            enabled := false
+            if _, err := pm.EditPrompt("u", block, nil, nil, &enabled); err != nil {
+                t.Fatalf("EditPrompt: %v", err)
+            }
+        }
+    }
+    tests := []struct {
+        name            string
+        setup           func(pm *PromptManager)
+        wantSystem      bool
+        wantPersonality bool
+    }{
+        {"new user", nil, true, true},
+        {"scenario preset", definitions(map[string]string{"scenario": "A forest"}), true, true},
+        {"description", definitions(map[string]string{"description": "A witch"}), false, false},
+        {"personality", definitions(map[string]string{"personality": "Curious"}), false, false},
+        {"example dialogue", definitions(map[string]string{"example_dialogue": "<START>"}), false, false},
+        {"first message", func(pm *PromptManager) { pm.SetFirstMessage("u", "Hello!") }, false, false},
+        {"named character", func(pm *PromptManager) {
+            if _, _, err := pm.CreateCharacter("u", "Alice"); err != nil {
+                t.Fatalf("CreateCharacter: %v", err)
+            }
+        }, false, false},
+        {"system prompt block disabled", disable(BlockSystemPrompt), false, true},
+        {"personality block disabled", disable(BlockPersonality), true, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            store := NewMemoryPromptStore()
+            pm := NewPromptManager(store)
+            if tt.setup != nil {
+                tt.setup(pm)
+            }
+
+            hasSystem, hasPersonality := false, false
+            for _, msg := range pm.BuildPromptList("u") {
+                switch msg.Content {
+                case templates.GetDefaultSystemPrompt():
+                    hasSystem = true
+                case blockLabels[BlockPersonality] + templates.GetDefaultPersonality():
+                    hasPersonality = true
+                }
+            }
+            if hasSystem != tt.wantSystem {
+                t.Errorf("default system prompt sent = %v, want %v", hasSystem, tt.wantSystem)
+            }
+            if hasPersonality != tt.wantPersonality {
+                t.Errorf("default personality sent = %v, want %v", hasPersonality, tt.wantPersonality)
+            }
+
+            // The defaults are never saved
+            pm.mu.Lock()
+            pm.persist("u")
+            pm.mu.Unlock()
+            if saved, err := store.Load("u"); err == nil {
+                if saved.Personality == templates.GetDefaultPersonality() {
+                    t.Error("default personality was saved")
+                }
+                for _, entry := range saved.Prompts {
+                    if entry.Content == templates.GetDefaultSystemPrompt() {
+                        t.Error("default system prompt was saved")
+                    }
+                }
+            }
+        })
+    }
+}
+
+func TestDefaultSystemPromptFollowsTheStack(t *testing.T) {
+    pm := NewPromptManager(nil)
+    if _, err := pm.AddPrompt("u", "system", "Be brief", 1); err != nil {
+        t.Fatalf("AddPrompt: %v", err)
+    }
+    if _, err := pm.MovePrompt("u", BlockSystemPrompt, 0); err != nil {
+        t.Fatalf("MovePrompt: %v", err)
+    }
+
+    messages := pm.BuildPromptList("u")
+    if len(messages) < 2 || messages[0].Content != "Be brief" {
+        t.Fatalf("stack starts with %+v, want the user's own prompt", messages)
+    }
+    if last := messages[len(messages)-1]; last.Content != templates.GetDefaultSystemPrompt() {
+        t.Errorf("stack ends with %q, want the default system prompt", last.Content)
+    }
+}
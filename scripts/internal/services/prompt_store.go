@@ -0,0 +1,116 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "sync"
+)
+
+var ErrPromptsNotFound = errors.New("prompts not found")
+
+// PromptStore persists user prompt definitions.
+type PromptStore interface {
+    Load(userID string) (*UserPrompts, error)
+    Save(userID string, prompts *UserPrompts) error
+    Delete(userID string) error
+    List() ([]string, error)
+}
+
+// FilePromptStore keeps one JSON file per user in a directory.
+type FilePromptStore struct {
+    files *jsonDir
+}
+
+func NewFilePromptStore(dir string) (*FilePromptStore, error) {
+    files, err := newJSONDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    return &FilePromptStore{files: files}, nil
+}
+
+func (s *FilePromptStore) Load(userID string) (*UserPrompts, error) {
+    var prompts UserPrompts
+    if err := s.files.load(userID, &prompts); err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, ErrPromptsNotFound
+        }
+        return nil, fmt.Errorf("error reading prompts: %v", err)
+    }
+    return &prompts, nil
+}
+
+func (s *FilePromptStore) Save(userID string, prompts *UserPrompts) error {
+    if err := s.files.save(userID, prompts); err != nil {
+        return fmt.Errorf("error writing prompts: %v", err)
+    }
+    return nil
+}
+
+func (s *FilePromptStore) Delete(userID string) error {
+    if err := s.files.delete(userID); err != nil {
+        return fmt.Errorf("error deleting prompts: %v", err)
+    }
+    return nil
+}
+
+func (s *FilePromptStore) List() ([]string, error) {
+    userIDs, err := s.files.list()
+    if err != nil {
+        return nil, fmt.Errorf("error listing prompts: %v", err)
+    }
+    return userIDs, nil
+}
+
+// MemoryPromptStore keeps prompt definitions in memory.
+type MemoryPromptStore struct {
+    prompts map[string]*UserPrompts
+    mu      sync.RWMutex
+}
+
+func NewMemoryPromptStore() *MemoryPromptStore {
+    return &MemoryPromptStore{
+        prompts: make(map[string]*UserPrompts),
+    }
+}
+
+func (s *MemoryPromptStore) Load(userID string) (*UserPrompts, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    prompts, exists := s.prompts[userID]
+    if !exists {
+        return nil, ErrPromptsNotFound
+    }
+    return prompts.clone(), nil
+}
+
+func (s *MemoryPromptStore) Save(userID string, prompts *UserPrompts) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.prompts[userID] = prompts.clone()
+    return nil
+}
+
+func (s *MemoryPromptStore) Delete(userID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.prompts, userID)
+    return nil
+}
+
+func (s *MemoryPromptStore) List() ([]string, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    userIDs := make([]string, 0, len(s.prompts))
+    for userID := range s.prompts {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+    return userIDs, nil
+}
@@ -1,10 +1,15 @@
 package services
 
 import (
+    "bufio"
     "bytes"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
+    "log"
     "net/http"
+    "strings"
     "sync"
     "time"
 )
@@ -14,7 +19,12 @@ type ProxyClient struct {
     password    string
     client      *http.Client
     userConfigs map[string]*UserConfig
+    store       ConfigStore
     mu          sync.RWMutex
+    // instruct, when set, renders requests as text completions sent to
+    // completionsURL.
+    instruct       *InstructTemplate
+    completionsURL string
 }
 
 type UserConfig struct {
@@ -27,7 +37,12 @@ type UserConfig struct {
     TopP             float64
 }
 
-func NewProxyClient(proxyURL, password string) *ProxyClient {
+// NewProxyClient creates a proxy client whose per-user settings are kept in
+// store. A nil store keeps settings in memory only.
+func NewProxyClient(proxyURL, password string, store ConfigStore) *ProxyClient {
+    if store == nil {
+        store = NewMemoryConfigStore()
+    }
     return &ProxyClient{
         proxyURL: proxyURL,
         password: password,
@@ -35,31 +50,21 @@ func NewProxyClient(proxyURL, password string) *ProxyClient {
             Timeout: time.Second * 60,
         },
         userConfigs: make(map[string]*UserConfig),
+        store:       store,
     }
 }
 
 func (pc *ProxyClient) SendRequest(userID string, messages []Message) (string, error) {
-    pc.mu.RLock()
-    config := pc.getUserConfig(userID)
-    pc.mu.RUnlock()
-
-    payload := map[string]interface{}{
-        "messages":          messages,
-        "model":            config.Model,
-        "temperature":      config.Temperature,
-        "max_tokens":       config.MaxTokens,
-        "stream":           config.Stream,
-        "presence_penalty": config.PresencePenalty,
-        "frequency_penalty": config.FrequencyPenalty,
-        "top_p":            config.TopP,
-    }
-
-    jsonData, err := json.Marshal(payload)
+    jsonData, err := json.Marshal(pc.Payload(userID, messages))
     if err != nil {
         return "", fmt.Errorf("error marshaling request: %v", err)
     }
 
-    req, err := http.NewRequest("POST", pc.proxyURL, bytes.NewBuffer(jsonData))
+    url := pc.proxyURL
+    if pc.instruct != nil {
+        url = pc.completionsURL
+    }
+    req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
     if err != nil {
         return "", fmt.Errorf("error creating request: %v", err)
     }
@@ -73,6 +78,10 @@ func (pc *ProxyClient) SendRequest(userID string, messages []Message) (string, e
     }
     defer resp.Body.Close()
 
+    if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+        return readStream(resp.Body)
+    }
+
     var result map[string]interface{}
     if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
         return "", fmt.Errorf("error decoding response: %v", err)
@@ -81,12 +90,91 @@ func (pc *ProxyClient) SendRequest(userID string, messages []Message) (string, e
     return extractResponse(result)
 }
 
+// readStream collects a streamed response, sent when the user's Stream
+// setting is on, into the full reply. Chat completions stream the reply as
+// message deltas and text completions as pieces of text.
+func readStream(body io.Reader) (string, error) {
+    var sb strings.Builder
+    scanner := bufio.NewScanner(body)
+    scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        data, ok := strings.CutPrefix(scanner.Text(), "data:")
+        if !ok {
+            continue
+        }
+        data = strings.TrimSpace(data)
+        if data == "[DONE]" {
+            break
+        }
+
+        var chunk map[string]interface{}
+        if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+            return "", fmt.Errorf("error decoding stream: %v", err)
+        }
+        choices, ok := chunk["choices"].([]interface{})
+        if !ok || len(choices) == 0 {
+            continue
+        }
+        choice, ok := choices[0].(map[string]interface{})
+        if !ok {
+            return "", fmt.Errorf("invalid choice format")
+        }
+        if text, ok := choice["text"].(string); ok {
+            sb.WriteString(text)
+        } else if delta, ok := choice["delta"].(map[string]interface{}); ok {
+            content, _ := delta["content"].(string)
+            sb.WriteString(content)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return "", fmt.Errorf("error reading stream: %v", err)
+    }
+    return strings.TrimSpace(sb.String()), nil
+}
+
+// Payload returns the request body SendRequest sends for messages, with
+// the user's sampling settings.
+func (pc *ProxyClient) Payload(userID string, messages []Message) map[string]interface{} {
+    pc.mu.Lock()
+    config := *pc.getUserConfig(userID)
+    pc.mu.Unlock()
+
+    payload := map[string]interface{}{
+        "messages":          messages,
+        "model":            config.Model,
+        "temperature":      config.Temperature,
+        "max_tokens":       config.MaxTokens,
+        "stream":           config.Stream,
+        "presence_penalty": config.PresencePenalty,
+        "frequency_penalty": config.FrequencyPenalty,
+        "top_p":            config.TopP,
+    }
+    if pc.instruct != nil {
+        delete(payload, "messages")
+        payload["prompt"] = pc.instruct.Render(messages)
+        payload["stop"] = pc.instruct.StopSequences()
+    }
+    return payload
+}
+
+// UseInstructTemplate sends every request as a text completion rendered
+// with template, to url or, when url is empty, to the completions endpoint
+// next to the proxy URL. Call it before the client is used.
+func (pc *ProxyClient) UseInstructTemplate(template InstructTemplate, url string) {
+    if url == "" {
+        url = completionsURL(pc.proxyURL)
+    }
+    pc.instruct = &template
+    pc.completionsURL = url
+}
+
 func (pc *ProxyClient) SwitchModel(userID, model string) {
     pc.mu.Lock()
     defer pc.mu.Unlock()
     
     config := pc.getUserConfig(userID)
     config.Model = model
+    pc.persist(userID)
 }
 
 func (pc *ProxyClient) SetTemperature(userID string, temp float64) {
@@ -95,6 +183,7 @@ func (pc *ProxyClient) SetTemperature(userID string, temp float64) {
     
     config := pc.getUserConfig(userID)
     config.Temperature = temp
+    pc.persist(userID)
 }
 
 func (pc *ProxyClient) ToggleStream(userID string) bool {
@@ -103,12 +192,74 @@ func (pc *ProxyClient) ToggleStream(userID string) bool {
     
     config := pc.getUserConfig(userID)
     config.Stream = !config.Stream
+    pc.persist(userID)
     return config.Stream
 }
 
+// SetUserConfig replaces the settings for userID.
+func (pc *ProxyClient) SetUserConfig(userID string, config *UserConfig) error {
+    pc.mu.Lock()
+    defer pc.mu.Unlock()
+
+    if err := pc.store.Save(userID, config); err != nil {
+        return err
+    }
+    copied := *config
+    pc.userConfigs[userID] = &copied
+    return nil
+}
+
+// DeleteUser removes the settings stored for userID and reports whether
+// there were any.
+func (pc *ProxyClient) DeleteUser(userID string) (bool, error) {
+    pc.mu.Lock()
+    defer pc.mu.Unlock()
+
+    _, existed := pc.userConfigs[userID]
+    if _, err := pc.store.Load(userID); !errors.Is(err, ErrConfigNotFound) {
+        existed = true
+    }
+    if err := pc.store.Delete(userID); err != nil {
+        return existed, err
+    }
+    delete(pc.userConfigs, userID)
+    return existed, nil
+}
+
+// UserIDs returns every user with settings in memory or in the store.
+func (pc *ProxyClient) UserIDs() ([]string, error) {
+    pc.mu.RLock()
+    defer pc.mu.RUnlock()
+
+    stored, err := pc.store.List()
+    if err != nil {
+        return nil, err
+    }
+    return mergeUserIDs(stored, pc.userConfigs), nil
+}
+
+// snapshot returns a copy of the settings for userID.
+func (pc *ProxyClient) snapshot(userID string) *UserConfig {
+    pc.mu.Lock()
+    defer pc.mu.Unlock()
+
+    copied := *pc.getUserConfig(userID)
+    return &copied
+}
+
+// getUserConfig returns the settings for userID, loading them from the store
+// the first time they are needed. Callers must hold pc.mu.
 func (pc *ProxyClient) getUserConfig(userID string) *UserConfig {
     config, exists := pc.userConfigs[userID]
-    if !exists {
+    if exists {
+        return config
+    }
+
+    config, err := pc.store.Load(userID)
+    if err != nil {
+        if !errors.Is(err, ErrConfigNotFound) {
+            log.Printf("Error loading config for %s: %v", userID, err)
+        }
         config = &UserConfig{
             Model:            "gpt-4-turbo",
             Temperature:      0.83,
@@ -118,11 +269,23 @@ func (pc *ProxyClient) getUserConfig(userID string) *UserConfig {
             FrequencyPenalty: 0.6,
             TopP:             0.99,
         }
-        pc.userConfigs[userID] = config
     }
+    pc.userConfigs[userID] = config
     return config
 }
 
+// persist flushes the settings for userID to the store. Callers must hold
+// pc.mu.
+func (pc *ProxyClient) persist(userID string) {
+    config, exists := pc.userConfigs[userID]
+    if !exists {
+        return
+    }
+    if err := pc.store.Save(userID, config); err != nil {
+        log.Printf("Error saving config for %s: %v", userID, err)
+    }
+}
+
 func extractResponse(result map[string]interface{}) (string, error) {
     choices, ok := result["choices"].([]interface{})
     if !ok || len(choices) == 0 {
@@ -134,6 +297,11 @@ func extractResponse(result map[string]interface{}) (string, error) {
         return "", fmt.Errorf("invalid choice format")
     }
 
+    // Text completions answer with the text itself
+    if text, ok := choice["text"].(string); ok {
+        return strings.TrimSpace(text), nil
+    }
+
     message, ok := choice["message"].(map[string]interface{})
     if !ok {
         return "", fmt.Errorf("invalid message format")
@@ -0,0 +1,72 @@
+package services
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestReadStream(t *testing.T) {
+    tests := []struct {
+        name    string
+        body    string
+        want    string
+        wantErr bool
+    }{
+        {
+            name: "chat deltas",
+            body: "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+                "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+                "data: {\"choices\":[{\"delta\":{\"content\":\", Bob\"}}]}\n\n" +
+                "data: [DONE]\n\n",
+            want: "Hello, Bob",
+        },
+        {
+            name: "text completion",
+            body: "data: {\"choices\":[{\"text\":\" Hi\"}]}\n" +
+                "data:{\"choices\":[{\"text\":\" there \"}]}\n" +
+                "data: [DONE]\n",
+            want: "Hi there",
+        },
+        {
+            name: "comments, empty choices and anything after done",
+            body: ": keep-alive\n" +
+                "data: {\"choices\":[]}\n" +
+                "data: {\"choices\":[{\"text\":\"Yes\"}]}\n" +
+                "data: [DONE]\n" +
+                "data: {\"choices\":[{\"text\":\"No\"}]}\n",
+            want: "Yes",
+        },
+        {
+            name: "stream ends without done",
+            body: "data: {\"choices\":[{\"text\":\"Cut\"}]}\n",
+            want: "Cut",
+        },
+        {
+            name:    "invalid chunk",
+            body:    "data: {\"choices\":\n",
+            wantErr: true,
+        },
+        {
+            name:    "invalid choice",
+            body:    "data: {\"choices\":[\"text\"]}\n",
+            wantErr: true,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := readStream(strings.NewReader(tt.body))
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatalf("readStream = %q, want an error", got)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("readStream: %v", err)
+            }
+            if got != tt.want {
+                t.Errorf("readStream = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
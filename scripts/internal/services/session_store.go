@@ -0,0 +1,231 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+)
+
+var (
+    ErrSessionNotFound = errors.New("session not found")
+    ErrChatNotFound    = errors.New("saved chat not found")
+)
+
+// SessionStore persists chat sessions so they survive restarts. Besides the
+// active session of each user it keeps the chats users saved explicitly.
+// SaveChat returns ErrChatNotOwned instead of overwriting a chat with the
+// same ID that belongs to another user.
+type SessionStore interface {
+    Load(userID string) (*ChatSession, error)
+    Save(userID string, session *ChatSession) error
+    Delete(userID string) error
+    List() ([]string, error)
+
+    SaveChat(chat *SavedChat) error
+    LoadChat(chatID string) (*SavedChat, error)
+    ListChats(ownerID string) ([]*SavedChat, error)
+    DeleteChat(chatID string) error
+}
+
+// FileSessionStore keeps one JSON file per user in a directory, and saved
+// chats one file per chat in its "saved" subdirectory.
+type FileSessionStore struct {
+    files *jsonDir
+    saved *jsonDir
+}
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+    files, err := newJSONDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    saved, err := newJSONDir(filepath.Join(dir, "saved"))
+    if err != nil {
+        return nil, err
+    }
+    return &FileSessionStore{files: files, saved: saved}, nil
+}
+
+func (s *FileSessionStore) Load(userID string) (*ChatSession, error) {
+    var session ChatSession
+    if err := s.files.load(userID, &session); err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, ErrSessionNotFound
+        }
+        return nil, fmt.Errorf("error reading session: %v", err)
+    }
+    return &session, nil
+}
+
+func (s *FileSessionStore) Save(userID string, session *ChatSession) error {
+    if err := s.files.save(userID, session); err != nil {
+        return fmt.Errorf("error writing session: %v", err)
+    }
+    return nil
+}
+
+func (s *FileSessionStore) Delete(userID string) error {
+    if err := s.files.delete(userID); err != nil {
+        return fmt.Errorf("error deleting session: %v", err)
+    }
+    return nil
+}
+
+func (s *FileSessionStore) List() ([]string, error) {
+    userIDs, err := s.files.list()
+    if err != nil {
+        return nil, fmt.Errorf("error listing sessions: %v", err)
+    }
+    return userIDs, nil
+}
+
+func (s *FileSessionStore) SaveChat(chat *SavedChat) error {
+    existing, err := s.LoadChat(chat.ID)
+    if err == nil && existing.OwnerID != chat.OwnerID {
+        return ErrChatNotOwned
+    }
+    if err != nil && !errors.Is(err, ErrChatNotFound) {
+        return err
+    }
+    if err := s.saved.save(chat.ID, chat); err != nil {
+        return fmt.Errorf("error writing saved chat: %v", err)
+    }
+    return nil
+}
+
+func (s *FileSessionStore) LoadChat(chatID string) (*SavedChat, error) {
+    var chat SavedChat
+    if err := s.saved.load(chatID, &chat); err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, ErrChatNotFound
+        }
+        return nil, fmt.Errorf("error reading saved chat: %v", err)
+    }
+    return &chat, nil
+}
+
+func (s *FileSessionStore) ListChats(ownerID string) ([]*SavedChat, error) {
+    chatIDs, err := s.saved.list()
+    if err != nil {
+        return nil, fmt.Errorf("error listing saved chats: %v", err)
+    }
+
+    var chats []*SavedChat
+    for _, chatID := range chatIDs {
+        chat, err := s.LoadChat(chatID)
+        if err != nil {
+            return nil, err
+        }
+        if chat.OwnerID == ownerID {
+            chats = append(chats, chat)
+        }
+    }
+    return chats, nil
+}
+
+func (s *FileSessionStore) DeleteChat(chatID string) error {
+    if err := s.saved.delete(chatID); err != nil {
+        return fmt.Errorf("error deleting saved chat: %v", err)
+    }
+    return nil
+}
+
+// MemorySessionStore keeps sessions in memory. It is meant for tests and
+// for running without a data directory.
+type MemorySessionStore struct {
+    sessions map[string]*ChatSession
+    saved    map[string]*SavedChat
+    mu       sync.RWMutex
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+    return &MemorySessionStore{
+        sessions: make(map[string]*ChatSession),
+        saved:    make(map[string]*SavedChat),
+    }
+}
+
+func (s *MemorySessionStore) Load(userID string) (*ChatSession, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    session, exists := s.sessions[userID]
+    if !exists {
+        return nil, ErrSessionNotFound
+    }
+    return session.clone(), nil
+}
+
+func (s *MemorySessionStore) Save(userID string, session *ChatSession) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.sessions[userID] = session.clone()
+    return nil
+}
+
+func (s *MemorySessionStore) Delete(userID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.sessions, userID)
+    return nil
+}
+
+func (s *MemorySessionStore) List() ([]string, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    userIDs := make([]string, 0, len(s.sessions))
+    for userID := range s.sessions {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+    return userIDs, nil
+}
+
+func (s *MemorySessionStore) SaveChat(chat *SavedChat) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if existing, ok := s.saved[chat.ID]; ok && existing.OwnerID != chat.OwnerID {
+        return ErrChatNotOwned
+    }
+    s.saved[chat.ID] = chat.clone()
+    return nil
+}
+
+func (s *MemorySessionStore) LoadChat(chatID string) (*SavedChat, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    chat, exists := s.saved[chatID]
+    if !exists {
+        return nil, ErrChatNotFound
+    }
+    return chat.clone(), nil
+}
+
+func (s *MemorySessionStore) ListChats(ownerID string) ([]*SavedChat, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    var chats []*SavedChat
+    for _, chat := range s.saved {
+        if chat.OwnerID == ownerID {
+            chats = append(chats, chat.clone())
+        }
+    }
+    return chats, nil
+}
+
+func (s *MemorySessionStore) DeleteChat(chatID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.saved, chatID)
+    return nil
+}
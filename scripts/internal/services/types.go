@@ -1,10 +1,37 @@
 package services
 
-import "time"
+import (
+    "sort"
+    "time"
+)
 
 type Message struct {
     ID        string    `json:"id"`
     Role      string    `json:"role"`
     Content   string    `json:"content"`
     Timestamp time.Time `json:"timestamp"`
+    // Speaker names the group chat member who wrote an assistant message.
+    Speaker   string    `json:"speaker,omitempty"`
+    // Section names the part of a prepared context the message belongs
+    // to. It is never sent or stored.
+    Section   string    `json:"-"`
+}
+
+// mergeUserIDs combines the user IDs known to a store with the keys of an
+// in-memory map, sorted and without duplicates.
+func mergeUserIDs[V any](stored []string, memory map[string]V) []string {
+    seen := make(map[string]bool, len(stored)+len(memory))
+    for _, userID := range stored {
+        seen[userID] = true
+    }
+    for userID := range memory {
+        seen[userID] = true
+    }
+
+    userIDs := make([]string, 0, len(seen))
+    for userID := range seen {
+        userIDs = append(userIDs, userID)
+    }
+    sort.Strings(userIDs)
+    return userIDs
 }